@@ -0,0 +1,236 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import "fmt"
+
+// Clone returns a deep copy of expr. The copy shares no nodes with expr,
+// including the steps and node tests of location paths, so either of them
+// can be modified without affecting the other.
+func Clone(expr Expr) Expr {
+	return cloneExpr(expr)
+}
+
+func cloneNode(node TreeNode) TreeNode {
+	switch n := node.(type) {
+	case nil:
+		return nil
+	case Expr:
+		return cloneExpr(n)
+	case *Step:
+		return cloneStep(n)
+	case NodeTest:
+		return cloneNodeTest(n)
+	case *SequenceType:
+		return cloneSequenceType(n)
+	case *Param:
+		return cloneParam(n)
+	case *MapEntry:
+		return cloneMapEntry(n)
+	case ItemType:
+		return cloneItemType(n)
+	}
+	panic(fmt.Sprintf("xpathparser: unexpected node type %T", node))
+}
+
+func cloneExpr(expr Expr) Expr {
+	switch e := expr.(type) {
+	case nil:
+		return nil
+	case *LocationPath:
+		return cloneLocationPath(e)
+	case *FilterExpr:
+		return &FilterExpr{cloneExpr(e.Expr), cloneExprs(e.Predicates), e.Span}
+	case *PathExpr:
+		return &PathExpr{cloneExpr(e.Filter), cloneLocationPath(e.LocationPath), e.Span}
+	case *SlashExpr:
+		return &SlashExpr{cloneExpr(e.LHS), cloneExpr(e.RHS), e.Span}
+	case *BinaryExpr:
+		return &BinaryExpr{cloneExpr(e.LHS), e.Op, cloneExpr(e.RHS), e.Span}
+	case *NegateExpr:
+		return &NegateExpr{cloneExpr(e.Expr), e.Span}
+	case *UnaryPlusExpr:
+		return &UnaryPlusExpr{cloneExpr(e.Expr), e.Span}
+	case *VarRef:
+		clone := *e
+		return &clone
+	case *BadExpr:
+		clone := *e
+		return &clone
+	case *FuncCall:
+		return &FuncCall{e.Prefix, e.Local, cloneExprs(e.Args), e.Span}
+	case *ForExpr:
+		return &ForExpr{e.Prefix, e.Local, cloneExpr(e.In), cloneExpr(e.Return), e.Span}
+	case *QuantifiedExpr:
+		return &QuantifiedExpr{e.Every, e.Prefix, e.Local, cloneExpr(e.In), cloneExpr(e.Satisfies), e.Span}
+	case *IfExpr:
+		return &IfExpr{cloneExpr(e.Cond), cloneExpr(e.Then), cloneExpr(e.Else), e.Span}
+	case *SequenceExpr:
+		return &SequenceExpr{cloneExprs(e.Items), e.Span}
+	case *InstanceOfExpr:
+		return &InstanceOfExpr{cloneExpr(e.Expr), cloneSequenceType(e.Type), e.Span}
+	case *TreatExpr:
+		return &TreatExpr{cloneExpr(e.Expr), cloneSequenceType(e.Type), e.Span}
+	case *CastableExpr:
+		return &CastableExpr{cloneExpr(e.Expr), cloneSequenceType(e.Type), e.Span}
+	case *CastExpr:
+		return &CastExpr{cloneExpr(e.Expr), cloneSequenceType(e.Type), e.Span}
+	case *LetExpr:
+		return &LetExpr{e.Prefix, e.Local, cloneExpr(e.Expr), cloneExpr(e.Return), e.Span}
+	case *InlineFunctionExpr:
+		var params []*Param
+		if e.Params != nil {
+			params = make([]*Param, len(e.Params))
+			for i, param := range e.Params {
+				params[i] = cloneParam(param)
+			}
+		}
+		return &InlineFunctionExpr{params, cloneSequenceType(e.Type), cloneExpr(e.Body), e.Span}
+	case *NamedFunctionRef:
+		clone := *e
+		return &clone
+	case *DynamicCallExpr:
+		return &DynamicCallExpr{cloneExpr(e.Func), cloneExprs(e.Args), e.Span}
+	case *SimpleMapExpr:
+		return &SimpleMapExpr{cloneExpr(e.LHS), cloneExpr(e.RHS), e.Span}
+	case *MapConstructor:
+		var entries []*MapEntry
+		if e.Entries != nil {
+			entries = make([]*MapEntry, len(e.Entries))
+			for i, entry := range e.Entries {
+				entries[i] = cloneMapEntry(entry)
+			}
+		}
+		return &MapConstructor{entries, e.Span}
+	case *ArrayConstructor:
+		return &ArrayConstructor{e.Curly, cloneExprs(e.Members), e.Span}
+	case *LookupExpr:
+		return &LookupExpr{cloneExpr(e.Expr), cloneExpr(e.Key), e.Span}
+	case *ArrowExpr:
+		return &ArrowExpr{cloneExpr(e.Expr), cloneExpr(e.Call), e.Span}
+	case *Number:
+		return &Number{e.Value, e.Span}
+	case *String:
+		return &String{e.Value, e.Span}
+	}
+	panic(fmt.Sprintf("xpathparser: unexpected expr type %T", expr))
+}
+
+func cloneExprs(exprs []Expr) []Expr {
+	if exprs == nil {
+		return nil
+	}
+	clone := make([]Expr, len(exprs))
+	for i, expr := range exprs {
+		clone[i] = cloneExpr(expr)
+	}
+	return clone
+}
+
+func cloneLocationPath(lp *LocationPath) *LocationPath {
+	if lp == nil {
+		return nil
+	}
+	var steps []*Step
+	if lp.Steps != nil {
+		steps = make([]*Step, len(lp.Steps))
+		for i, step := range lp.Steps {
+			steps[i] = cloneStep(step)
+		}
+	}
+	return &LocationPath{lp.Abs, steps, lp.Span}
+}
+
+func cloneStep(s *Step) *Step {
+	if s == nil {
+		return nil
+	}
+	return &Step{s.Axis, cloneNodeTest(s.NodeTest), cloneExprs(s.Predicates), s.Span}
+}
+
+func cloneNodeTest(nt NodeTest) NodeTest {
+	switch nt := nt.(type) {
+	case *NameTest:
+		clone := *nt
+		return &clone
+	case *NodeTypeTest:
+		clone := *nt
+		return &clone
+	case *PITest:
+		clone := *nt
+		return &clone
+	case *KindTest:
+		return cloneKindTest(nt)
+	}
+	return nt
+}
+
+func cloneSequenceType(t *SequenceType) *SequenceType {
+	if t == nil {
+		return nil
+	}
+	return &SequenceType{cloneItemType(t.ItemType), t.Occurrence, t.Span}
+}
+
+func cloneParam(p *Param) *Param {
+	if p == nil {
+		return nil
+	}
+	return &Param{p.Prefix, p.Local, cloneSequenceType(p.Type), p.Span}
+}
+
+func cloneMapEntry(e *MapEntry) *MapEntry {
+	if e == nil {
+		return nil
+	}
+	return &MapEntry{cloneExpr(e.Key), cloneExpr(e.Value), e.Span}
+}
+
+func cloneItemType(it ItemType) ItemType {
+	switch it := it.(type) {
+	case *AtomicType:
+		clone := *it
+		return &clone
+	case *AnyItem:
+		clone := *it
+		return &clone
+	case *NodeTypeTest:
+		clone := *it
+		return &clone
+	case *PITest:
+		clone := *it
+		return &clone
+	case *KindTest:
+		return cloneKindTest(it)
+	case *FunctionTest:
+		var paramTypes []*SequenceType
+		if it.ParamTypes != nil {
+			paramTypes = make([]*SequenceType, len(it.ParamTypes))
+			for i, t := range it.ParamTypes {
+				paramTypes[i] = cloneSequenceType(t)
+			}
+		}
+		return &FunctionTest{paramTypes, cloneSequenceType(it.ReturnType), it.Span}
+	case *MapTest:
+		var keyType *AtomicType
+		if it.KeyType != nil {
+			clone := *it.KeyType
+			keyType = &clone
+		}
+		return &MapTest{keyType, cloneSequenceType(it.ValueType), it.Span}
+	case *ArrayTest:
+		return &ArrayTest{cloneSequenceType(it.MemberType), it.Span}
+	}
+	return it
+}
+
+func cloneKindTest(kt *KindTest) *KindTest {
+	if kt == nil {
+		return nil
+	}
+	clone := *kt
+	clone.Element = cloneKindTest(kt.Element)
+	return &clone
+}
@@ -4,6 +4,7 @@
 
 /*
 Package xpathparser provides lexer and parser for XPath 1.0.
+XPath 2.0, 3.0 and 3.1 grammars are enabled with ParseOptions.Version.
 
 This Package parses given XPath expression to expression model.
 
@@ -12,5 +13,13 @@ An example of using this package:
 	expr := xpathparser.MustParse("(/a/b)[5]")
 	fmt.Println(expr)
 
+Every node of the expression model records its Span in the xpath. For this
+reason the nodes that used to be plain values are now pointers to structs:
+number and string literals are *Number and *String, whose Value holds the
+literal, and the tests node(), text() and comment(), processing-instruction()
+and item() are *NodeTypeTest, *PITest and *AnyItem. Code building or switching
+on Number(1), String("x"), Node or PITest("pi") must be changed accordingly,
+for example to &Number{Value: 1} and &NodeTypeTest{Type: Node}.
+
 */
 package xpathparser
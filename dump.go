@@ -0,0 +1,299 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Dump returns the tree of expr as indented S-expression, for debugging.
+// Each node is written as
+//
+//	(KIND DETAIL @SPAN CHILDREN...)
+//
+// where DETAIL is the operator, axis, name or value of the node, if any,
+// and SPAN is its source span as LINE:COLUMN-LINE:COLUMN, if known.
+// Children are listed in the order visited by Walk.
+func Dump(expr Expr) string {
+	buf := new(bytes.Buffer)
+	dumpSexpr(buf, expr, 0)
+	return buf.String()
+}
+
+// Fdump writes Dump(expr) to w.
+func Fdump(w io.Writer, expr Expr) error {
+	_, err := io.WriteString(w, Dump(expr))
+	return err
+}
+
+func dumpSexpr(buf *bytes.Buffer, n TreeNode, depth int) {
+	buf.WriteString("(")
+	buf.WriteString(strings.Join(dumpLabel(n), " "))
+	for _, child := range children(n) {
+		buf.WriteString("\n")
+		buf.WriteString(strings.Repeat("  ", depth+1))
+		dumpSexpr(buf, child, depth+1)
+	}
+	buf.WriteString(")")
+}
+
+// DumpDOT returns the tree of expr as Graphviz DOT graph, for debugging.
+// Nodes are labeled with the same details as in Dump, and edges of each
+// node are ordered as its children.
+func DumpDOT(expr Expr) string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("digraph xpath {\n")
+	buf.WriteString("\tordering=out;\n")
+	buf.WriteString("\tnode [shape=box];\n")
+	var id int
+	var dump func(n TreeNode) int
+	dump = func(n TreeNode) int {
+		nid := id
+		id++
+		label := dotEscaper.Replace(strings.Join(dumpLabel(n), "\n"))
+		fmt.Fprintf(buf, "\tn%d [label=\"%s\"];\n", nid, label)
+		for _, child := range children(n) {
+			cid := dump(child)
+			fmt.Fprintf(buf, "\tn%d -> n%d;\n", nid, cid)
+		}
+		return nid
+	}
+	dump(expr)
+	buf.WriteString("}\n")
+	return buf.String()
+}
+
+// dotEscaper escapes the text of DOT quoted string. DOT knows no escapes
+// other than these, so the remaining characters are written as they are.
+var dotEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// FdumpDOT writes DumpDOT(expr) to w.
+func FdumpDOT(w io.Writer, expr Expr) error {
+	_, err := io.WriteString(w, DumpDOT(expr))
+	return err
+}
+
+// children returns the children of n, in the order visited by Walk.
+func children(n TreeNode) []TreeNode {
+	var list []TreeNode
+	Inspect(n, func(child TreeNode) bool {
+		if child == n {
+			return true
+		}
+		if child != nil {
+			list = append(list, child)
+		}
+		return false
+	})
+	return list
+}
+
+// dumpLabel returns kind, detail and span of n. Missing details are omitted.
+func dumpLabel(n TreeNode) []string {
+	var label []string
+	switch n := n.(type) {
+	case Expr:
+		label = append(label, n.Kind().String())
+	case NodeTest:
+		label = append(label, n.Kind().String())
+	case *Step:
+		label = append(label, "Step")
+	case *SequenceType:
+		label = append(label, "SequenceType")
+	case *Param:
+		label = append(label, "Param")
+	case *MapEntry:
+		label = append(label, "MapEntry")
+	case *AtomicType:
+		label = append(label, "AtomicType")
+	case *AnyItem:
+		label = append(label, "AnyItem")
+	case *FunctionTest:
+		label = append(label, "FunctionTest")
+	case *MapTest:
+		label = append(label, "MapTest")
+	case *ArrayTest:
+		label = append(label, "ArrayTest")
+	}
+
+	switch n := n.(type) {
+	case *LocationPath:
+		if n.Abs {
+			label = append(label, "abs")
+		}
+	case *BinaryExpr:
+		label = append(label, n.Op.String())
+	case *VarRef:
+		label = append(label, n.String())
+	case *FuncCall:
+		label = append(label, qname(n.Prefix, n.Local))
+	case *ForExpr:
+		label = append(label, "$"+qname(n.Prefix, n.Local))
+	case *LetExpr:
+		label = append(label, "$"+qname(n.Prefix, n.Local))
+	case *Param:
+		label = append(label, "$"+qname(n.Prefix, n.Local))
+	case *NamedFunctionRef:
+		label = append(label, n.String())
+	case *ArrayConstructor:
+		if n.Curly {
+			label = append(label, "curly")
+		}
+	case *LookupExpr:
+		if n.Expr == nil {
+			label = append(label, "unary")
+		}
+		if n.Key == nil {
+			label = append(label, "*")
+		}
+	case *QuantifiedExpr:
+		if n.Every {
+			label = append(label, "every")
+		} else {
+			label = append(label, "some")
+		}
+		label = append(label, "$"+qname(n.Prefix, n.Local))
+	case *Number:
+		label = append(label, n.String())
+	case *String:
+		label = append(label, strconv.Quote(n.Value))
+	case *Step:
+		label = append(label, n.Axis.String())
+	case *NameTest:
+		label = append(label, n.String())
+	case *NodeTypeTest:
+		label = append(label, n.String())
+	case *PITest:
+		label = append(label, strconv.Quote(n.Target))
+	case *KindTest:
+		label = append(label, n.String())
+	case *SequenceType:
+		label = append(label, n.String())
+	case *AtomicType:
+		label = append(label, n.String())
+	case *FunctionTest:
+		label = append(label, n.String())
+	case *MapTest:
+		label = append(label, n.String())
+	case *ArrayTest:
+		label = append(label, n.String())
+	}
+
+	if span, ok := spanOf(n); ok && span != (Span{}) {
+		label = append(label, "@"+span.String())
+	}
+	return label
+}
+
+func qname(prefix, local string) string {
+	switch {
+	case prefix == "":
+		return local
+	case isBracedURI(prefix):
+		return prefix + local
+	}
+	return prefix + ":" + local
+}
+
+// isBracedURI tells whether prefix is the braced URI of EQName, such as
+// "Q{http://example.com}".
+func isBracedURI(prefix string) bool {
+	return strings.HasPrefix(prefix, "Q{")
+}
+
+// spanOf returns the span of n. It returns false, if n is nil.
+func spanOf(n TreeNode) (Span, bool) {
+	switch n := n.(type) {
+	case *LocationPath:
+		return n.Span, true
+	case *FilterExpr:
+		return n.Span, true
+	case *PathExpr:
+		return n.Span, true
+	case *SlashExpr:
+		return n.Span, true
+	case *BinaryExpr:
+		return n.Span, true
+	case *NegateExpr:
+		return n.Span, true
+	case *UnaryPlusExpr:
+		return n.Span, true
+	case *VarRef:
+		return n.Span, true
+	case *FuncCall:
+		return n.Span, true
+	case *Number:
+		return n.Span, true
+	case *String:
+		return n.Span, true
+	case *Step:
+		return n.Span, true
+	case *BadExpr:
+		return n.Span, true
+	case *ForExpr:
+		return n.Span, true
+	case *QuantifiedExpr:
+		return n.Span, true
+	case *IfExpr:
+		return n.Span, true
+	case *SequenceExpr:
+		return n.Span, true
+	case *InstanceOfExpr:
+		return n.Span, true
+	case *TreatExpr:
+		return n.Span, true
+	case *CastableExpr:
+		return n.Span, true
+	case *CastExpr:
+		return n.Span, true
+	case *LetExpr:
+		return n.Span, true
+	case *InlineFunctionExpr:
+		return n.Span, true
+	case *NamedFunctionRef:
+		return n.Span, true
+	case *DynamicCallExpr:
+		return n.Span, true
+	case *SimpleMapExpr:
+		return n.Span, true
+	case *MapConstructor:
+		return n.Span, true
+	case *ArrayConstructor:
+		return n.Span, true
+	case *LookupExpr:
+		return n.Span, true
+	case *ArrowExpr:
+		return n.Span, true
+	case *Param:
+		return n.Span, true
+	case *MapEntry:
+		return n.Span, true
+	case *NameTest:
+		return n.Span, true
+	case *NodeTypeTest:
+		return n.Span, true
+	case *PITest:
+		return n.Span, true
+	case *SequenceType:
+		return n.Span, true
+	case *AtomicType:
+		return n.Span, true
+	case *AnyItem:
+		return n.Span, true
+	case *KindTest:
+		return n.Span, true
+	case *FunctionTest:
+		return n.Span, true
+	case *MapTest:
+		return n.Span, true
+	case *ArrayTest:
+		return n.Span, true
+	}
+	return Span{}, false
+}
@@ -0,0 +1,65 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+func TestDump(t *testing.T) {
+	got := Dump(MustParse(`a | b = "c"`))
+	want := `(BinaryExpr = @1:1-1:12
+  (BinaryExpr | @1:1-1:6
+    (LocationPath @1:1-1:2
+      (Step child @1:1-1:2
+        (NameTest a @1:1-1:2)))
+    (LocationPath @1:5-1:6
+      (Step child @1:5-1:6
+        (NameTest b @1:5-1:6))))
+  (String "c" @1:9-1:12))`
+	if got != want {
+		t.Errorf("FAIL: got\n%s\nwant\n%s", got, want)
+	}
+
+	got = Dump(&FilterExpr{Expr: &FuncCall{Prefix: "ns", Local: "f"}, Predicates: []Expr{&Number{Value: 1}}})
+	want = `(FilterExpr
+  (FuncCall ns:f)
+  (Number 1))`
+	if got != want {
+		t.Errorf("FAIL: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestDumpDOT(t *testing.T) {
+	got := DumpDOT(MustParse(`/a[1]`))
+	want := `digraph xpath {
+	ordering=out;
+	node [shape=box];
+	n0 [label="LocationPath\nabs\n@1:1-1:6"];
+	n1 [label="Step\nchild\n@1:2-1:6"];
+	n2 [label="NameTest\na\n@1:2-1:3"];
+	n1 -> n2;
+	n3 [label="Number\n1\n@1:4-1:5"];
+	n1 -> n3;
+	n0 -> n1;
+}
+`
+	if got != want {
+		t.Errorf("FAIL: got\n%s\nwant\n%s", got, want)
+	}
+
+	got = DumpDOT(&String{Value: "a\"\\€\x00"})
+	want = `digraph xpath {
+	ordering=out;
+	node [shape=box];
+	n0 [label="String\n\"a\\\"\\\\€\\x00\""];
+}
+`
+	if got != want {
+		t.Errorf("FAIL: got\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,485 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/fnv"
+	"math"
+)
+
+// Equal tells whether given expressions are structurally equal.
+//
+// Spans are ignored, nil and empty slices are considered equal, and
+// Number of value NaN is equal to itself.
+func Equal(a, b Expr) bool {
+	switch a := a.(type) {
+	case nil:
+		return b == nil
+	case *LocationPath:
+		b, ok := b.(*LocationPath)
+		return ok && equalLocationPaths(a, b)
+	case *FilterExpr:
+		b, ok := b.(*FilterExpr)
+		return ok && Equal(a.Expr, b.Expr) && equalExprs(a.Predicates, b.Predicates)
+	case *PathExpr:
+		b, ok := b.(*PathExpr)
+		return ok && Equal(a.Filter, b.Filter) && equalLocationPaths(a.LocationPath, b.LocationPath)
+	case *SlashExpr:
+		b, ok := b.(*SlashExpr)
+		return ok && Equal(a.LHS, b.LHS) && Equal(a.RHS, b.RHS)
+	case *BinaryExpr:
+		b, ok := b.(*BinaryExpr)
+		return ok && a.Op == b.Op && Equal(a.LHS, b.LHS) && Equal(a.RHS, b.RHS)
+	case *NegateExpr:
+		b, ok := b.(*NegateExpr)
+		return ok && Equal(a.Expr, b.Expr)
+	case *UnaryPlusExpr:
+		b, ok := b.(*UnaryPlusExpr)
+		return ok && Equal(a.Expr, b.Expr)
+	case *VarRef:
+		b, ok := b.(*VarRef)
+		return ok && a.Prefix == b.Prefix && a.Local == b.Local
+	case *FuncCall:
+		b, ok := b.(*FuncCall)
+		return ok && a.Prefix == b.Prefix && a.Local == b.Local && equalExprs(a.Args, b.Args)
+	case *Number:
+		b, ok := b.(*Number)
+		return ok && (a.Value == b.Value || math.IsNaN(a.Value) && math.IsNaN(b.Value))
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *BadExpr:
+		_, ok := b.(*BadExpr)
+		return ok
+	case *ForExpr:
+		b, ok := b.(*ForExpr)
+		return ok && a.Prefix == b.Prefix && a.Local == b.Local && Equal(a.In, b.In) && Equal(a.Return, b.Return)
+	case *QuantifiedExpr:
+		b, ok := b.(*QuantifiedExpr)
+		return ok && a.Every == b.Every && a.Prefix == b.Prefix && a.Local == b.Local && Equal(a.In, b.In) && Equal(a.Satisfies, b.Satisfies)
+	case *IfExpr:
+		b, ok := b.(*IfExpr)
+		return ok && Equal(a.Cond, b.Cond) && Equal(a.Then, b.Then) && Equal(a.Else, b.Else)
+	case *SequenceExpr:
+		b, ok := b.(*SequenceExpr)
+		return ok && equalExprs(a.Items, b.Items)
+	case *InstanceOfExpr:
+		b, ok := b.(*InstanceOfExpr)
+		return ok && Equal(a.Expr, b.Expr) && equalSequenceTypes(a.Type, b.Type)
+	case *TreatExpr:
+		b, ok := b.(*TreatExpr)
+		return ok && Equal(a.Expr, b.Expr) && equalSequenceTypes(a.Type, b.Type)
+	case *CastableExpr:
+		b, ok := b.(*CastableExpr)
+		return ok && Equal(a.Expr, b.Expr) && equalSequenceTypes(a.Type, b.Type)
+	case *CastExpr:
+		b, ok := b.(*CastExpr)
+		return ok && Equal(a.Expr, b.Expr) && equalSequenceTypes(a.Type, b.Type)
+	case *LetExpr:
+		b, ok := b.(*LetExpr)
+		return ok && a.Prefix == b.Prefix && a.Local == b.Local && Equal(a.Expr, b.Expr) && Equal(a.Return, b.Return)
+	case *InlineFunctionExpr:
+		b, ok := b.(*InlineFunctionExpr)
+		return ok && equalParams(a.Params, b.Params) && equalSequenceTypes(a.Type, b.Type) && Equal(a.Body, b.Body)
+	case *NamedFunctionRef:
+		b, ok := b.(*NamedFunctionRef)
+		return ok && a.Prefix == b.Prefix && a.Local == b.Local && a.Arity == b.Arity
+	case *DynamicCallExpr:
+		b, ok := b.(*DynamicCallExpr)
+		return ok && Equal(a.Func, b.Func) && equalExprs(a.Args, b.Args)
+	case *SimpleMapExpr:
+		b, ok := b.(*SimpleMapExpr)
+		return ok && Equal(a.LHS, b.LHS) && Equal(a.RHS, b.RHS)
+	case *MapConstructor:
+		b, ok := b.(*MapConstructor)
+		return ok && equalMapEntries(a.Entries, b.Entries)
+	case *ArrayConstructor:
+		b, ok := b.(*ArrayConstructor)
+		return ok && a.Curly == b.Curly && equalExprs(a.Members, b.Members)
+	case *LookupExpr:
+		b, ok := b.(*LookupExpr)
+		return ok && Equal(a.Expr, b.Expr) && Equal(a.Key, b.Key)
+	case *ArrowExpr:
+		b, ok := b.(*ArrowExpr)
+		return ok && Equal(a.Expr, b.Expr) && Equal(a.Call, b.Call)
+	}
+	panic(fmt.Sprintf("xpathparser: unexpected expr type %T", a))
+}
+
+func equalExprs(a, b []Expr) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalParams(a, b []*Param) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Prefix != b[i].Prefix || a[i].Local != b[i].Local || !equalSequenceTypes(a[i].Type, b[i].Type) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalMapEntries(a, b []*MapEntry) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !Equal(a[i].Key, b[i].Key) || !Equal(a[i].Value, b[i].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalLocationPaths(a, b *LocationPath) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	if a.Abs != b.Abs || len(a.Steps) != len(b.Steps) {
+		return false
+	}
+	for i := range a.Steps {
+		if !equalSteps(a.Steps[i], b.Steps[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalSteps(a, b *Step) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Axis == b.Axis && equalNodeTests(a.NodeTest, b.NodeTest) && equalExprs(a.Predicates, b.Predicates)
+}
+
+func equalNodeTests(a, b NodeTest) bool {
+	switch a := a.(type) {
+	case *NameTest:
+		b, ok := b.(*NameTest)
+		return ok && a.Prefix == b.Prefix && a.Local == b.Local
+	case *NodeTypeTest:
+		b, ok := b.(*NodeTypeTest)
+		return ok && a.Type == b.Type
+	case *PITest:
+		b, ok := b.(*PITest)
+		return ok && a.Target == b.Target
+	case *KindTest:
+		b, ok := b.(*KindTest)
+		return ok && equalKindTests(a, b)
+	}
+	return a == b
+}
+
+func equalSequenceTypes(a, b *SequenceType) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Occurrence == b.Occurrence && equalItemTypes(a.ItemType, b.ItemType)
+}
+
+func equalItemTypes(a, b ItemType) bool {
+	switch a := a.(type) {
+	case *AtomicType:
+		b, ok := b.(*AtomicType)
+		return ok && a.Prefix == b.Prefix && a.Local == b.Local
+	case *AnyItem:
+		_, ok := b.(*AnyItem)
+		return ok
+	case *NodeTypeTest:
+		b, ok := b.(*NodeTypeTest)
+		return ok && a.Type == b.Type
+	case *PITest:
+		b, ok := b.(*PITest)
+		return ok && a.Target == b.Target
+	case *KindTest:
+		b, ok := b.(*KindTest)
+		return ok && equalKindTests(a, b)
+	case *FunctionTest:
+		b, ok := b.(*FunctionTest)
+		if !ok || len(a.ParamTypes) != len(b.ParamTypes) {
+			return false
+		}
+		for i := range a.ParamTypes {
+			if !equalSequenceTypes(a.ParamTypes[i], b.ParamTypes[i]) {
+				return false
+			}
+		}
+		return equalSequenceTypes(a.ReturnType, b.ReturnType)
+	case *MapTest:
+		b, ok := b.(*MapTest)
+		if !ok || (a.KeyType == nil) != (b.KeyType == nil) {
+			return false
+		}
+		return (a.KeyType == nil || equalItemTypes(a.KeyType, b.KeyType)) && equalSequenceTypes(a.ValueType, b.ValueType)
+	case *ArrayTest:
+		b, ok := b.(*ArrayTest)
+		return ok && equalSequenceTypes(a.MemberType, b.MemberType)
+	}
+	return a == b
+}
+
+func equalKindTests(a, b *KindTest) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Test == b.Test && a.Prefix == b.Prefix && a.Local == b.Local &&
+		a.TypePrefix == b.TypePrefix && a.TypeLocal == b.TypeLocal && a.Nillable == b.Nillable &&
+		equalKindTests(a.Element, b.Element)
+}
+
+// Hash returns a hash code of expr, suitable for use as map key or for
+// deduplication. Expressions that are Equal have the same hash code.
+//
+// The hash code is stable: it does not change across processes or
+// versions of this package, unless the expression model changes.
+func Hash(expr Expr) uint64 {
+	h := hasher{fnv.New64a(), make([]byte, 8)}
+	h.expr(expr)
+	return h.Sum64()
+}
+
+type hasher struct {
+	hash.Hash64
+	buf []byte
+}
+
+func (h hasher) int(i int) {
+	binary.LittleEndian.PutUint64(h.buf, uint64(i))
+	h.Write(h.buf)
+}
+
+func (h hasher) bool(b bool) {
+	if b {
+		h.int(1)
+	} else {
+		h.int(0)
+	}
+}
+
+func (h hasher) string(s string) {
+	h.int(len(s))
+	h.Write([]byte(s))
+}
+
+func (h hasher) expr(expr Expr) {
+	if expr == nil {
+		h.int(-1)
+		return
+	}
+	h.int(int(expr.Kind()))
+	switch e := expr.(type) {
+	case *LocationPath:
+		h.locationPath(e)
+	case *FilterExpr:
+		h.expr(e.Expr)
+		h.exprs(e.Predicates)
+	case *PathExpr:
+		h.expr(e.Filter)
+		h.locationPath(e.LocationPath)
+	case *SlashExpr:
+		h.expr(e.LHS)
+		h.expr(e.RHS)
+	case *BinaryExpr:
+		h.int(int(e.Op))
+		h.expr(e.LHS)
+		h.expr(e.RHS)
+	case *NegateExpr:
+		h.expr(e.Expr)
+	case *UnaryPlusExpr:
+		h.expr(e.Expr)
+	case *VarRef:
+		h.string(e.Prefix)
+		h.string(e.Local)
+	case *FuncCall:
+		h.string(e.Prefix)
+		h.string(e.Local)
+		h.exprs(e.Args)
+	case *Number:
+		f := e.Value
+		switch {
+		case math.IsNaN(f):
+			f = math.NaN() // all NaNs are equal
+		case f == 0:
+			f = 0 // -0 == 0
+		}
+		h.int(int(math.Float64bits(f)))
+	case *String:
+		h.string(e.Value)
+	case *BadExpr:
+		// kind is all it has
+	case *ForExpr:
+		h.string(e.Prefix)
+		h.string(e.Local)
+		h.expr(e.In)
+		h.expr(e.Return)
+	case *QuantifiedExpr:
+		h.bool(e.Every)
+		h.string(e.Prefix)
+		h.string(e.Local)
+		h.expr(e.In)
+		h.expr(e.Satisfies)
+	case *IfExpr:
+		h.expr(e.Cond)
+		h.expr(e.Then)
+		h.expr(e.Else)
+	case *SequenceExpr:
+		h.exprs(e.Items)
+	case *InstanceOfExpr:
+		h.expr(e.Expr)
+		h.sequenceType(e.Type)
+	case *TreatExpr:
+		h.expr(e.Expr)
+		h.sequenceType(e.Type)
+	case *CastableExpr:
+		h.expr(e.Expr)
+		h.sequenceType(e.Type)
+	case *CastExpr:
+		h.expr(e.Expr)
+		h.sequenceType(e.Type)
+	case *LetExpr:
+		h.string(e.Prefix)
+		h.string(e.Local)
+		h.expr(e.Expr)
+		h.expr(e.Return)
+	case *InlineFunctionExpr:
+		h.int(len(e.Params))
+		for _, param := range e.Params {
+			h.string(param.Prefix)
+			h.string(param.Local)
+			h.sequenceType(param.Type)
+		}
+		h.sequenceType(e.Type)
+		h.expr(e.Body)
+	case *NamedFunctionRef:
+		h.string(e.Prefix)
+		h.string(e.Local)
+		h.int(e.Arity)
+	case *DynamicCallExpr:
+		h.expr(e.Func)
+		h.exprs(e.Args)
+	case *SimpleMapExpr:
+		h.expr(e.LHS)
+		h.expr(e.RHS)
+	case *MapConstructor:
+		h.int(len(e.Entries))
+		for _, entry := range e.Entries {
+			h.expr(entry.Key)
+			h.expr(entry.Value)
+		}
+	case *ArrayConstructor:
+		h.bool(e.Curly)
+		h.exprs(e.Members)
+	case *LookupExpr:
+		h.expr(e.Expr)
+		h.expr(e.Key)
+	case *ArrowExpr:
+		h.expr(e.Expr)
+		h.expr(e.Call)
+	default:
+		panic(fmt.Sprintf("xpathparser: unexpected expr type %T", expr))
+	}
+}
+
+func (h hasher) exprs(exprs []Expr) {
+	h.int(len(exprs))
+	for _, expr := range exprs {
+		h.expr(expr)
+	}
+}
+
+func (h hasher) locationPath(lp *LocationPath) {
+	if lp == nil {
+		h.int(-1)
+		return
+	}
+	h.bool(lp.Abs)
+	h.int(len(lp.Steps))
+	for _, step := range lp.Steps {
+		h.int(int(step.Axis))
+		h.node(step.NodeTest)
+		h.exprs(step.Predicates)
+	}
+}
+
+func (h hasher) sequenceType(t *SequenceType) {
+	if t == nil {
+		h.int(-1)
+		return
+	}
+	h.int(int(t.Occurrence))
+	h.node(t.ItemType)
+}
+
+// node hashes node test or item type.
+func (h hasher) node(n TreeNode) {
+	switch n := n.(type) {
+	case nil:
+		h.int(-1)
+	case *NameTest:
+		h.int(0)
+		h.string(n.Prefix)
+		h.string(n.Local)
+	case *NodeTypeTest:
+		h.int(1)
+		h.int(int(n.Type))
+	case *PITest:
+		h.int(2)
+		h.string(n.Target)
+	case *KindTest:
+		h.int(3)
+		h.int(int(n.Test))
+		h.string(n.Prefix)
+		h.string(n.Local)
+		h.string(n.TypePrefix)
+		h.string(n.TypeLocal)
+		h.bool(n.Nillable)
+		if n.Element == nil {
+			h.node(nil)
+		} else {
+			h.node(n.Element)
+		}
+	case *AtomicType:
+		h.int(4)
+		h.string(n.Prefix)
+		h.string(n.Local)
+	case *AnyItem:
+		h.int(5)
+	case *FunctionTest:
+		h.int(6)
+		h.int(len(n.ParamTypes))
+		for _, t := range n.ParamTypes {
+			h.sequenceType(t)
+		}
+		h.sequenceType(n.ReturnType)
+	case *MapTest:
+		h.int(7)
+		if n.KeyType == nil {
+			h.node(nil)
+		} else {
+			h.node(n.KeyType)
+		}
+		h.sequenceType(n.ValueType)
+	case *ArrayTest:
+		h.int(8)
+		h.sequenceType(n.MemberType)
+	default:
+		panic(fmt.Sprintf("xpathparser: unexpected node type %T", n))
+	}
+}
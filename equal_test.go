@@ -0,0 +1,90 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+func TestEqual(t *testing.T) {
+	tests := []struct {
+		a, b  Expr
+		equal bool
+	}{
+		{MustParse(`a/b[1]`), MustParse(` a / b [ 1 ] `), true},
+		{MustParse(`a/b[1]`), MustParse(`a/b[2]`), false},
+		{MustParse(`a/b`), MustParse(`a/@b`), false},
+		{MustParse(`a/b`), MustParse(`/a/b`), false},
+		{MustParse(`a/text()`), MustParse(`a/comment()`), false},
+		{MustParse(`processing-instruction('a')`), MustParse(`processing-instruction("a")`), true},
+		{MustParse(`f(1, 2)`), MustParse(`f(1)`), false},
+		{MustParse(`ns:f(1)`), MustParse(`f(1)`), false},
+		{MustParse(`$x[1]/a`), MustParse(`$x[1]/a`), true},
+		{MustParse(`1 + 2`), MustParse(`1 - 2`), false},
+		{MustParse(`-$a`), MustParse(`-$b`), false},
+		{&Number{Value: math.NaN()}, &Number{Value: math.NaN()}, true},
+		{&Number{Value: 0}, &Number{Value: math.Copysign(0, -1)}, true},
+		{&Number{Value: 1}, &String{Value: "1"}, false},
+		{
+			&LocationPath{Steps: []*Step{{Axis: Child, NodeTest: &NodeTypeTest{Type: Node}, Predicates: []Expr{}}}},
+			&LocationPath{Steps: []*Step{{Axis: Child, NodeTest: &NodeTypeTest{Type: Node}}}},
+			true,
+		},
+		{&FuncCall{Local: "f", Args: []Expr{}}, &FuncCall{Local: "f"}, true},
+		{
+			&TreatExpr{Expr: &VarRef{Local: "f"}, Type: &SequenceType{ItemType: &FunctionTest{}}},
+			&TreatExpr{Expr: &VarRef{Local: "f"}, Type: &SequenceType{ItemType: &FunctionTest{ReturnType: &SequenceType{ItemType: &AnyItem{}}}}},
+			false,
+		},
+		{
+			&TreatExpr{Expr: &VarRef{Local: "m"}, Type: &SequenceType{ItemType: &MapTest{}}},
+			&TreatExpr{Expr: &VarRef{Local: "m"}, Type: &SequenceType{ItemType: &ArrayTest{}}},
+			false,
+		},
+	}
+	for _, test := range tests {
+		if got := Equal(test.a, test.b); got != test.equal {
+			t.Errorf("FAIL: Equal(%v, %v): got %v, want %v", test.a, test.b, got, test.equal)
+		}
+		if got := Equal(test.b, test.a); got != test.equal {
+			t.Errorf("FAIL: Equal(%v, %v): got %v, want %v", test.b, test.a, got, test.equal)
+		}
+		if test.equal && Hash(test.a) != Hash(test.b) {
+			t.Errorf("FAIL: Hash(%v) != Hash(%v)", test.a, test.b)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	seen := make(map[uint64]string)
+	for _, test := range roundTrips() {
+		h := Hash(test.expr)
+		if other, ok := seen[h]; ok {
+			t.Errorf("FAIL: hash collision between %s and %s", test.xpath, other)
+		}
+		seen[h] = test.xpath
+	}
+}
+
+func TestClone(t *testing.T) {
+	for _, test := range roundTrips() {
+		clone := Clone(test.expr)
+		if !Equal(test.expr, clone) {
+			t.Errorf("FAIL: %s: clone %v is not equal", test.xpath, clone)
+		}
+	}
+
+	expr := MustParse(`a[1]/b`)
+	clone := Clone(expr).(*LocationPath)
+	clone.Steps[0].NodeTest.(*NameTest).Local = "x"
+	clone.Steps[0].Predicates[0] = &Number{Value: 2}
+	clone.Steps[1].Axis = Attribute
+	if got := expr.String(); got != `child::a[1]/child::b` {
+		t.Errorf("FAIL: original modified to %s", got)
+	}
+}
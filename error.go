@@ -0,0 +1,135 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is the error type returned by Parse function.
+//
+// It represents a syntax error in the XPath expression.
+type Error struct {
+	Msg    string
+	XPath  string
+	Offset int // byte offset, starting at 0
+
+	Code     ErrorCode
+	Line     int         // line number, starting at 1
+	Column   int         // column number in runes, starting at 1
+	Expected []TokenKind // kinds of token expected, if known
+	Actual   TokenKind   // kind of token found at Offset
+
+	// Suggestions lists the names, closest first, that were probably
+	// meant instead of a misspelled axis, node type or function name.
+	Suggestions []string
+
+	end int // byte offset immediately after the token found
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s in xpath %s at offset %d", e.Msg, e.XPath, e.Offset)
+}
+
+// Snippet returns the line of xpath containing the error, followed by a line
+// underlining the offending token with a caret, for command-line output:
+//
+//	a[@]/b
+//	   ^
+//
+// Tabs preceding the caret are preserved, so that it is aligned when
+// printed to a terminal.
+func (e *Error) Snippet() string {
+	begin := strings.LastIndexByte(e.XPath[:e.Offset], '\n') + 1
+	end := strings.IndexByte(e.XPath[e.Offset:], '\n')
+	if end == -1 {
+		end = len(e.XPath)
+	} else {
+		end += e.Offset
+	}
+	line := strings.TrimSuffix(e.XPath[begin:end], "\r")
+
+	buf := new(bytes.Buffer)
+	buf.WriteString(line)
+	buf.WriteByte('\n')
+	for _, r := range e.XPath[begin:e.Offset] {
+		if r == '\t' {
+			buf.WriteByte('\t')
+		} else {
+			buf.WriteByte(' ')
+		}
+	}
+	buf.WriteByte('^')
+	if e.end > e.Offset {
+		tokenEnd := e.end
+		if tokenEnd > begin+len(line) {
+			tokenEnd = begin + len(line)
+		}
+		if n := utf8.RuneCountInString(e.XPath[e.Offset:tokenEnd]); n > 1 {
+			buf.WriteString(strings.Repeat("~", n-1))
+		}
+	}
+	return buf.String()
+}
+
+// ErrorCode identifies the kind of syntax error. The values are stable;
+// new codes are only added at the end.
+type ErrorCode int
+
+// Possible values for ErrorCode.
+const (
+	UnexpectedToken    ErrorCode = iota // token not allowed at this position
+	IllegalCharacter                    // character that cannot start a token, such as '!' not followed by '='
+	UnclosedLiteral                     // string literal without closing quote
+	OperatorExpected                    // name found where operator name is expected
+	InvalidName                         // name that is not valid NCName
+	InvalidAxis                         // unknown axis name
+	InvalidNodeType                     // unknown node type name
+	TrailingSlashSlash                  // location path ending with "//"
+	NodeSetExpected                     // location path applied to number or string literal in XPath 1.0
+	NumberOutOfRange                    // number literal that cannot be represented as double
+	UnknownFunction                     // function not listed in ParseOptions.Functions
+	LimitExceeded                       // xpath exceeding one of the limits in ParseOptions
+	UnclosedComment                     // comment of XPath 2.0 without closing ":)"
+)
+
+var errorCodeNames = []string{
+	"UnexpectedToken",
+	"IllegalCharacter",
+	"UnclosedLiteral",
+	"OperatorExpected",
+	"InvalidName",
+	"InvalidAxis",
+	"InvalidNodeType",
+	"TrailingSlashSlash",
+	"NodeSetExpected",
+	"NumberOutOfRange",
+	"UnknownFunction",
+	"LimitExceeded",
+	"UnclosedComment",
+}
+
+func (c ErrorCode) String() string {
+	return errorCodeNames[c]
+}
+
+// W3CCode returns the error code defined by W3C for c, in the
+// http://www.w3.org/2005/xqt-errors namespace. Every syntax error is
+// XPST0003, except NumberOutOfRange, which is FOAR0002, UnknownFunction,
+// which is XPST0017, and LimitExceeded, which is XPDY0130.
+func (c ErrorCode) W3CCode() string {
+	switch c {
+	case NumberOutOfRange:
+		return "FOAR0002"
+	case UnknownFunction:
+		return "XPST0017"
+	case LimitExceeded:
+		return "XPDY0130"
+	}
+	return "XPST0003"
+}
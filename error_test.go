@@ -0,0 +1,108 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+func TestErrorCode(t *testing.T) {
+	tests := []struct {
+		xpath    string
+		code     ErrorCode
+		line     int
+		column   int
+		expected []TokenKind
+		actual   TokenKind
+	}{
+		{`a[`, UnexpectedToken, 1, 3, nil, TokenEOF},
+		{`foo(1`, UnexpectedToken, 1, 6, []TokenKind{TokenRParen}, TokenEOF},
+		{`a/`, UnexpectedToken, 1, 3, []TokenKind{TokenDot, TokenDotDot, TokenAt, TokenIdentifier, TokenStar}, TokenEOF},
+		{`a !b`, IllegalCharacter, 1, 3, []TokenKind{TokenNEQ}, TokenIllegal},
+		{`;abc`, IllegalCharacter, 1, 1, nil, TokenIllegal},
+		{"a = \n'one", UnclosedLiteral, 2, 5, nil, TokenIllegal},
+		{`abc def`, OperatorExpected, 1, 5, []TokenKind{TokenAnd, TokenOr, TokenMod, TokenDiv}, TokenIllegal},
+		{`hero::*`, InvalidAxis, 1, 1, nil, TokenIdentifier},
+		{`child::abcd()`, InvalidNodeType, 1, 8, nil, TokenIdentifier},
+		{`a[//]`, TrailingSlashSlash, 1, 5, nil, TokenRBracket},
+		{`'a'/b`, NodeSetExpected, 1, 4, nil, TokenSlash},
+		{"1 +\n\t" + `"€" 2`, UnexpectedToken, 2, 6, []TokenKind{TokenEOF}, TokenNumber},
+		{strings.Repeat("9", 400), NumberOutOfRange, 1, 1, nil, TokenNumber},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.xpath)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("FAIL: %q: *Error expected, got %v", test.xpath, err)
+			continue
+		}
+		if e.Code != test.code || e.Line != test.line || e.Column != test.column || !reflect.DeepEqual(e.Expected, test.expected) || e.Actual != test.actual {
+			t.Errorf("FAIL: %q: got %v %d:%d %v %v, want %v %d:%d %v %v", test.xpath,
+				e.Code, e.Line, e.Column, e.Expected, e.Actual,
+				test.code, test.line, test.column, test.expected, test.actual)
+		}
+	}
+}
+
+func TestErrorSnippet(t *testing.T) {
+	tests := []struct {
+		xpath string
+		want  string
+	}{
+		{`a[@]/b`, "a[@]/b\n   ^"},
+		{`hero::*`, "hero::*\n^~~~"},
+		{"a = 1 and\n\tb = 'x' 'y'", "\tb = 'x' 'y'\n\t        ^~~"},
+		{`"€€" = 'x`, "\"€€\" = 'x\n         ^"},
+		{"foo(\r\n  1 2)", "  1 2)\n    ^"},
+	}
+	for _, test := range tests {
+		_, err := Parse(test.xpath)
+		if got := err.(*Error).Snippet(); got != test.want {
+			t.Errorf("FAIL: %q: got\n%s\nwant\n%s", test.xpath, got, test.want)
+		}
+	}
+}
+
+func TestErrorSuggestions(t *testing.T) {
+	options := &ParseOptions{Functions: []string{"concat", "contains", "count", "ext:node-set"}}
+	tests := []struct {
+		xpath string
+		code  ErrorCode
+		want  []string
+	}{
+		{`desendant::x`, InvalidAxis, []string{"descendant"}},
+		{`ancestor-or-slf::x`, InvalidAxis, []string{"ancestor-or-self"}},
+		{`precedin::x`, InvalidAxis, []string{"preceding"}},
+		{`hero::*`, InvalidAxis, nil},
+		{`child::texts()`, InvalidNodeType, []string{"text"}},
+		{`conact('a', 'b')`, UnknownFunction, []string{"concat"}},
+		{`conat('a', 'b')`, UnknownFunction, []string{"concat", "count"}},
+		{`ext:nodeset(.)`, UnknownFunction, []string{"ext:node-set"}},
+		{`cout(.)`, UnknownFunction, []string{"count"}},
+		{`foo()`, UnknownFunction, nil},
+	}
+	for _, test := range tests {
+		_, err := options.Parse(test.xpath)
+		e, ok := err.(*Error)
+		if !ok {
+			t.Errorf("FAIL: %s: *Error expected, got %v", test.xpath, err)
+			continue
+		}
+		if e.Code != test.code || !reflect.DeepEqual(e.Suggestions, test.want) {
+			t.Errorf("FAIL: %s: got %v %v, want %v %v", test.xpath, e.Code, e.Suggestions, test.code, test.want)
+		}
+		t.Log(e.Msg)
+	}
+	if _, err := options.Parse(`concat(count(.), ext:node-set($x))`); err != nil {
+		t.Errorf("FAIL: %v", err)
+	}
+	if _, err := Parse(`foo()`); err != nil {
+		t.Errorf("FAIL: %v", err)
+	}
+}
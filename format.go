@@ -0,0 +1,908 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Format returns the XPath 1.0 text of given expression.
+//
+// Unlike the String methods, which are meant for debugging, the text
+// returned is always accepted by Parse. String literals are quoted with
+// whichever quote character they do not contain. Trees parsed in later
+// versions of XPath must be formatted using PrintConfig with that Version,
+// which quotes a literal containing both quote characters by doubling the
+// quote character in it, as in "say ""it's"" again".
+//
+// Re-parsing the text yields a tree structurally equal to expr, provided
+// expr is a tree that Parse can produce. Values that Parse never produces
+// have no literal form, and are written as equivalent expressions, which
+// re-parse to a different tree:
+//
+//   - in XPath 1.0, a string containing both quote characters is written
+//     as a call to concat, which re-parses to *FuncCall
+//   - negative numbers, infinities and NaN are written as arithmetic
+//     expressions, which re-parse to *NegateExpr or *BinaryExpr
+//
+// Location steps are written in unabbreviated form, and every operand
+// that is itself an operation is parenthesized. Use PrintConfig to
+// change this.
+func Format(expr Expr) string {
+	return new(PrintConfig).Format(expr)
+}
+
+// Fprint writes the XPath 1.0 text of given expression to w.
+// See Format for details.
+func Fprint(w io.Writer, expr Expr) error {
+	return new(PrintConfig).Fprint(w, expr)
+}
+
+// A PrintMode value is a set of flags (or 0). They control printing.
+type PrintMode uint
+
+// Possible values for PrintMode.
+const (
+	// Abbreviate writes location steps using abbreviated syntax where
+	// legal: child axis is omitted, and '@', '.', '..' and '//' are used.
+	Abbreviate PrintMode = 1 << iota
+
+	// MinimalParens writes only those parentheses that are required
+	// by operator precedence and associativity.
+	MinimalParens
+)
+
+// A PrintConfig controls the output of Format and Fprint.
+//
+// The zero value writes unabbreviated location steps, parenthesizes
+// every operand that is itself an operation, and writes everything
+// on a single line.
+type PrintConfig struct {
+	Mode PrintMode
+
+	// Version is the version of XPath grammar, the text is written for.
+	// It must be the version the expression is parsed with, as operator
+	// precedence differs across versions. The zero value is XPath10.
+	Version Version
+
+	// Width is the maximum line width. If it is positive, chains of
+	// "and"/"or" operands, predicates, function arguments and
+	// parenthesized expressions that do not fit are broken across lines.
+	// Only whitespace is inserted, so the output still parses to the
+	// same tree.
+	Width int
+
+	// Indent is the number of spaces per nesting level of broken lines.
+	// Zero means 4.
+	Indent int
+}
+
+// Format returns the XPath text of given expression,
+// formatted according to config c. See package-level Format for details.
+func (c *PrintConfig) Format(expr Expr) string {
+	p := &printer{config: *c}
+	p.expr(expr)
+	return p.buf.String()
+}
+
+// Fprint writes the XPath text of given expression to w,
+// formatted according to config c. See package-level Format for details.
+func (c *PrintConfig) Fprint(w io.Writer, expr Expr) error {
+	p := &printer{config: *c}
+	p.expr(expr)
+	_, err := p.buf.WriteTo(w)
+	return err
+}
+
+type printer struct {
+	config PrintConfig
+	buf    bytes.Buffer
+	col    int // current column in runes
+	indent int // current nesting level
+
+	// limit, if positive, makes the printer measure the length of text
+	// in runes, rather than print it, and stop once length reaches limit.
+	limit  int
+	length int
+}
+
+func (p *printer) print(args ...string) {
+	for _, arg := range args {
+		if p.limit > 0 {
+			p.measure(arg)
+			continue
+		}
+		p.buf.WriteString(arg)
+		if i := strings.LastIndexByte(arg, '\n'); i != -1 {
+			p.col = utf8.RuneCountInString(arg[i+1:])
+		} else {
+			p.col += utf8.RuneCountInString(arg)
+		}
+	}
+}
+
+func (p *printer) newline() {
+	indent := p.config.Indent
+	if indent <= 0 {
+		indent = 4
+	}
+	p.buf.WriteByte('\n')
+	p.buf.WriteString(strings.Repeat(" ", p.indent*indent))
+	p.col = p.indent * indent
+}
+
+// fits tells whether text of given length fits in current line.
+func (p *printer) fits(length int) bool {
+	return p.config.Width <= 0 || p.col+length <= p.config.Width
+}
+
+// flatLen returns the length of expr when printed on single line. The
+// text is measured only till it exceeds the rest of current line, so
+// that measuring each node takes time proportional to Width rather than
+// to its size. Then the length returned is less than the actual length,
+// but still does not fit.
+func (p *printer) flatLen(expr Expr) int {
+	if p.config.Width <= 0 {
+		return 0 // not used
+	}
+	limit := p.config.Width - p.col + 1
+	if limit < 1 {
+		limit = 1
+	}
+	m := &printer{config: p.config, limit: limit}
+	m.config.Width = 0
+	m.expr(expr)
+	return m.length
+}
+
+// measure adds the length of s to p.length, counting no further than p.limit.
+func (p *printer) measure(s string) {
+	for i := 0; i < len(s) && p.length < p.limit; p.length++ {
+		_, size := utf8.DecodeRuneInString(s[i:])
+		i += size
+	}
+}
+
+// full tells whether the printer measuring text has reached its limit,
+// after which printing the rest of text is skipped.
+func (p *printer) full() bool {
+	return p.limit > 0 && p.length >= p.limit
+}
+
+func (p *printer) expr(expr Expr) {
+	if p.full() {
+		return
+	}
+	switch e := expr.(type) {
+	case *LocationPath:
+		p.locationPath(e)
+	case *FilterExpr:
+		p.primary(e.Expr)
+		p.predicates(e.Predicates)
+	case *PathExpr:
+		p.pathFilter(e.Filter)
+		if len(e.LocationPath.Steps) == 0 {
+			p.print("/")
+		}
+		p.steps(e.LocationPath.Steps, true, false)
+	case *SlashExpr:
+		if p.pathLHS(e.LHS) {
+			p.print("/")
+		}
+		p.postfix(e.RHS)
+	case *BinaryExpr:
+		if (e.Op == And || e.Op == Or) && !p.fits(p.flatLen(e)) {
+			p.chain(e)
+			return
+		}
+		p.operand(e.LHS, p.needsParen(e, e.LHS, false))
+		p.print(" ", e.Op.String(), " ")
+		p.operand(e.RHS, p.needsParen(e, e.RHS, true))
+	case *NegateExpr:
+		p.print("-")
+		p.operand(e.Expr, p.needsParen(e, e.Expr, true))
+	case *UnaryPlusExpr:
+		p.print("+")
+		p.operand(e.Expr, p.needsParen(e, e.Expr, true))
+	case *VarRef:
+		p.qname("$", e.Prefix, e.Local)
+	case *FuncCall:
+		broken := len(e.Args) > 0 && !p.fits(p.flatLen(e))
+		p.qname("", e.Prefix, e.Local)
+		p.list(e.Args, broken)
+	case *SequenceExpr:
+		p.list(e.Items, len(e.Items) > 0 && !p.fits(p.flatLen(e)))
+	case *ForExpr:
+		p.print("for ")
+		p.binding(e.Prefix, e.Local, e.In)
+		p.print(" return ")
+		p.expr(e.Return)
+	case *QuantifiedExpr:
+		if e.Every {
+			p.print("every ")
+		} else {
+			p.print("some ")
+		}
+		p.binding(e.Prefix, e.Local, e.In)
+		p.print(" satisfies ")
+		p.expr(e.Satisfies)
+	case *IfExpr:
+		p.print("if ")
+		p.paren(e.Cond)
+		p.print(" then ")
+		p.expr(e.Then)
+		p.print(" else ")
+		p.expr(e.Else)
+	case *InstanceOfExpr:
+		p.operand(e.Expr, p.needsParen(e, e.Expr, false))
+		p.print(" instance of ")
+		p.sequenceType(e.Type)
+	case *TreatExpr:
+		p.operand(e.Expr, p.needsParen(e, e.Expr, false))
+		p.print(" treat as ")
+		p.sequenceType(e.Type)
+	case *CastableExpr:
+		p.operand(e.Expr, p.needsParen(e, e.Expr, false))
+		p.print(" castable as ")
+		p.sequenceType(e.Type)
+	case *CastExpr:
+		p.operand(e.Expr, p.needsParen(e, e.Expr, false))
+		p.print(" cast as ")
+		p.sequenceType(e.Type)
+	case *LetExpr:
+		p.print("let ")
+		p.qname("$", e.Prefix, e.Local)
+		p.print(" := ")
+		p.expr(e.Expr)
+		p.print(" return ")
+		p.expr(e.Return)
+	case *InlineFunctionExpr:
+		p.print("function(")
+		for i, param := range e.Params {
+			if i > 0 {
+				p.print(", ")
+			}
+			p.qname("$", param.Prefix, param.Local)
+			if param.Type != nil {
+				p.print(" as ")
+				p.sequenceType(param.Type)
+			}
+		}
+		p.print(")")
+		if e.Type != nil {
+			p.print(" as ")
+			p.sequenceType(e.Type)
+		}
+		p.print(" ")
+		if s, ok := e.Body.(*SequenceExpr); ok && len(s.Items) == 0 && p.config.Version >= XPath31 {
+			p.print("{}")
+		} else {
+			p.enclose("{", e.Body, "}")
+		}
+	case *NamedFunctionRef:
+		p.qname("", e.Prefix, e.Local)
+		p.print("#", strconv.Itoa(e.Arity))
+	case *DynamicCallExpr:
+		p.postfix(e.Func)
+		p.list(e.Args, len(e.Args) > 0 && !p.fits(p.flatLen(e)))
+	case *SimpleMapExpr:
+		p.operand(e.LHS, p.needsParen(e, e.LHS, false))
+		p.print(" ! ")
+		p.operand(e.RHS, p.needsParen(e, e.RHS, true))
+	case *MapConstructor:
+		broken := len(e.Entries) > 0 && !p.fits(p.flatLen(e))
+		p.items("map {", len(e.Entries), "}", broken, func(i int) {
+			p.expr(e.Entries[i].Key)
+			p.print(": ")
+			p.expr(e.Entries[i].Value)
+		})
+	case *ArrayConstructor:
+		broken := len(e.Members) > 0 && !p.fits(p.flatLen(e))
+		open, close := "[", "]"
+		if e.Curly {
+			open, close = "array {", "}"
+		}
+		p.items(open, len(e.Members), close, broken, func(i int) {
+			p.expr(e.Members[i])
+		})
+	case *LookupExpr:
+		if e.Expr != nil {
+			p.postfix(e.Expr)
+		}
+		p.print("?")
+		p.lookupKey(e.Key)
+	case *ArrowExpr:
+		p.operand(e.Expr, p.needsParen(e, e.Expr, false))
+		p.print(" => ")
+		if call, ok := e.Call.(*DynamicCallExpr); ok {
+			// function is specified by variable reference or parenthesized expression
+			if _, ok := call.Func.(*VarRef); !ok {
+				p.paren(call.Func)
+				p.list(call.Args, len(call.Args) > 0 && !p.fits(p.flatLen(call)))
+				break
+			}
+		}
+		p.expr(e.Call)
+	case *Number:
+		p.number(e.Value)
+	case *String:
+		p.literal(e.Value)
+	case *BadExpr:
+		p.print("BadExpr")
+	default:
+		panic(fmt.Sprintf("xpathparser: unexpected expr type %T", expr))
+	}
+}
+
+// list prints exprs separated by ',' and enclosed in parentheses.
+// If broken is true, each expr is printed on separate indented line.
+func (p *printer) list(exprs []Expr, broken bool) {
+	p.items("(", len(exprs), ")", broken, func(i int) {
+		p.expr(exprs[i])
+	})
+}
+
+// items prints n items using item, separated by ',' and enclosed in
+// open and close. If broken is true, each item is printed on separate
+// indented line.
+func (p *printer) items(open string, n int, close string, broken bool, item func(i int)) {
+	p.print(open)
+	if broken {
+		p.indent++
+	}
+	for i := 0; i < n && !p.full(); i++ {
+		if i > 0 {
+			p.print(",")
+		}
+		if broken {
+			p.newline()
+		} else if i > 0 {
+			p.print(" ")
+		}
+		item(i)
+	}
+	if broken {
+		p.indent--
+		p.newline()
+	}
+	p.print(close)
+}
+
+// binding prints variable binding of for and quantified expressions.
+func (p *printer) binding(prefix, local string, in Expr) {
+	p.qname("$", prefix, local)
+	p.print(" in ")
+	p.expr(in)
+}
+
+// operand prints operand of unary or binary operator.
+func (p *printer) operand(expr Expr, paren bool) {
+	if lp, ok := expr.(*LocationPath); ok && lp.Abs && len(lp.Steps) == 0 {
+		// "/" followed by operator name or '*' is read as a step
+		paren = true
+	}
+	if paren {
+		p.paren(expr)
+	} else {
+		p.expr(expr)
+	}
+}
+
+// chain prints the chain of operands joined by the operator of b,
+// one operand per line.
+func (p *printer) chain(b *BinaryExpr) {
+	for i, operand := range p.chainOperands(b, nil) {
+		if i > 0 {
+			p.newline()
+			p.print(b.Op.String(), " ")
+		}
+		p.operand(operand.expr, operand.paren)
+	}
+}
+
+type chainOperand struct {
+	expr  Expr
+	paren bool
+}
+
+// chainOperands appends operands of b to operands. Operands using the
+// same operator as b, that need no parentheses, are flattened.
+func (p *printer) chainOperands(b *BinaryExpr, operands []chainOperand) []chainOperand {
+	for i, operand := range []Expr{b.LHS, b.RHS} {
+		paren := p.needsParen(b, operand, i == 1)
+		if o, ok := operand.(*BinaryExpr); ok && o.Op == b.Op && !paren {
+			operands = p.chainOperands(o, operands)
+		} else {
+			operands = append(operands, chainOperand{operand, paren})
+		}
+	}
+	return operands
+}
+
+// needsParen tells whether given operand of parent must be parenthesized.
+// right tells whether operand is the right operand of parent.
+func (p *printer) needsParen(parent, operand Expr, right bool) bool {
+	if p.config.Mode&MinimalParens == 0 {
+		switch operand.(type) {
+		case *BinaryExpr, *NegateExpr, *UnaryPlusExpr, *ForExpr, *QuantifiedExpr, *IfExpr,
+			*InstanceOfExpr, *TreatExpr, *CastableExpr, *CastExpr, *LetExpr, *SimpleMapExpr, *ArrowExpr:
+			return true
+		}
+		return false
+	}
+	if b, ok := parent.(*BinaryExpr); ok && !right && (b.Op == Add || b.Op == Multiply) && p.endsWithType(operand) {
+		// '+' or '*' would be read as occurrence indicator
+		return true
+	}
+	version := p.config.Version
+	prec, operandPrec := precedence(parent, version), precedence(operand, version)
+	switch parent.(type) {
+	case *NegateExpr, *UnaryPlusExpr:
+		return operandPrec < prec
+	}
+	if right || !associative(parent, version) {
+		return operandPrec <= prec
+	}
+	return operandPrec < prec
+}
+
+// precedence returns the precedence level of expr in given version.
+// Operators with higher level bind tighter.
+func precedence(expr Expr, version Version) int {
+	switch e := expr.(type) {
+	case *ForExpr, *QuantifiedExpr, *IfExpr, *LetExpr:
+		return 0
+	case *BinaryExpr:
+		switch e.Op {
+		case Or:
+			return 1
+		case And:
+			return 2
+		case EQ, NEQ, ValueEQ, ValueNE, ValueLT, ValueLE, ValueGT, ValueGE, Is, Precedes, Follows:
+			return 3
+		case LT, LTE, GT, GTE:
+			if version >= XPath20 {
+				return 3
+			}
+			return 4
+		case Concat:
+			return 4
+		case To:
+			return 5
+		case Add, Subtract:
+			return 6
+		case Multiply, Div, Mod, IDiv:
+			return 7
+		case Union:
+			if version >= XPath20 {
+				return 8
+			}
+			return 17
+		case Intersect, Except:
+			return 9
+		}
+	case *InstanceOfExpr:
+		return 10
+	case *TreatExpr:
+		return 11
+	case *CastableExpr:
+		return 12
+	case *CastExpr:
+		return 13
+	case *ArrowExpr:
+		return 14
+	case *NegateExpr, *UnaryPlusExpr:
+		return 15
+	case *SimpleMapExpr:
+		return 16
+	}
+	return 18
+}
+
+// associative tells whether the operator of expression e is left
+// associative in given version. In XPath 2.0, comparison and range
+// operators are not associative, and type expressions cannot be applied
+// to themselves without parentheses.
+func associative(e Expr, version Version) bool {
+	switch e := e.(type) {
+	case *BinaryExpr:
+		if version >= XPath20 {
+			switch e.Op {
+			case EQ, NEQ, LT, LTE, GT, GTE, To,
+				ValueEQ, ValueNE, ValueLT, ValueLE, ValueGT, ValueGE, Is, Precedes, Follows:
+				return false
+			}
+		}
+	case *InstanceOfExpr, *TreatExpr, *CastableExpr, *CastExpr:
+		return false
+	}
+	return true
+}
+
+// endsWithType tells whether expr, printed without enclosing parentheses,
+// ends with a sequence type without occurrence indicator.
+func (p *printer) endsWithType(expr Expr) bool {
+	switch e := expr.(type) {
+	case *InstanceOfExpr:
+		return endsWithItemType(e.Type)
+	case *TreatExpr:
+		return endsWithItemType(e.Type)
+	case *BinaryExpr:
+		return !p.needsParen(e, e.RHS, true) && p.endsWithType(e.RHS)
+	case *NegateExpr:
+		return !p.needsParen(e, e.Expr, true) && p.endsWithType(e.Expr)
+	case *UnaryPlusExpr:
+		return !p.needsParen(e, e.Expr, true) && p.endsWithType(e.Expr)
+	}
+	return false
+}
+
+// endsWithItemType tells whether t, printed, ends with an item type
+// without occurrence indicator. The return type of function test is
+// printed last.
+func endsWithItemType(t *SequenceType) bool {
+	if t.ItemType == nil || t.Occurrence != ExactlyOne {
+		return false
+	}
+	if ft, ok := t.ItemType.(*FunctionTest); ok && ft.ReturnType != nil {
+		return endsWithItemType(ft.ReturnType)
+	}
+	return true
+}
+
+// primary prints expr such that it is read as primary expression
+// of a filter expression.
+func (p *printer) primary(expr Expr) {
+	switch expr.(type) {
+	case *VarRef, *FuncCall, *SequenceExpr, *String, *NamedFunctionRef, *InlineFunctionExpr, *DynamicCallExpr,
+		*MapConstructor, *ArrayConstructor, *LookupExpr:
+		p.expr(expr)
+	case *Number:
+		if isLiteral(expr) {
+			p.expr(expr)
+		} else {
+			p.paren(expr)
+		}
+	default:
+		p.paren(expr)
+	}
+}
+
+// postfix prints expr such that it is read as the operand of postfix
+// dynamic function call or lookup operator.
+func (p *printer) postfix(expr Expr) {
+	if _, ok := expr.(*FilterExpr); ok {
+		p.expr(expr)
+	} else {
+		p.primary(expr)
+	}
+}
+
+// lookupKey prints the key of lookup operator, nil being wildcard.
+func (p *printer) lookupKey(key Expr) {
+	switch k := key.(type) {
+	case nil:
+		p.print("*")
+	case *String:
+		if isName([]byte(k.Value)) {
+			p.print(k.Value)
+		} else {
+			p.paren(k)
+		}
+	case *Number:
+		if f := k.Value; isLiteral(k) && f == math.Trunc(f) {
+			p.number(f)
+		} else {
+			p.paren(k)
+		}
+	case *SequenceExpr:
+		p.expr(k)
+	default:
+		p.paren(k)
+	}
+}
+
+func (p *printer) paren(expr Expr) {
+	p.enclose("(", expr, ")")
+}
+
+// enclose prints expr enclosed in given brackets. If it does not fit
+// in current line, expr is printed on separate indented lines.
+func (p *printer) enclose(open string, expr Expr, close string) {
+	p.print(open)
+	if p.fits(p.flatLen(expr) + 2) {
+		p.expr(expr)
+	} else {
+		p.indent++
+		p.newline()
+		p.expr(expr)
+		p.indent--
+		p.newline()
+	}
+	p.print(close)
+}
+
+func (p *printer) locationPath(lp *LocationPath) {
+	if lp.Abs && len(lp.Steps) == 0 {
+		p.print("/")
+		return
+	}
+	p.steps(lp.Steps, lp.Abs, false)
+}
+
+// pathFilter prints the filter expression of PathExpr, or the left
+// operand of SlashExpr, which is not a path itself.
+func (p *printer) pathFilter(f Expr) {
+	switch f := f.(type) {
+	case *FilterExpr, *DynamicCallExpr, *LookupExpr:
+		if isLiteral(postfixBase(f)) {
+			p.paren(f)
+		} else {
+			p.expr(f)
+		}
+	case *VarRef, *FuncCall, *SequenceExpr, *NamedFunctionRef, *InlineFunctionExpr, *MapConstructor, *ArrayConstructor,
+		*SlashExpr:
+		p.expr(f)
+	default:
+		p.paren(f)
+	}
+}
+
+// pathLHS prints the left operand of SlashExpr. It tells whether '/'
+// must follow, which is not the case if the operand ends with "//".
+func (p *printer) pathLHS(lhs Expr) bool {
+	switch e := lhs.(type) {
+	case *LocationPath:
+		return p.steps(e.Steps, e.Abs, true)
+	case *PathExpr:
+		p.pathFilter(e.Filter)
+		return p.steps(e.LocationPath.Steps, true, true)
+	}
+	p.pathFilter(lhs)
+	return true
+}
+
+// steps prints given steps separated by '/'. If slash is true, steps
+// are preceded by '/'. If more is true, steps are followed by another
+// step. It tells whether '/' must precede the next step.
+func (p *printer) steps(steps []*Step, slash, more bool) bool {
+	abbreviate := p.config.Mode&Abbreviate != 0
+	for i, step := range steps {
+		if p.full() {
+			break
+		}
+		if abbreviate && slash && (more || i < len(steps)-1) && isAbbrev(step, DescendantOrSelf) {
+			p.print("//")
+			slash = false
+			continue
+		}
+		if slash {
+			p.print("/")
+		}
+		p.step(step)
+		slash = true
+	}
+	return slash
+}
+
+func (p *printer) step(step *Step) {
+	if p.config.Mode&Abbreviate != 0 {
+		switch {
+		case isAbbrev(step, Self):
+			p.print(".")
+			return
+		case isAbbrev(step, Parent):
+			p.print("..")
+			return
+		case step.Axis == Attribute:
+			p.print("@")
+		case step.Axis != Child:
+			p.print(step.Axis.String(), "::")
+		}
+	} else {
+		p.print(step.Axis.String(), "::")
+	}
+	p.nodeTest(step.NodeTest)
+	p.predicates(step.Predicates)
+}
+
+// isAbbrev tells whether step is axis::node() without predicates,
+// which has abbreviated syntax for axes self, parent and descendant-or-self.
+func isAbbrev(step *Step, axis Axis) bool {
+	nt, ok := step.NodeTest.(*NodeTypeTest)
+	return step.Axis == axis && ok && nt.Type == Node && len(step.Predicates) == 0
+}
+
+func (p *printer) nodeTest(nodeTest NodeTest) {
+	switch nt := nodeTest.(type) {
+	case *NameTest:
+		p.qname("", nt.Prefix, nt.Local)
+	case *NodeTypeTest:
+		p.print(nt.String())
+	case *PITest:
+		p.print("processing-instruction(")
+		if nt.Target != "" {
+			p.literal(nt.Target)
+		}
+		p.print(")")
+	case *KindTest:
+		p.kindTest(nt)
+	default:
+		panic(fmt.Sprintf("xpathparser: unexpected nodeTest type %T", nodeTest))
+	}
+}
+
+func (p *printer) kindTest(kt *KindTest) {
+	p.print(kt.Test.String(), "(")
+	switch {
+	case kt.Element != nil:
+		p.kindTest(kt.Element)
+	case kt.Local != "":
+		p.qname("", kt.Prefix, kt.Local)
+		if kt.TypeLocal != "" {
+			p.print(", ")
+			p.qname("", kt.TypePrefix, kt.TypeLocal)
+			if kt.Nillable {
+				p.print("?")
+			}
+		}
+	}
+	p.print(")")
+}
+
+func (p *printer) sequenceType(t *SequenceType) {
+	switch it := t.ItemType.(type) {
+	case nil:
+		p.print("empty-sequence()")
+		return
+	case *AtomicType:
+		p.qname("", it.Prefix, it.Local)
+	case *AnyItem:
+		p.print("item()")
+	case NodeTest:
+		p.nodeTest(it)
+	case *FunctionTest:
+		// the occurrence indicator would otherwise bind to the return type
+		if it.ReturnType != nil && t.Occurrence != ExactlyOne {
+			p.print("(")
+			p.functionTest(it)
+			p.print(")")
+		} else {
+			p.functionTest(it)
+		}
+	case *MapTest:
+		if it.KeyType == nil {
+			p.print("map(*)")
+		} else {
+			p.print("map(")
+			p.qname("", it.KeyType.Prefix, it.KeyType.Local)
+			p.print(", ")
+			p.sequenceType(it.ValueType)
+			p.print(")")
+		}
+	case *ArrayTest:
+		if it.MemberType == nil {
+			p.print("array(*)")
+		} else {
+			p.print("array(")
+			p.sequenceType(it.MemberType)
+			p.print(")")
+		}
+	default:
+		panic(fmt.Sprintf("xpathparser: unexpected itemType type %T", it))
+	}
+	p.print(t.Occurrence.String())
+}
+
+func (p *printer) functionTest(ft *FunctionTest) {
+	if ft.ReturnType == nil {
+		p.print("function(*)")
+		return
+	}
+	p.print("function(")
+	for i, t := range ft.ParamTypes {
+		if i > 0 {
+			p.print(", ")
+		}
+		p.sequenceType(t)
+	}
+	p.print(") as ")
+	p.sequenceType(ft.ReturnType)
+}
+
+func (p *printer) predicates(predicates []Expr) {
+	for _, predicate := range predicates {
+		if p.full() {
+			break
+		}
+		p.enclose("[", predicate, "]")
+	}
+}
+
+func (p *printer) qname(sigil, prefix, local string) {
+	switch {
+	case prefix == "":
+		p.print(sigil, local)
+	case isBracedURI(prefix):
+		p.print(sigil, prefix, local)
+	default:
+		p.print(sigil, prefix, ":", local)
+	}
+}
+
+func (p *printer) number(f float64) {
+	switch {
+	case math.IsNaN(f):
+		p.print("(0 div 0)")
+	case math.IsInf(f, 1):
+		p.print("(1 div 0)")
+	case math.IsInf(f, -1):
+		p.print("(-1 div 0)")
+	case math.Signbit(f):
+		p.print("(-", strconv.FormatFloat(-f, 'f', -1, 64), ")")
+	default:
+		p.print(strconv.FormatFloat(f, 'f', -1, 64))
+	}
+}
+
+func (p *printer) literal(s string) {
+	switch {
+	case !strings.Contains(s, `"`):
+		p.print(`"`, s, `"`)
+	case !strings.Contains(s, `'`):
+		p.print(`'`, s, `'`)
+	case p.config.Version >= XPath20:
+		// quote character is escaped by doubling it
+		p.print(`"`, strings.Replace(s, `"`, `""`, -1), `"`)
+	default:
+		// XPath 1.0 literals have no escape syntax
+		p.print("concat(")
+		for i, part := range strings.Split(s, `"`) {
+			if i > 0 {
+				p.print(`, '"', `)
+			}
+			p.print(`"`, part, `"`)
+		}
+		p.print(")")
+	}
+}
+
+// postfixBase returns the primary expression, to which the predicates,
+// dynamic calls and lookups of expr are applied.
+func postfixBase(expr Expr) Expr {
+	for {
+		switch e := expr.(type) {
+		case *FilterExpr:
+			expr = e.Expr
+		case *DynamicCallExpr:
+			expr = e.Func
+		case *LookupExpr:
+			if e.Expr == nil {
+				return expr
+			}
+			expr = e.Expr
+		default:
+			return expr
+		}
+	}
+}
+
+// isLiteral tells whether expr is printed as literal.
+func isLiteral(expr Expr) bool {
+	switch e := expr.(type) {
+	case *Number:
+		f := e.Value
+		return !math.IsNaN(f) && !math.IsInf(f, 0) && !math.Signbit(f)
+	case *String:
+		return true
+	}
+	return false
+}
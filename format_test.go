@@ -0,0 +1,590 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"math"
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+var roundTripXPaths = []string{
+	`1`,
+	`-1`,
+	`-(-1)`,
+	`- - $x`,
+	`1.5 + .5 * 2`,
+	`(1 + 2) * 3`,
+	`1 - (2 - 3)`,
+	`"it's"`,
+	`'say "hi"'`,
+	`$ns:var`,
+	`/`,
+	`/ | a`,
+	`(/) * 2`,
+	`//a/b[@c = 'd'][2]//e`,
+	`a/.././@*`,
+	`ns:a/ns:*/text()/comment()/node()`,
+	`processing-instruction()`,
+	`processing-instruction('xsl')`,
+	`(/a/b)[5]`,
+	`(/a/b)/c`,
+	`$x[1]/a`,
+	`$x[1][2]`,
+	`($x[1])[2]`,
+	`(1)[1]`,
+	`(1[1])/a`,
+	`("x")/a`,
+	`f(1, ns:g(2), /a)//b`,
+	`document('test.xml')/*`,
+	`(a)//b`,
+	`(.)/`,
+	`$x[1]/`,
+	`$x//`,
+	`a or b and c`,
+	`a = b != c < d <= e > f >= g`,
+	`a | b`,
+	`-a | b`,
+	`1 mod 2 div 3`,
+	`child::and and or`,
+	`(a or b) or c`,
+	`a and (b and c)`,
+	`a and b and c`,
+	`(a | b) | c`,
+	`(a | b) = c`,
+	`-(a | b) + 1`,
+}
+
+// roundTripXPaths20 are the XPath 2.0 expressions, which are not
+// valid XPath 1.0.
+var roundTripXPaths20 = []string{
+	`for $x in a return $x`,
+	`for $x in a, $ns:y in $x/b return ($x, $ns:y)`,
+	`for $x in for $y in a return $y return -$x`,
+	`some $x in a satisfies $x = 1`,
+	`every $x in a, $y in b satisfies $x < $y`,
+	`if (a) then b else c`,
+	`if (a, b) then for $x in c return $x else ()`,
+	`(if (a) then 1 else 2) + 3`,
+	`a or (some $x in b satisfies c)`,
+	`-(every $x in a satisfies b)`,
+	`1 to 10`,
+	`1 to 2 + 3`,
+	`(1 to 2) to 3`,
+	`1 = (2 < 3)`,
+	`(1 = 2) = 3`,
+	`a = b or c != d and e < f`,
+	`(1, 2, 3)`,
+	`()`,
+	`(a, (b, c))`,
+	`(1, 2)[1]/a`,
+	`((), 2)[1]`,
+	`f((1, 2), ())`,
+	`-a | b`,
+	`-(a | b) * 2`,
+	`a[1, 2]`,
+	`a, b`,
+	`$x instance of xs:integer*`,
+	`. castable as xs:date`,
+	`a treat as element(foo)`,
+	`a instance of item()+`,
+	`a instance of empty-sequence()`,
+	`a instance of element(*, xs:anyType?)?`,
+	`a instance of attribute(ns:b, xs:string)`,
+	`a instance of attribute()`,
+	`a instance of document-node(schema-element(ns:c))`,
+	`a instance of document-node(element(c))`,
+	`a treat as schema-attribute(d)`,
+	`a instance of node()*`,
+	`a treat as text()`,
+	`a instance of processing-instruction(pi)`,
+	`-a cast as xs:int? + 1`,
+	`(a instance of xs:integer) + 1`,
+	`1 * (a treat as item()) + 2`,
+	`(a | b instance of element()) * 1`,
+	`-(a instance of comment()) + 1`,
+	`(a cast as xs:int) cast as xs:string`,
+	`a castable as xs:int instance of xs:boolean`,
+	`(a instance of xs:int) treat as item()`,
+	`child::element(a)/attribute::attribute(*)`,
+	`//schema-element(x)[1]`,
+	`*:a/@*:b`,
+	`child::*:div[1]`,
+	`document-node()`,
+	`a eq b`,
+	`$x ne 1 and $y lt 2`,
+	`a le b or a ge c`,
+	`(1 gt 2) eq 3`,
+	`a is b`,
+	`a << b`,
+	`a/b >> c`,
+	`7 idiv 2`,
+	`a idiv b mod c`,
+	`a intersect b except c`,
+	`a intersect (b except c)`,
+	`(a | b) intersect c`,
+	`a | b except c`,
+	`(a except b) instance of node()*`,
+	`'it''s here'`,
+	`"say ""it's"" again"`,
+	`1e3`,
+	`1.5E-2`,
+	`(: c :) 17`,
+	`1 (: a (: nested :) b :) + 2`,
+	`+1`,
+	`-+a`,
+	`+-a`,
+	`1 - +2`,
+	`+(1 + 2)`,
+	`a/string()`,
+	`$x/f()`,
+	`a/(b | c)`,
+	`a//(b)`,
+	`1/a`,
+	`'s'/a`,
+	`/f()`,
+	`//f()`,
+	`/1`,
+	`a/f()/b//c/g()`,
+	`$x//f()/$y`,
+	`a/$x[1]/b`,
+	`a/(1 + 2)[1]`,
+	`$a union $b/c`,
+}
+
+// roundTripXPaths30 are the XPath 3.0 expressions, which are not
+// valid XPath 2.0.
+var roundTripXPaths30 = []string{
+	`let $x := 1 return $x`,
+	`let $x := a, $ns:y := $x return ($x, $ns:y)`,
+	`(let $x := a return $x) + 1`,
+	`function($a as xs:integer, $b) as xs:integer* {$a + $b}`,
+	`function() {.}`,
+	`function($a) {let $b := $a return $b}`,
+	`fn:concat#3`,
+	`true#0`,
+	`$f(1, 2)`,
+	`$f()`,
+	`$f(1)[2](3)`,
+	`f(1)(2)`,
+	`(a)(1)`,
+	`(1)(2)/a`,
+	`function($a) {$a}(1)`,
+	`$f(1)/a`,
+	`a ! b ! c`,
+	`a ! (b ! c)`,
+	`-a ! b`,
+	`(-a) ! b`,
+	`a/b ! c[1]`,
+	`$x ! string() || "a"`,
+	`a || b = c`,
+	`a || (b || c)`,
+	`1 to 2 || 3`,
+	`(a ! b) instance of item()*`,
+	`$f instance of function(*)`,
+	`$f instance of function(*)+`,
+	`$f instance of function(xs:integer, item()*) as xs:string?`,
+	`$f instance of (function() as xs:integer)*`,
+	`$f instance of function(function(*)) as function() as empty-sequence()`,
+	`($f instance of function() as xs:integer) * 2`,
+	`function($f as function(*)) as function(node()) as xs:boolean {$f}`,
+	`namespace::namespace-node()`,
+	`a/namespace-node()[1]`,
+	`$n instance of namespace-node()*`,
+	`Q{http://x}f(1)`,
+	`Q{}f#2`,
+	`$Q{u}v + $Q{}v`,
+	`a/Q{u}b/@Q{u}*`,
+	`Q{a b}c`,
+	`let $Q{u}x := 1 return $Q{u}x`,
+	`$x instance of Q{http://www.w3.org/2001/XMLSchema}integer`,
+	`$x cast as Q{u}t?`,
+	`$x treat as element(Q{u}a, Q{u}t)`,
+}
+
+// roundTripXPaths31 are the XPath 3.1 expressions, which are not
+// valid XPath 3.0.
+var roundTripXPaths31 = []string{
+	`map {}`,
+	`map {"a": 1, "b": (2, 3), $k : map {1: ()}}`,
+	`map {a : b}`,
+	`[]`,
+	`[1, (2, 3), [4]]`,
+	`array {}`,
+	`array {1, 2}`,
+	`array {(1, 2)}`,
+	`[1, 2]/a`,
+	`map {"a": 1}?a`,
+	`$m?a`,
+	`$m?*`,
+	`$a?1`,
+	`$m?($k)`,
+	`$m?("a b")`,
+	`$m?()`,
+	`$m?(1, 2)`,
+	`$m?a?b[1]?*`,
+	`$m?a(1)?b`,
+	`$m?a/b`,
+	`a[?b = 1]`,
+	`?a`,
+	`?*[1]`,
+	`(a)?b`,
+	`1?a`,
+	`$s => upper-case()`,
+	`$s => fn:concat("a", "b") => string-length()`,
+	`$s => $f(1)`,
+	`$s => (f#1)()`,
+	`-a => f()`,
+	`-(a => f())`,
+	`a => f() cast as xs:int`,
+	`(a cast as xs:int) => f()`,
+	`a => f() + 1`,
+	`a ! b => f()`,
+	`map {"a": [1, 2]}?a?2 => sum()`,
+	`$m instance of map(*)`,
+	`$m instance of map(xs:string, item()*)+`,
+	`$m treat as map(Q{u}k, empty-sequence())`,
+	`$a instance of array(*)?`,
+	`$a instance of array(array(xs:integer+))`,
+	`function($m as map(xs:int, map(*))) as array(*) {[$m]}`,
+	`$f instance of function(map(*)) as array(*)*`,
+	`function() {}`,
+	`function($a) as empty-sequence() {}(1)`,
+}
+
+type roundTrip struct {
+	xpath   string
+	version Version
+	expr    Expr
+}
+
+// roundTrips returns roundTripXPaths, roundTripXPaths20, roundTripXPaths30
+// and roundTripXPaths31, parsed in their versions.
+func roundTrips() []roundTrip {
+	var list []roundTrip
+	for _, xpath := range roundTripXPaths {
+		list = append(list, roundTrip{xpath, XPath10, MustParse(xpath)})
+	}
+	options := &ParseOptions{Version: XPath20}
+	for _, xpath := range roundTripXPaths20 {
+		expr, err := options.Parse(xpath)
+		if err != nil {
+			panic(err)
+		}
+		list = append(list, roundTrip{xpath, XPath20, expr})
+	}
+	options = &ParseOptions{Version: XPath30}
+	for _, xpath := range roundTripXPaths30 {
+		expr, err := options.Parse(xpath)
+		if err != nil {
+			panic(err)
+		}
+		list = append(list, roundTrip{xpath, XPath30, expr})
+	}
+	options = &ParseOptions{Version: XPath31}
+	for _, xpath := range roundTripXPaths31 {
+		expr, err := options.Parse(xpath)
+		if err != nil {
+			panic(err)
+		}
+		list = append(list, roundTrip{xpath, XPath31, expr})
+	}
+	return list
+}
+
+func TestFormat(t *testing.T) {
+	modes := []PrintMode{0, Abbreviate, MinimalParens, Abbreviate | MinimalParens}
+	for _, mode := range modes {
+		for _, width := range []int{0, 1} {
+			for _, test := range roundTrips() {
+				config := &PrintConfig{Mode: mode, Version: test.version, Width: width}
+				s := config.Format(test.expr)
+				got, err := (&ParseOptions{Version: test.version}).Parse(s)
+				if err != nil {
+					t.Errorf("FAIL: %+v: %s: Format returned %s: %v", config, test.xpath, s, err)
+					continue
+				}
+				if !Equal(test.expr, got) {
+					t.Errorf("FAIL: %+v: %s: Format returned %s, which parses to %s", config, test.xpath, s, got)
+				}
+			}
+		}
+	}
+}
+
+func TestFormatAbbreviated(t *testing.T) {
+	tests := map[string]string{
+		`//a/@b`:                         `//a/@b`,
+		`a//b`:                           `a//b`,
+		`$x//b`:                          `$x//b`,
+		`.//..`:                          `.//..`,
+		`self::node()[1]`:                `self::node()[1]`,
+		`a/descendant-or-self::node()`:   `a/descendant-or-self::node()`,
+		`ancestor::a/@node()`:            `ancestor::a/@node()`,
+		`1 + 2 * 3`:                      `1 + 2 * 3`,
+		`(1 + 2) * 3`:                    `(1 + 2) * 3`,
+		`1 - (2 - 3)`:                    `1 - (2 - 3)`,
+		`(1 - 2) - 3`:                    `1 - 2 - 3`,
+		`-a * -b`:                        `-a * -b`,
+		`-(1 + 2)`:                       `-(1 + 2)`,
+		`-(-1)`:                          `--1`,
+		`- - $x`:                         `--$x`,
+		`(a or b) or c`:                  `a or b or c`,
+		`a and (b and c)`:                `a and (b and c)`,
+		`a = b or c != d and e < f`:      `a = b or c != d and e < f`,
+		`a[b or c][d and (e or f)]`:      `a[b or c][d and (e or f)]`,
+		`(a | b)[1] | c`:                 `(a | b)[1] | c`,
+		`(a | b) | c`:                    `a | b | c`,
+		`a | (b | c)`:                    `a | (b | c)`,
+		`(a | b) = c`:                    `a | b = c`,
+		`-(a | b)`:                       `-a | b`,
+		`(/)*2`:                          `(/) * 2`,
+		`f(1 + 2, -3)`:                   `f(1 + 2, -3)`,
+		`(a or b) and (c or d)`:          `(a or b) and (c or d)`,
+		`child::a/attribute::b[. = ..]`:  `a/@b[. = ..]`,
+		`descendant-or-self::node()/a`:   `descendant-or-self::node()/a`,
+		`/descendant-or-self::node()/a`:  `//a`,
+		`/descendant-or-self::node()[1]`: `/descendant-or-self::node()[1]`,
+	}
+	config := &PrintConfig{Mode: Abbreviate | MinimalParens}
+	for xpath, want := range tests {
+		if got := config.Format(MustParse(xpath)); got != want {
+			t.Errorf("FAIL: %s: got %s, want %s", xpath, got, want)
+		}
+	}
+}
+
+func TestFormat20(t *testing.T) {
+	tests := map[string]string{
+		`for $x in a, $y in b return $x + $y`: `for $x in a return for $y in b return $x + $y`,
+		`some $x in a satisfies b`:            `some $x in a satisfies b`,
+		`every $x in (a) satisfies (b)`:       `every $x in a satisfies b`,
+		`if ((a)) then (b, c) else ()`:        `if (a) then (b, c) else ()`,
+		`(if (a) then b else c) or d`:         `(if (a) then b else c) or d`,
+		`a, b`:                                `(a, b)`,
+		`((1, 2))[1]`:                         `(1, 2)[1]`,
+		`(1 to 2) to 3`:                       `(1 to 2) to 3`,
+		`1 to (2 + 3)`:                        `1 to 2 + 3`,
+		`(1 = 2) != 3`:                        `(1 = 2) != 3`,
+		`(1 < 2) = 3`:                         `(1 < 2) = 3`,
+		`-(a | b)`:                            `-(a | b)`,
+		`(-a) | b`:                            `-a | b`,
+		`(a | b) = c`:                         `a | b = c`,
+		`(a instance of xs:integer) + 1`:      `(a instance of xs:integer) + 1`,
+		`(a instance of xs:integer*) + 1`:     `a instance of xs:integer* + 1`,
+		`(a instance of xs:integer) - 1`:      `a instance of xs:integer - 1`,
+		`(a cast as xs:int) + 1`:              `a cast as xs:int + 1`,
+		`(-a) cast as xs:int`:                 `-a cast as xs:int`,
+		`-(a cast as xs:int)`:                 `-(a cast as xs:int)`,
+		`(a treat as item()) instance of b`:   `a treat as item() instance of b`,
+		`(a instance of item()) treat as b`:   `(a instance of item()) treat as b`,
+		`a/child::element()`:                  `a/element()`,
+		`attribute::attribute(a, b)`:          `@attribute(a, b)`,
+		`child::*:a/attribute::*:b`:           `*:a/@*:b`,
+		`(a eq b) = c`:                        `(a eq b) = c`,
+		`(a is b) or c`:                       `a is b or c`,
+		`(a intersect b) except c`:            `a intersect b except c`,
+		`a intersect (b | c)`:                 `a intersect (b | c)`,
+		`(a idiv 2) * 3`:                      `a idiv 2 * 3`,
+		`'it''s'`:                             `"it's"`,
+		`'say "it''s" again'`:                 `"say ""it's"" again"`,
+		`1e3`:                                 `1000`,
+		`a (: comment :) + 1`:                 `a + 1`,
+		`+(1 + 2)`:                            `+(1 + 2)`,
+		`1 - +2`:                              `1 - +2`,
+		`a/(b)`:                               `a/(b)`,
+		`(a/f())/b`:                           `a/f()/b`,
+		`/(f())`:                              `/f()`,
+		`a/descendant-or-self::node()/f()`:    `a//f()`,
+		`(1)/a`:                               `(1)/a`,
+		`a/(-1)`:                              `a/(-1)`,
+		`a union b`:                           `a | b`,
+		`(a union b) intersect c`:             `(a | b) intersect c`,
+	}
+	config := &PrintConfig{Mode: Abbreviate | MinimalParens, Version: XPath20}
+	options := &ParseOptions{Version: XPath20}
+	for xpath, want := range tests {
+		expr, err := options.Parse(xpath)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+			continue
+		}
+		if got := config.Format(expr); got != want {
+			t.Errorf("FAIL: %s: got %s, want %s", xpath, got, want)
+		}
+	}
+}
+
+func TestFormat30(t *testing.T) {
+	tests := map[string]string{
+		`let $x := 1, $y := 2 return $x + $y`:   `let $x := 1 return let $y := 2 return $x + $y`,
+		`(let $x := a return $x) or b`:          `(let $x := a return $x) or b`,
+		`function ( $a as item() ) { ( $a ) }`:  `function($a as item()) {$a}`,
+		`function() as xs:int {1, 2}`:           `function() as xs:int {(1, 2)}`,
+		`fn:concat # 3`:                         `fn:concat#3`,
+		`($f)(1)`:                               `$f(1)`,
+		`($f[1])(2)`:                            `$f[1](2)`,
+		`(1)(2)`:                                `1(2)`,
+		`(a ! b) ! c`:                           `a ! b ! c`,
+		`a ! (b ! c)`:                           `a ! (b ! c)`,
+		`(-a) ! b`:                              `(-a) ! b`,
+		`-(a ! b)`:                              `-a ! b`,
+		`(a || b) || c`:                         `a || b || c`,
+		`a || (b = c)`:                          `a || (b = c)`,
+		`(a || b) = c`:                          `a || b = c`,
+		`(1 to 2) || 3`:                         `1 to 2 || 3`,
+		`(a || b) to c`:                         `(a || b) to c`,
+		`child::a ! attribute::b`:               `a ! @b`,
+		`$f(1)/child::a`:                        `$f(1)/a`,
+		`(function($a) {$a})(1)`:                `function($a) {$a}(1)`,
+		`$f instance of (function(*))`:          `$f instance of function(*)`,
+		`$f instance of (function(*))?`:         `$f instance of function(*)?`,
+		`$f treat as ((item()))+`:               `$f treat as item()+`,
+		`$f instance of function ( ) as item()`: `$f instance of function() as item()`,
+		`child::Q{u}a/attribute::Q{u}*`:         `Q{u}a/@Q{u}*`,
+	}
+	config := &PrintConfig{Mode: Abbreviate | MinimalParens, Version: XPath30}
+	options := &ParseOptions{Version: XPath30}
+	for xpath, want := range tests {
+		expr, err := options.Parse(xpath)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+			continue
+		}
+		if got := config.Format(expr); got != want {
+			t.Errorf("FAIL: %s: got %s, want %s", xpath, got, want)
+		}
+	}
+}
+
+func TestFormat31(t *testing.T) {
+	tests := map[string]string{
+		`map{"a":1,"b":2}`:                `map {"a": 1, "b": 2}`,
+		`map { }`:                         `map {}`,
+		`[ 1 , ( 2 ) ]`:                   `[1, 2]`,
+		`array { }`:                       `array {}`,
+		`function ( ) { }`:                `function() {}`,
+		`function() {()}`:                 `function() {}`,
+		`array { 1, 2 }`:                  `array {1, 2}`,
+		`$m ? a`:                          `$m?a`,
+		`$m?("a")`:                        `$m?a`,
+		`$m?(1)`:                          `$m?1`,
+		`$m?(1.5)`:                        `$m?(1.5)`,
+		`$m?(-1)`:                         `$m?(-1)`,
+		`$m?(a)`:                          `$m?(a)`,
+		`$m?( * )`:                        `$m?(*)`,
+		`$m instance of map ( * )`:        `$m instance of map(*)`,
+		`$a instance of array( xs:int )*`: `$a instance of array(xs:int)*`,
+		`$m?*`:                            `$m?*`,
+		`($m?a)?b`:                        `$m?a?b`,
+		`(child::a)?b`:                    `(a)?b`,
+		`(1)?a`:                           `1?a`,
+		`(1?a)/b`:                         `(1?a)/b`,
+		`(a => f()) => g()`:               `a => f() => g()`,
+		`(-a) => f()`:                     `-a => f()`,
+		`-(a => f())`:                     `-(a => f())`,
+		`(a + b) => f()`:                  `(a + b) => f()`,
+		`a => ($f)()`:                     `a => $f()`,
+		`a => ($f[1])(2)`:                 `a => ($f[1])(2)`,
+		`(a => f()) cast as xs:int`:       `a => f() cast as xs:int`,
+		`map {$k : (a => f())}`:           `map {$k: a => f()}`,
+		`(map {"a": 1})("a")`:             `map {"a": 1}("a")`,
+		`($a?1)[2]`:                       `$a?1[2]`,
+		`child::a[?b]`:                    `a[?b]`,
+		`(?a)?b`:                          `?a?b`,
+		`map {"a": (1, 2)}`:               `map {"a": (1, 2)}`,
+		`a ! (b => f())`:                  `a ! (b => f())`,
+		`(a ! b) => f()`:                  `a ! b => f()`,
+		`(a => f()) ! b`:                  `(a => f()) ! b`,
+	}
+	config := &PrintConfig{Mode: Abbreviate | MinimalParens, Version: XPath31}
+	options := &ParseOptions{Version: XPath31}
+	for xpath, want := range tests {
+		expr, err := options.Parse(xpath)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+			continue
+		}
+		if got := config.Format(expr); got != want {
+			t.Errorf("FAIL: %s: got %s, want %s", xpath, got, want)
+		}
+	}
+}
+
+func TestFormatValues(t *testing.T) {
+	tests := []struct {
+		expr Expr
+		want string
+		kind ExprKind // kind of tree, the text re-parses to
+	}{
+		{&String{Value: `a"b`}, `'a"b'`, KindString},
+		{&String{Value: `a'b`}, `"a'b"`, KindString},
+		{&String{Value: `a"b'c`}, `concat("a", '"', "b'c")`, KindFuncCall},
+		{&Number{Value: 1e21}, `1000000000000000000000`, KindNumber},
+		{&Number{Value: -2}, `(-2)`, KindNegateExpr},
+		{&Number{Value: math.Inf(1)}, `(1 div 0)`, KindBinaryExpr},
+		{&Number{Value: math.NaN()}, `(0 div 0)`, KindBinaryExpr},
+		{MustParse(`//a[1]`), `/descendant-or-self::node()/child::a[1]`, KindLocationPath},
+		{MustParse(`(1+2)*-3`), `(1 + 2) * (-3)`, KindBinaryExpr},
+	}
+	for _, test := range tests {
+		got := Format(test.expr)
+		if got != test.want {
+			t.Errorf("FAIL: got %s, want %s", got, test.want)
+			continue
+		}
+		expr, err := Parse(got)
+		switch {
+		case err != nil:
+			t.Errorf("FAIL: %s: %v", got, err)
+		case expr.Kind() != test.kind:
+			t.Errorf("FAIL: %s: re-parsed to %v, want %v", got, expr.Kind(), test.kind)
+		case expr.Kind() == test.expr.Kind() && !Equal(expr, test.expr):
+			t.Errorf("FAIL: %s: re-parsed to %v", got, expr)
+		}
+	}
+}
+
+func TestFormatWidth(t *testing.T) {
+	expr := MustParse(`//book[@price > 10 and (@year = 2000 or @year = 2001) and contains(title, 'XPath guide')]/author[1]`)
+	tests := []struct {
+		width int
+		want  string
+	}{
+		{0, `//book[@price > 10 and (@year = 2000 or @year = 2001) and contains(title, "XPath guide")]/author[1]`},
+		{60, `//book[
+  @price > 10
+  and (@year = 2000 or @year = 2001)
+  and contains(title, "XPath guide")
+]/author[1]`},
+		{30, `//book[
+  @price > 10
+  and (
+    @year = 2000
+    or @year = 2001
+  )
+  and contains(
+    title,
+    "XPath guide"
+  )
+]/author[1]`},
+	}
+	for _, test := range tests {
+		config := &PrintConfig{Mode: Abbreviate | MinimalParens, Width: test.width, Indent: 2}
+		if got := config.Format(expr); got != test.want {
+			t.Errorf("FAIL: width %d: got\n%s\nwant\n%s", test.width, got, test.want)
+		}
+	}
+
+	// width is measured in runes
+	expr = MustParse(`f('ä', 'ö')`)
+	for width, want := range map[int]string{11: `f("ä", "ö")`, 10: "f(\n  \"ä\",\n  \"ö\"\n)"} {
+		config := &PrintConfig{Width: width, Indent: 2}
+		if got := config.Format(expr); got != want {
+			t.Errorf("FAIL: width %d: got\n%s\nwant\n%s", width, got, want)
+		}
+	}
+}
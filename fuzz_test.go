@@ -0,0 +1,64 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package xpathparser_test
+
+import (
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+// FuzzParse checks that no xpath causes panic or stack overflow in any
+// version, and that every xpath parsed can be formatted and parsed back
+// to the same tree. The seed corpus in testdata/fuzz/FuzzParse holds
+// inputs near the limits.
+func FuzzParse(f *testing.F) {
+	for _, xpaths := range [][]string{roundTripXPaths, roundTripXPaths20, roundTripXPaths30, roundTripXPaths31, invalidXPaths} {
+		for _, xpath := range xpaths {
+			f.Add(xpath)
+		}
+	}
+	f.Fuzz(func(t *testing.T, xpath string) {
+		for _, version := range []Version{XPath10, XPath20, XPath30, XPath31} {
+			options := &ParseOptions{Version: version}
+			expr, err := options.Parse(xpath)
+			all, errs := options.ParseAll(xpath)
+			if all == nil || (err == nil) != (len(errs) == 0) {
+				t.Fatalf("%v: ParseAll got %v, %v, but Parse got %v", version, all, errs, err)
+			}
+			if err != nil {
+				if _, ok := err.(*Error); !ok {
+					t.Fatalf("%v: *Error expected, got %T", version, err)
+				}
+				(&PrintConfig{Version: version}).Format(all)
+			} else {
+				if !Equal(expr, all) {
+					t.Fatalf("%v: ParseAll got %v, but Parse got %v", version, all, expr)
+				}
+				for _, mode := range []PrintMode{MinimalParens, Abbreviate | MinimalParens} {
+					s := (&PrintConfig{Mode: mode, Version: version}).Format(expr)
+					got, err := options.Parse(s)
+					if err != nil {
+						t.Fatalf("%v: %s: %v", version, s, err)
+					}
+					if !Equal(got, expr) {
+						t.Fatalf("%v: %s: got %v, want %v", version, s, got, expr)
+					}
+				}
+			}
+
+			scanner := NewScanner(xpath, version, ScanWhitespace|ScanLenient)
+			for {
+				token, _ := scanner.Scan()
+				if token.Kind == TokenEOF {
+					break
+				}
+			}
+		}
+	})
+}
@@ -1 +1,3 @@
 module github.com/santhosh-tekuri/xpathparser
+
+go 1.18
@@ -0,0 +1,1545 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+)
+
+// JSONVersion is the version of JSON schema written by EncodeJSON.
+const JSONVersion = 1
+
+// EncodeJSON returns the JSON encoding of expr, wrapped in a versioned envelope:
+//
+//	{"version": 1, "expr": EXPR}
+//
+// Each node is encoded as a JSON object. Expressions and node tests carry
+// a "kind" member naming their type, as returned by ExprKind.String:
+//
+//	{"kind": "LocationPath", "abs": BOOL, "steps": [STEP...]}
+//	{"kind": "FilterExpr", "expr": EXPR, "predicates": [EXPR...]}
+//	{"kind": "PathExpr", "filter": EXPR, "locationPath": LOCATIONPATH}
+//	{"kind": "BinaryExpr", "op": OP, "lhs": EXPR, "rhs": EXPR}
+//	{"kind": "NegateExpr", "expr": EXPR}
+//	{"kind": "VarRef", "prefix": STRING, "local": STRING}
+//	{"kind": "FuncCall", "prefix": STRING, "local": STRING, "args": [EXPR...]}
+//	{"kind": "Number", "value": NUMBER}
+//	{"kind": "String", "value": STRING}
+//	{"kind": "BadExpr"}
+//	{"kind": "ForExpr", "prefix": STRING, "local": STRING, "in": EXPR, "return": EXPR}
+//	{"kind": "QuantifiedExpr", "every": BOOL, "prefix": STRING, "local": STRING, "in": EXPR, "satisfies": EXPR}
+//	{"kind": "IfExpr", "cond": EXPR, "then": EXPR, "else": EXPR}
+//	{"kind": "SequenceExpr", "items": [EXPR...]}
+//	{"kind": "InstanceOfExpr", "expr": EXPR, "sequenceType": SEQUENCETYPE}
+//	{"kind": "TreatExpr", "expr": EXPR, "sequenceType": SEQUENCETYPE}
+//	{"kind": "CastableExpr", "expr": EXPR, "sequenceType": SEQUENCETYPE}
+//	{"kind": "CastExpr", "expr": EXPR, "sequenceType": SEQUENCETYPE}
+//	{"kind": "UnaryPlusExpr", "expr": EXPR}
+//	{"kind": "SlashExpr", "lhs": EXPR, "rhs": EXPR}
+//	{"kind": "LetExpr", "prefix": STRING, "local": STRING, "expr": EXPR, "return": EXPR}
+//	{"kind": "InlineFunctionExpr", "params": [PARAM...], "sequenceType": SEQUENCETYPE, "body": EXPR}
+//	{"kind": "NamedFunctionRef", "prefix": STRING, "local": STRING, "arity": NUMBER}
+//	{"kind": "DynamicCallExpr", "func": EXPR, "args": [EXPR...]}
+//	{"kind": "SimpleMapExpr", "lhs": EXPR, "rhs": EXPR}
+//	{"kind": "MapConstructor", "entries": [MAPENTRY...]}
+//	{"kind": "ArrayConstructor", "curly": BOOL, "members": [EXPR...]}
+//	{"kind": "LookupExpr", "expr": EXPR, "key": EXPR}
+//	{"kind": "ArrowExpr", "expr": EXPR, "call": EXPR}
+//
+//	STEP: {"axis": AXIS, "nodeTest": NODETEST, "predicates": [EXPR...]}
+//
+//	NODETEST:
+//	{"kind": "NameTest", "prefix": STRING, "local": STRING}
+//	{"kind": "NodeTypeTest", "type": "comment()" | "text()" | "node()"}
+//	{"kind": "PITest", "target": STRING}
+//	{"kind": "KindTest", "test": TEST, "prefix": STRING, "local": STRING,
+//	 "typePrefix": STRING, "typeLocal": STRING, "nillable": BOOL, "element": NODETEST}
+//
+//	SEQUENCETYPE: {"itemType": ITEMTYPE, "occurrence": "" | "?" | "*" | "+"}
+//
+//	PARAM: {"prefix": STRING, "local": STRING, "sequenceType": SEQUENCETYPE}
+//
+//	MAPENTRY: {"key": EXPR, "value": EXPR}
+//
+//	ITEMTYPE: NODETEST other than NameTest, or
+//	{"kind": "AtomicType", "prefix": STRING, "local": STRING}
+//	{"kind": "AnyItem"}
+//	{"kind": "FunctionTest", "paramTypes": [SEQUENCETYPE...], "returnType": SEQUENCETYPE}
+//	{"kind": "MapTest", "keyType": ATOMICTYPE, "valueType": SEQUENCETYPE}
+//	{"kind": "ArrayTest", "memberType": SEQUENCETYPE}
+//
+// ATOMICTYPE is the AtomicType object above. TEST is the name of kind test
+// as returned by TestKind.String, such as "element". The "itemType" of
+// empty-sequence() is omitted, and so is the "sequenceType" of PARAM and
+// InlineFunctionExpr without declared type. The "paramTypes" and
+// "returnType" of function(*), the "keyType" and "valueType" of map(*)
+// and the "memberType" of array(*) are omitted. The "expr" of unary
+// LookupExpr and the "key" of wildcard LookupExpr are omitted.
+//
+// AXIS is the name of axis as returned by Axis.String, such as "child" or
+// "descendant-or-self". OP is the operator as returned by Op.String, such as
+// "+", "!=", "div" or "|". Number values that JSON cannot represent are
+// encoded as one of the strings "NaN", "Infinity" and "-Infinity".
+//
+// Nodes additionally carry their Span, if known:
+//
+//	"span": {"start": POS, "end": POS}
+//	POS: {"offset": NUMBER, "line": NUMBER, "column": NUMBER}
+//
+// Empty "predicates", "args", "items", "params", "entries", "members" and
+// "paramTypes", empty "prefix", "local", "typePrefix", "typeLocal" and
+// "occurrence", missing "element" and false "abs", "every", "nillable" and
+// "curly" may be omitted, except that "local" is required by all but
+// KindTest.
+//
+// The node types also implement json.Marshaler and json.Unmarshaler using
+// this encoding, without the envelope.
+func EncodeJSON(expr Expr) ([]byte, error) {
+	return json.Marshal(struct {
+		Version int  `json:"version"`
+		Expr    Expr `json:"expr"`
+	}{JSONVersion, expr})
+}
+
+// DecodeJSON decodes expression encoded by EncodeJSON.
+// See EncodeJSON for the schema.
+func DecodeJSON(data []byte) (Expr, error) {
+	var envelope struct {
+		Version int             `json:"version"`
+		Expr    json.RawMessage `json:"expr"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Version != JSONVersion {
+		return nil, fmt.Errorf("xpathparser: unsupported json version %d", envelope.Version)
+	}
+	return decodeJSONExpr(envelope.Expr)
+}
+
+// jsonSpan returns nil for zero span, so that it is omitted.
+func jsonSpan(span Span) *Span {
+	if span == (Span{}) {
+		return nil
+	}
+	return &span
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (lp *LocationPath) MarshalJSON() ([]byte, error) {
+	steps := lp.Steps
+	if steps == nil {
+		steps = []*Step{}
+	}
+	return json.Marshal(struct {
+		Kind  string  `json:"kind"`
+		Abs   bool    `json:"abs"`
+		Steps []*Step `json:"steps"`
+		Span  *Span   `json:"span,omitempty"`
+	}{KindLocationPath.String(), lp.Abs, steps, jsonSpan(lp.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (f *FilterExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind       string `json:"kind"`
+		Expr       Expr   `json:"expr"`
+		Predicates []Expr `json:"predicates,omitempty"`
+		Span       *Span  `json:"span,omitempty"`
+	}{KindFilterExpr.String(), f.Expr, f.Predicates, jsonSpan(f.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (p *PathExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind         string        `json:"kind"`
+		Filter       Expr          `json:"filter"`
+		LocationPath *LocationPath `json:"locationPath"`
+		Span         *Span         `json:"span,omitempty"`
+	}{KindPathExpr.String(), p.Filter, p.LocationPath, jsonSpan(p.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (s *SlashExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		LHS  Expr   `json:"lhs"`
+		RHS  Expr   `json:"rhs"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindSlashExpr.String(), s.LHS, s.RHS, jsonSpan(s.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (b *BinaryExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Op   string `json:"op"`
+		LHS  Expr   `json:"lhs"`
+		RHS  Expr   `json:"rhs"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindBinaryExpr.String(), b.Op.String(), b.LHS, b.RHS, jsonSpan(b.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (n *NegateExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Expr Expr   `json:"expr"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindNegateExpr.String(), n.Expr, jsonSpan(n.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (u *UnaryPlusExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Expr Expr   `json:"expr"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindUnaryPlusExpr.String(), u.Expr, jsonSpan(u.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (vr *VarRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind   string `json:"kind"`
+		Prefix string `json:"prefix,omitempty"`
+		Local  string `json:"local"`
+		Span   *Span  `json:"span,omitempty"`
+	}{KindVarRef.String(), vr.Prefix, vr.Local, jsonSpan(vr.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (fc *FuncCall) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind   string `json:"kind"`
+		Prefix string `json:"prefix,omitempty"`
+		Local  string `json:"local"`
+		Args   []Expr `json:"args,omitempty"`
+		Span   *Span  `json:"span,omitempty"`
+	}{KindFuncCall.String(), fc.Prefix, fc.Local, fc.Args, jsonSpan(fc.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (n *Number) MarshalJSON() ([]byte, error) {
+	var value interface{} = n.Value
+	switch f := n.Value; {
+	case math.IsNaN(f):
+		value = "NaN"
+	case math.IsInf(f, 1):
+		value = "Infinity"
+	case math.IsInf(f, -1):
+		value = "-Infinity"
+	}
+	return json.Marshal(struct {
+		Kind  string      `json:"kind"`
+		Value interface{} `json:"value"`
+		Span  *Span       `json:"span,omitempty"`
+	}{KindNumber.String(), value, jsonSpan(n.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (s *String) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind  string `json:"kind"`
+		Value string `json:"value"`
+		Span  *Span  `json:"span,omitempty"`
+	}{KindString.String(), s.Value, jsonSpan(s.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (be *BadExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindBadExpr.String(), jsonSpan(be.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (f *ForExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind   string `json:"kind"`
+		Prefix string `json:"prefix,omitempty"`
+		Local  string `json:"local"`
+		In     Expr   `json:"in"`
+		Return Expr   `json:"return"`
+		Span   *Span  `json:"span,omitempty"`
+	}{KindForExpr.String(), f.Prefix, f.Local, f.In, f.Return, jsonSpan(f.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (q *QuantifiedExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind      string `json:"kind"`
+		Every     bool   `json:"every,omitempty"`
+		Prefix    string `json:"prefix,omitempty"`
+		Local     string `json:"local"`
+		In        Expr   `json:"in"`
+		Satisfies Expr   `json:"satisfies"`
+		Span      *Span  `json:"span,omitempty"`
+	}{KindQuantifiedExpr.String(), q.Every, q.Prefix, q.Local, q.In, q.Satisfies, jsonSpan(q.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (i *IfExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Cond Expr   `json:"cond"`
+		Then Expr   `json:"then"`
+		Else Expr   `json:"else"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindIfExpr.String(), i.Cond, i.Then, i.Else, jsonSpan(i.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (s *SequenceExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind  string `json:"kind"`
+		Items []Expr `json:"items,omitempty"`
+		Span  *Span  `json:"span,omitempty"`
+	}{KindSequenceExpr.String(), s.Items, jsonSpan(s.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (i *InstanceOfExpr) MarshalJSON() ([]byte, error) {
+	return marshalJSONTypeExpr(KindInstanceOfExpr, i.Expr, i.Type, i.Span)
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (t *TreatExpr) MarshalJSON() ([]byte, error) {
+	return marshalJSONTypeExpr(KindTreatExpr, t.Expr, t.Type, t.Span)
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (c *CastableExpr) MarshalJSON() ([]byte, error) {
+	return marshalJSONTypeExpr(KindCastableExpr, c.Expr, c.Type, c.Span)
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (c *CastExpr) MarshalJSON() ([]byte, error) {
+	return marshalJSONTypeExpr(KindCastExpr, c.Expr, c.Type, c.Span)
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (l *LetExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind   string `json:"kind"`
+		Prefix string `json:"prefix,omitempty"`
+		Local  string `json:"local"`
+		Expr   Expr   `json:"expr"`
+		Return Expr   `json:"return"`
+		Span   *Span  `json:"span,omitempty"`
+	}{KindLetExpr.String(), l.Prefix, l.Local, l.Expr, l.Return, jsonSpan(l.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (f *InlineFunctionExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind         string        `json:"kind"`
+		Params       []*Param      `json:"params,omitempty"`
+		SequenceType *SequenceType `json:"sequenceType,omitempty"`
+		Body         Expr          `json:"body"`
+		Span         *Span         `json:"span,omitempty"`
+	}{KindInlineFunctionExpr.String(), f.Params, f.Type, f.Body, jsonSpan(f.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (p *Param) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Prefix       string        `json:"prefix,omitempty"`
+		Local        string        `json:"local"`
+		SequenceType *SequenceType `json:"sequenceType,omitempty"`
+		Span         *Span         `json:"span,omitempty"`
+	}{p.Prefix, p.Local, p.Type, jsonSpan(p.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (r *NamedFunctionRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind   string `json:"kind"`
+		Prefix string `json:"prefix,omitempty"`
+		Local  string `json:"local"`
+		Arity  int    `json:"arity"`
+		Span   *Span  `json:"span,omitempty"`
+	}{KindNamedFunctionRef.String(), r.Prefix, r.Local, r.Arity, jsonSpan(r.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (d *DynamicCallExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Func Expr   `json:"func"`
+		Args []Expr `json:"args,omitempty"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindDynamicCallExpr.String(), d.Func, d.Args, jsonSpan(d.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (s *SimpleMapExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		LHS  Expr   `json:"lhs"`
+		RHS  Expr   `json:"rhs"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindSimpleMapExpr.String(), s.LHS, s.RHS, jsonSpan(s.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (m *MapConstructor) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind    string      `json:"kind"`
+		Entries []*MapEntry `json:"entries,omitempty"`
+		Span    *Span       `json:"span,omitempty"`
+	}{KindMapConstructor.String(), m.Entries, jsonSpan(m.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (e *MapEntry) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Key   Expr  `json:"key"`
+		Value Expr  `json:"value"`
+		Span  *Span `json:"span,omitempty"`
+	}{e.Key, e.Value, jsonSpan(e.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (a *ArrayConstructor) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind    string `json:"kind"`
+		Curly   bool   `json:"curly,omitempty"`
+		Members []Expr `json:"members,omitempty"`
+		Span    *Span  `json:"span,omitempty"`
+	}{KindArrayConstructor.String(), a.Curly, a.Members, jsonSpan(a.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (l *LookupExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Expr Expr   `json:"expr,omitempty"`
+		Key  Expr   `json:"key,omitempty"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindLookupExpr.String(), l.Expr, l.Key, jsonSpan(l.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (a *ArrowExpr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Expr Expr   `json:"expr"`
+		Call Expr   `json:"call"`
+		Span *Span  `json:"span,omitempty"`
+	}{KindArrowExpr.String(), a.Expr, a.Call, jsonSpan(a.Span)})
+}
+
+func marshalJSONTypeExpr(kind ExprKind, expr Expr, t *SequenceType, span Span) ([]byte, error) {
+	return json.Marshal(struct {
+		Kind         string        `json:"kind"`
+		Expr         Expr          `json:"expr"`
+		SequenceType *SequenceType `json:"sequenceType"`
+		Span         *Span         `json:"span,omitempty"`
+	}{kind.String(), expr, t, jsonSpan(span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (st *SequenceType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		ItemType   ItemType `json:"itemType,omitempty"`
+		Occurrence string   `json:"occurrence,omitempty"`
+		Span       *Span    `json:"span,omitempty"`
+	}{st.ItemType, st.Occurrence.String(), jsonSpan(st.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (at *AtomicType) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind   string `json:"kind"`
+		Prefix string `json:"prefix,omitempty"`
+		Local  string `json:"local"`
+		Span   *Span  `json:"span,omitempty"`
+	}{"AtomicType", at.Prefix, at.Local, jsonSpan(at.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (ai *AnyItem) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Span *Span  `json:"span,omitempty"`
+	}{"AnyItem", jsonSpan(ai.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (ft *FunctionTest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind       string          `json:"kind"`
+		ParamTypes []*SequenceType `json:"paramTypes,omitempty"`
+		ReturnType *SequenceType   `json:"returnType,omitempty"`
+		Span       *Span           `json:"span,omitempty"`
+	}{"FunctionTest", ft.ParamTypes, ft.ReturnType, jsonSpan(ft.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (mt *MapTest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind      string        `json:"kind"`
+		KeyType   *AtomicType   `json:"keyType,omitempty"`
+		ValueType *SequenceType `json:"valueType,omitempty"`
+		Span      *Span         `json:"span,omitempty"`
+	}{"MapTest", mt.KeyType, mt.ValueType, jsonSpan(mt.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (at *ArrayTest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind       string        `json:"kind"`
+		MemberType *SequenceType `json:"memberType,omitempty"`
+		Span       *Span         `json:"span,omitempty"`
+	}{"ArrayTest", at.MemberType, jsonSpan(at.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (kt *KindTest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind       string    `json:"kind"`
+		Test       string    `json:"test"`
+		Prefix     string    `json:"prefix,omitempty"`
+		Local      string    `json:"local,omitempty"`
+		TypePrefix string    `json:"typePrefix,omitempty"`
+		TypeLocal  string    `json:"typeLocal,omitempty"`
+		Nillable   bool      `json:"nillable,omitempty"`
+		Element    *KindTest `json:"element,omitempty"`
+		Span       *Span     `json:"span,omitempty"`
+	}{"KindTest", kt.Test.String(), kt.Prefix, kt.Local, kt.TypePrefix, kt.TypeLocal, kt.Nillable, kt.Element, jsonSpan(kt.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (s *Step) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Axis       string   `json:"axis"`
+		NodeTest   NodeTest `json:"nodeTest"`
+		Predicates []Expr   `json:"predicates,omitempty"`
+		Span       *Span    `json:"span,omitempty"`
+	}{s.Axis.String(), s.NodeTest, s.Predicates, jsonSpan(s.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (nt *NameTest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind   string `json:"kind"`
+		Prefix string `json:"prefix,omitempty"`
+		Local  string `json:"local"`
+		Span   *Span  `json:"span,omitempty"`
+	}{"NameTest", nt.Prefix, nt.Local, jsonSpan(nt.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (nt *NodeTypeTest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind string `json:"kind"`
+		Type string `json:"type"`
+		Span *Span  `json:"span,omitempty"`
+	}{"NodeTypeTest", nt.Type.String(), jsonSpan(nt.Span)})
+}
+
+// MarshalJSON implements json.Marshaler. See EncodeJSON for the schema.
+func (pt *PITest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Kind   string `json:"kind"`
+		Target string `json:"target"`
+		Span   *Span  `json:"span,omitempty"`
+	}{"PITest", pt.Target, jsonSpan(pt.Span)})
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (lp *LocationPath) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindLocationPath)
+	if err == nil {
+		*lp = *expr.(*LocationPath)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (f *FilterExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindFilterExpr)
+	if err == nil {
+		*f = *expr.(*FilterExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (p *PathExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindPathExpr)
+	if err == nil {
+		*p = *expr.(*PathExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (s *SlashExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindSlashExpr)
+	if err == nil {
+		*s = *expr.(*SlashExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (b *BinaryExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindBinaryExpr)
+	if err == nil {
+		*b = *expr.(*BinaryExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (n *NegateExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindNegateExpr)
+	if err == nil {
+		*n = *expr.(*NegateExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (u *UnaryPlusExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindUnaryPlusExpr)
+	if err == nil {
+		*u = *expr.(*UnaryPlusExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (vr *VarRef) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindVarRef)
+	if err == nil {
+		*vr = *expr.(*VarRef)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (fc *FuncCall) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindFuncCall)
+	if err == nil {
+		*fc = *expr.(*FuncCall)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (be *BadExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindBadExpr)
+	if err == nil {
+		*be = *expr.(*BadExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (f *ForExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindForExpr)
+	if err == nil {
+		*f = *expr.(*ForExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (q *QuantifiedExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindQuantifiedExpr)
+	if err == nil {
+		*q = *expr.(*QuantifiedExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (i *IfExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindIfExpr)
+	if err == nil {
+		*i = *expr.(*IfExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (s *SequenceExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindSequenceExpr)
+	if err == nil {
+		*s = *expr.(*SequenceExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (i *InstanceOfExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindInstanceOfExpr)
+	if err == nil {
+		*i = *expr.(*InstanceOfExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (t *TreatExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindTreatExpr)
+	if err == nil {
+		*t = *expr.(*TreatExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (c *CastableExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindCastableExpr)
+	if err == nil {
+		*c = *expr.(*CastableExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (c *CastExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindCastExpr)
+	if err == nil {
+		*c = *expr.(*CastExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (l *LetExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindLetExpr)
+	if err == nil {
+		*l = *expr.(*LetExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (f *InlineFunctionExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindInlineFunctionExpr)
+	if err == nil {
+		*f = *expr.(*InlineFunctionExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (p *Param) UnmarshalJSON(data []byte) error {
+	param, err := decodeJSONParam(data)
+	if err == nil {
+		*p = *param
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (r *NamedFunctionRef) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindNamedFunctionRef)
+	if err == nil {
+		*r = *expr.(*NamedFunctionRef)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (d *DynamicCallExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindDynamicCallExpr)
+	if err == nil {
+		*d = *expr.(*DynamicCallExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (s *SimpleMapExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindSimpleMapExpr)
+	if err == nil {
+		*s = *expr.(*SimpleMapExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (m *MapConstructor) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindMapConstructor)
+	if err == nil {
+		*m = *expr.(*MapConstructor)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (e *MapEntry) UnmarshalJSON(data []byte) error {
+	entry, err := decodeJSONMapEntry(data)
+	if err == nil {
+		*e = *entry
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (a *ArrayConstructor) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindArrayConstructor)
+	if err == nil {
+		*a = *expr.(*ArrayConstructor)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (l *LookupExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindLookupExpr)
+	if err == nil {
+		*l = *expr.(*LookupExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (a *ArrowExpr) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindArrowExpr)
+	if err == nil {
+		*a = *expr.(*ArrowExpr)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (n *Number) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindNumber)
+	if err == nil {
+		*n = *expr.(*Number)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (s *String) UnmarshalJSON(data []byte) error {
+	expr, err := decodeJSONExprOf(data, KindString)
+	if err == nil {
+		*s = *expr.(*String)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (s *Step) UnmarshalJSON(data []byte) error {
+	step, err := decodeJSONStep(data)
+	if err == nil {
+		*s = *step
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (nt *NameTest) UnmarshalJSON(data []byte) error {
+	nodeTest, err := decodeJSONNodeTestOf(data, "NameTest")
+	if err == nil {
+		*nt = *nodeTest.(*NameTest)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (nt *NodeTypeTest) UnmarshalJSON(data []byte) error {
+	nodeTest, err := decodeJSONNodeTestOf(data, "NodeTypeTest")
+	if err == nil {
+		*nt = *nodeTest.(*NodeTypeTest)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (pt *PITest) UnmarshalJSON(data []byte) error {
+	nodeTest, err := decodeJSONNodeTestOf(data, "PITest")
+	if err == nil {
+		*pt = *nodeTest.(*PITest)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (kt *KindTest) UnmarshalJSON(data []byte) error {
+	nodeTest, err := decodeJSONNodeTestOf(data, "KindTest")
+	if err == nil {
+		*kt = *nodeTest.(*KindTest)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (st *SequenceType) UnmarshalJSON(data []byte) error {
+	t, err := decodeJSONSequenceType(data)
+	if err == nil {
+		*st = *t
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (at *AtomicType) UnmarshalJSON(data []byte) error {
+	itemType, err := decodeJSONItemTypeOf(data, "AtomicType")
+	if err == nil {
+		*at = *itemType.(*AtomicType)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (ai *AnyItem) UnmarshalJSON(data []byte) error {
+	itemType, err := decodeJSONItemTypeOf(data, "AnyItem")
+	if err == nil {
+		*ai = *itemType.(*AnyItem)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (ft *FunctionTest) UnmarshalJSON(data []byte) error {
+	itemType, err := decodeJSONItemTypeOf(data, "FunctionTest")
+	if err == nil {
+		*ft = *itemType.(*FunctionTest)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (mt *MapTest) UnmarshalJSON(data []byte) error {
+	itemType, err := decodeJSONItemTypeOf(data, "MapTest")
+	if err == nil {
+		*mt = *itemType.(*MapTest)
+	}
+	return err
+}
+
+// UnmarshalJSON implements json.Unmarshaler. See EncodeJSON for the schema.
+func (at *ArrayTest) UnmarshalJSON(data []byte) error {
+	itemType, err := decodeJSONItemTypeOf(data, "ArrayTest")
+	if err == nil {
+		*at = *itemType.(*ArrayTest)
+	}
+	return err
+}
+
+// jsonNode holds members of all node objects.
+type jsonNode struct {
+	Kind         string            `json:"kind"`
+	Abs          bool              `json:"abs"`
+	Steps        []json.RawMessage `json:"steps"`
+	Expr         json.RawMessage   `json:"expr"`
+	Predicates   []json.RawMessage `json:"predicates"`
+	Filter       json.RawMessage   `json:"filter"`
+	LocationPath json.RawMessage   `json:"locationPath"`
+	Op           string            `json:"op"`
+	LHS          json.RawMessage   `json:"lhs"`
+	RHS          json.RawMessage   `json:"rhs"`
+	Prefix       string            `json:"prefix"`
+	Local        *string           `json:"local"`
+	Args         []json.RawMessage `json:"args"`
+	Value        interface{}       `json:"value"`
+	Axis         string            `json:"axis"`
+	NodeTest     json.RawMessage   `json:"nodeTest"`
+	Type         string            `json:"type"`
+	Target       *string           `json:"target"`
+	Every        bool              `json:"every"`
+	In           json.RawMessage   `json:"in"`
+	Return       json.RawMessage   `json:"return"`
+	Satisfies    json.RawMessage   `json:"satisfies"`
+	Cond         json.RawMessage   `json:"cond"`
+	Then         json.RawMessage   `json:"then"`
+	Else         json.RawMessage   `json:"else"`
+	Items        []json.RawMessage `json:"items"`
+	SequenceType json.RawMessage   `json:"sequenceType"`
+	ItemType     json.RawMessage   `json:"itemType"`
+	Occurrence   string            `json:"occurrence"`
+	Test         string            `json:"test"`
+	TypePrefix   string            `json:"typePrefix"`
+	TypeLocal    string            `json:"typeLocal"`
+	Nillable     bool              `json:"nillable"`
+	Element      json.RawMessage   `json:"element"`
+	ParamTypes   []json.RawMessage `json:"paramTypes"`
+	ReturnType   json.RawMessage   `json:"returnType"`
+	KeyType      json.RawMessage   `json:"keyType"`
+	ValueType    json.RawMessage   `json:"valueType"`
+	MemberType   json.RawMessage   `json:"memberType"`
+	Params       []json.RawMessage `json:"params"`
+	Body         json.RawMessage   `json:"body"`
+	Arity        *int              `json:"arity"`
+	Func         json.RawMessage   `json:"func"`
+	Entries      []json.RawMessage `json:"entries"`
+	Curly        bool              `json:"curly"`
+	Members      []json.RawMessage `json:"members"`
+	Key          json.RawMessage   `json:"key"`
+	Call         json.RawMessage   `json:"call"`
+	Span         *Span             `json:"span"`
+}
+
+func (n *jsonNode) span() Span {
+	if n.Span == nil {
+		return Span{}
+	}
+	return *n.Span
+}
+
+func (n *jsonNode) local() (string, error) {
+	if n.Local == nil {
+		return "", fmt.Errorf("xpathparser: json %s without local", n.Kind)
+	}
+	return *n.Local, nil
+}
+
+func decodeJSONExprOf(data []byte, kind ExprKind) (Expr, error) {
+	expr, err := decodeJSONExpr(data)
+	if err == nil && expr.Kind() != kind {
+		err = fmt.Errorf("xpathparser: json kind %s found, %s expected", expr.Kind(), kind)
+	}
+	return expr, err
+}
+
+func decodeJSONExpr(data []byte) (Expr, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("xpathparser: json expr missing")
+	}
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	switch n.Kind {
+	case KindLocationPath.String():
+		return decodeJSONLocationPath(&n)
+	case KindFilterExpr.String():
+		expr, err := decodeJSONExpr(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		predicates, err := decodeJSONExprs(n.Predicates)
+		if err != nil {
+			return nil, err
+		}
+		return &FilterExpr{expr, predicates, n.span()}, nil
+	case KindPathExpr.String():
+		filter, err := decodeJSONExpr(n.Filter)
+		if err != nil {
+			return nil, err
+		}
+		lp, err := decodeJSONExprOf(n.LocationPath, KindLocationPath)
+		if err != nil {
+			return nil, err
+		}
+		return &PathExpr{filter, lp.(*LocationPath), n.span()}, nil
+	case KindSlashExpr.String():
+		exprs, err := decodeJSONOperands(n.LHS, n.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return &SlashExpr{exprs[0], exprs[1], n.span()}, nil
+	case KindBinaryExpr.String():
+		op, ok := name2Op[n.Op]
+		if !ok {
+			return nil, fmt.Errorf("xpathparser: invalid json op %q", n.Op)
+		}
+		lhs, err := decodeJSONExpr(n.LHS)
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := decodeJSONExpr(n.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{lhs, op, rhs, n.span()}, nil
+	case KindNegateExpr.String():
+		expr, err := decodeJSONExpr(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &NegateExpr{expr, n.span()}, nil
+	case KindUnaryPlusExpr.String():
+		expr, err := decodeJSONExpr(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryPlusExpr{expr, n.span()}, nil
+	case KindVarRef.String():
+		local, err := n.local()
+		if err != nil {
+			return nil, err
+		}
+		return &VarRef{n.Prefix, local, n.span()}, nil
+	case KindFuncCall.String():
+		local, err := n.local()
+		if err != nil {
+			return nil, err
+		}
+		args, err := decodeJSONExprs(n.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &FuncCall{n.Prefix, local, args, n.span()}, nil
+	case KindNumber.String():
+		switch v := n.Value.(type) {
+		case float64:
+			return &Number{v, n.span()}, nil
+		case string:
+			switch v {
+			case "NaN":
+				return &Number{math.NaN(), n.span()}, nil
+			case "Infinity":
+				return &Number{math.Inf(1), n.span()}, nil
+			case "-Infinity":
+				return &Number{math.Inf(-1), n.span()}, nil
+			}
+		}
+		return nil, fmt.Errorf("xpathparser: invalid json number value %v", n.Value)
+	case KindString.String():
+		v, ok := n.Value.(string)
+		if !ok {
+			return nil, fmt.Errorf("xpathparser: invalid json string value %v", n.Value)
+		}
+		return &String{v, n.span()}, nil
+	case KindBadExpr.String():
+		return &BadExpr{n.span()}, nil
+	case KindForExpr.String():
+		local, err := n.local()
+		if err != nil {
+			return nil, err
+		}
+		exprs, err := decodeJSONOperands(n.In, n.Return)
+		if err != nil {
+			return nil, err
+		}
+		return &ForExpr{n.Prefix, local, exprs[0], exprs[1], n.span()}, nil
+	case KindQuantifiedExpr.String():
+		local, err := n.local()
+		if err != nil {
+			return nil, err
+		}
+		exprs, err := decodeJSONOperands(n.In, n.Satisfies)
+		if err != nil {
+			return nil, err
+		}
+		return &QuantifiedExpr{n.Every, n.Prefix, local, exprs[0], exprs[1], n.span()}, nil
+	case KindIfExpr.String():
+		exprs, err := decodeJSONOperands(n.Cond, n.Then, n.Else)
+		if err != nil {
+			return nil, err
+		}
+		return &IfExpr{exprs[0], exprs[1], exprs[2], n.span()}, nil
+	case KindSequenceExpr.String():
+		items, err := decodeJSONExprs(n.Items)
+		if err != nil {
+			return nil, err
+		}
+		return &SequenceExpr{items, n.span()}, nil
+	case KindInstanceOfExpr.String(), KindTreatExpr.String(), KindCastableExpr.String(), KindCastExpr.String():
+		expr, err := decodeJSONExpr(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		t, err := decodeJSONSequenceType(n.SequenceType)
+		if err != nil {
+			return nil, err
+		}
+		switch n.Kind {
+		case KindInstanceOfExpr.String():
+			return &InstanceOfExpr{expr, t, n.span()}, nil
+		case KindTreatExpr.String():
+			return &TreatExpr{expr, t, n.span()}, nil
+		case KindCastableExpr.String():
+			return &CastableExpr{expr, t, n.span()}, nil
+		default:
+			return &CastExpr{expr, t, n.span()}, nil
+		}
+	case KindLetExpr.String():
+		local, err := n.local()
+		if err != nil {
+			return nil, err
+		}
+		exprs, err := decodeJSONOperands(n.Expr, n.Return)
+		if err != nil {
+			return nil, err
+		}
+		return &LetExpr{n.Prefix, local, exprs[0], exprs[1], n.span()}, nil
+	case KindInlineFunctionExpr.String():
+		var params []*Param
+		for _, d := range n.Params {
+			param, err := decodeJSONParam(d)
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, param)
+		}
+		var t *SequenceType
+		if len(n.SequenceType) > 0 {
+			var err error
+			if t, err = decodeJSONSequenceType(n.SequenceType); err != nil {
+				return nil, err
+			}
+		}
+		body, err := decodeJSONExpr(n.Body)
+		if err != nil {
+			return nil, err
+		}
+		return &InlineFunctionExpr{params, t, body, n.span()}, nil
+	case KindNamedFunctionRef.String():
+		local, err := n.local()
+		if err != nil {
+			return nil, err
+		}
+		if n.Arity == nil || *n.Arity < 0 {
+			return nil, fmt.Errorf("xpathparser: json %s without valid arity", n.Kind)
+		}
+		return &NamedFunctionRef{n.Prefix, local, *n.Arity, n.span()}, nil
+	case KindDynamicCallExpr.String():
+		f, err := decodeJSONExpr(n.Func)
+		if err != nil {
+			return nil, err
+		}
+		args, err := decodeJSONExprs(n.Args)
+		if err != nil {
+			return nil, err
+		}
+		return &DynamicCallExpr{f, args, n.span()}, nil
+	case KindSimpleMapExpr.String():
+		exprs, err := decodeJSONOperands(n.LHS, n.RHS)
+		if err != nil {
+			return nil, err
+		}
+		return &SimpleMapExpr{exprs[0], exprs[1], n.span()}, nil
+	case KindMapConstructor.String():
+		var entries []*MapEntry
+		for _, d := range n.Entries {
+			entry, err := decodeJSONMapEntry(d)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		return &MapConstructor{entries, n.span()}, nil
+	case KindArrayConstructor.String():
+		members, err := decodeJSONExprs(n.Members)
+		if err != nil {
+			return nil, err
+		}
+		return &ArrayConstructor{n.Curly, members, n.span()}, nil
+	case KindLookupExpr.String():
+		lookup := &LookupExpr{Span: n.span()}
+		var err error
+		if len(n.Expr) > 0 {
+			if lookup.Expr, err = decodeJSONExpr(n.Expr); err != nil {
+				return nil, err
+			}
+		}
+		if len(n.Key) > 0 {
+			if lookup.Key, err = decodeJSONExpr(n.Key); err != nil {
+				return nil, err
+			}
+		}
+		return lookup, nil
+	case KindArrowExpr.String():
+		exprs, err := decodeJSONOperands(n.Expr, n.Call)
+		if err != nil {
+			return nil, err
+		}
+		switch exprs[1].(type) {
+		case *FuncCall, *DynamicCallExpr:
+		default:
+			return nil, fmt.Errorf("xpathparser: json %s call must be FuncCall or DynamicCallExpr", n.Kind)
+		}
+		return &ArrowExpr{exprs[0], exprs[1], n.span()}, nil
+	}
+	return nil, fmt.Errorf("xpathparser: invalid json expr kind %q", n.Kind)
+}
+
+func decodeJSONExprs(data []json.RawMessage) ([]Expr, error) {
+	var exprs []Expr
+	for _, d := range data {
+		expr, err := decodeJSONExpr(d)
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+// decodeJSONOperands decodes each of the required exprs.
+func decodeJSONOperands(data ...json.RawMessage) ([]Expr, error) {
+	exprs := make([]Expr, len(data))
+	for i, d := range data {
+		expr, err := decodeJSONExpr(d)
+		if err != nil {
+			return nil, err
+		}
+		exprs[i] = expr
+	}
+	return exprs, nil
+}
+
+func decodeJSONParam(data []byte) (*Param, error) {
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	local, err := n.local()
+	if err != nil {
+		return nil, err
+	}
+	param := &Param{Prefix: n.Prefix, Local: local, Span: n.span()}
+	if len(n.SequenceType) > 0 {
+		if param.Type, err = decodeJSONSequenceType(n.SequenceType); err != nil {
+			return nil, err
+		}
+	}
+	return param, nil
+}
+
+func decodeJSONMapEntry(data []byte) (*MapEntry, error) {
+	var n struct {
+		Key   json.RawMessage `json:"key"`
+		Value json.RawMessage `json:"value"`
+		Span  *Span           `json:"span"`
+	}
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	exprs, err := decodeJSONOperands(n.Key, n.Value)
+	if err != nil {
+		return nil, err
+	}
+	entry := &MapEntry{Key: exprs[0], Value: exprs[1]}
+	if n.Span != nil {
+		entry.Span = *n.Span
+	}
+	return entry, nil
+}
+
+func decodeJSONLocationPath(n *jsonNode) (*LocationPath, error) {
+	var steps []*Step
+	for _, d := range n.Steps {
+		step, err := decodeJSONStep(d)
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	return &LocationPath{n.Abs, steps, n.span()}, nil
+}
+
+func decodeJSONStep(data []byte) (*Step, error) {
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	axis, ok := name2Axis[n.Axis]
+	if !ok {
+		return nil, fmt.Errorf("xpathparser: invalid json axis %q", n.Axis)
+	}
+	nodeTest, err := decodeJSONNodeTest(n.NodeTest)
+	if err != nil {
+		return nil, err
+	}
+	predicates, err := decodeJSONExprs(n.Predicates)
+	if err != nil {
+		return nil, err
+	}
+	return &Step{axis, nodeTest, predicates, n.span()}, nil
+}
+
+func decodeJSONNodeTestOf(data []byte, kind string) (NodeTest, error) {
+	var n struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	if n.Kind != kind {
+		return nil, fmt.Errorf("xpathparser: json kind %s found, %s expected", n.Kind, kind)
+	}
+	return decodeJSONNodeTest(data)
+}
+
+func decodeJSONNodeTest(data []byte) (NodeTest, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("xpathparser: json nodeTest missing")
+	}
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	switch n.Kind {
+	case "NameTest":
+		local, err := n.local()
+		if err != nil {
+			return nil, err
+		}
+		return &NameTest{n.Prefix, local, n.span()}, nil
+	case "NodeTypeTest":
+		for i, name := range nodeTypeNames {
+			if name == n.Type {
+				return &NodeTypeTest{NodeType(i), n.span()}, nil
+			}
+		}
+		return nil, fmt.Errorf("xpathparser: invalid json node type %q", n.Type)
+	case "PITest":
+		if n.Target == nil {
+			return nil, fmt.Errorf("xpathparser: json PITest without target")
+		}
+		return &PITest{*n.Target, n.span()}, nil
+	case "KindTest":
+		return decodeJSONKindTest(&n)
+	}
+	return nil, fmt.Errorf("xpathparser: invalid json nodeTest kind %q", n.Kind)
+}
+
+func decodeJSONKindTest(n *jsonNode) (*KindTest, error) {
+	kindTest := &KindTest{Prefix: n.Prefix, TypePrefix: n.TypePrefix, TypeLocal: n.TypeLocal, Nillable: n.Nillable, Span: n.span()}
+	if n.Local != nil {
+		kindTest.Local = *n.Local
+	}
+	found := false
+	for i, name := range testKindNames {
+		if name == n.Test {
+			kindTest.Test, found = TestKind(i), true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("xpathparser: invalid json kind test %q", n.Test)
+	}
+	if len(n.Element) > 0 {
+		element, err := decodeJSONNodeTestOf(n.Element, "KindTest")
+		if err != nil {
+			return nil, err
+		}
+		kindTest.Element = element.(*KindTest)
+	}
+	return kindTest, nil
+}
+
+func decodeJSONSequenceType(data []byte) (*SequenceType, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("xpathparser: json sequenceType missing")
+	}
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	t := &SequenceType{Span: n.span()}
+	found := false
+	for i, name := range occurrenceNames {
+		if name == n.Occurrence {
+			t.Occurrence, found = Occurrence(i), true
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("xpathparser: invalid json occurrence %q", n.Occurrence)
+	}
+	if len(n.ItemType) > 0 {
+		itemType, err := decodeJSONItemType(n.ItemType)
+		if err != nil {
+			return nil, err
+		}
+		t.ItemType = itemType
+	}
+	return t, nil
+}
+
+func decodeJSONItemTypeOf(data []byte, kind string) (ItemType, error) {
+	var n struct {
+		Kind string `json:"kind"`
+	}
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	if n.Kind != kind {
+		return nil, fmt.Errorf("xpathparser: json kind %s found, %s expected", n.Kind, kind)
+	}
+	return decodeJSONItemType(data)
+}
+
+func decodeJSONItemType(data []byte) (ItemType, error) {
+	var n jsonNode
+	if err := json.Unmarshal(data, &n); err != nil {
+		return nil, err
+	}
+	switch n.Kind {
+	case "AtomicType":
+		local, err := n.local()
+		if err != nil {
+			return nil, err
+		}
+		return &AtomicType{n.Prefix, local, n.span()}, nil
+	case "AnyItem":
+		return &AnyItem{n.span()}, nil
+	case "FunctionTest":
+		return decodeJSONFunctionTest(&n)
+	case "MapTest":
+		return decodeJSONMapTest(&n)
+	case "ArrayTest":
+		arrayTest := &ArrayTest{Span: n.span()}
+		if len(n.MemberType) > 0 {
+			t, err := decodeJSONSequenceType(n.MemberType)
+			if err != nil {
+				return nil, err
+			}
+			arrayTest.MemberType = t
+		}
+		return arrayTest, nil
+	case "NodeTypeTest", "PITest", "KindTest":
+		nodeTest, err := decodeJSONNodeTest(data)
+		if err != nil {
+			return nil, err
+		}
+		return nodeTest.(ItemType), nil
+	}
+	return nil, fmt.Errorf("xpathparser: invalid json itemType kind %q", n.Kind)
+}
+
+func decodeJSONFunctionTest(n *jsonNode) (*FunctionTest, error) {
+	functionTest := &FunctionTest{Span: n.span()}
+	for _, d := range n.ParamTypes {
+		t, err := decodeJSONSequenceType(d)
+		if err != nil {
+			return nil, err
+		}
+		functionTest.ParamTypes = append(functionTest.ParamTypes, t)
+	}
+	if len(n.ReturnType) > 0 {
+		t, err := decodeJSONSequenceType(n.ReturnType)
+		if err != nil {
+			return nil, err
+		}
+		functionTest.ReturnType = t
+	} else if len(n.ParamTypes) > 0 {
+		return nil, fmt.Errorf("xpathparser: json FunctionTest with paramTypes, but without returnType")
+	}
+	return functionTest, nil
+}
+
+func decodeJSONMapTest(n *jsonNode) (*MapTest, error) {
+	mapTest := &MapTest{Span: n.span()}
+	if len(n.KeyType) == 0 && len(n.ValueType) == 0 {
+		return mapTest, nil
+	}
+	if len(n.KeyType) == 0 || len(n.ValueType) == 0 {
+		return nil, fmt.Errorf("xpathparser: json MapTest requires both keyType and valueType")
+	}
+	keyType, err := decodeJSONItemTypeOf(n.KeyType, "AtomicType")
+	if err != nil {
+		return nil, err
+	}
+	mapTest.KeyType = keyType.(*AtomicType)
+	if mapTest.ValueType, err = decodeJSONSequenceType(n.ValueType); err != nil {
+		return nil, err
+	}
+	return mapTest, nil
+}
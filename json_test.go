@@ -0,0 +1,160 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+func TestJSON(t *testing.T) {
+	for _, test := range roundTrips() {
+		b, err := EncodeJSON(test.expr)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", test.xpath, err)
+			continue
+		}
+		got, err := DecodeJSON(b)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", test.xpath, err)
+			continue
+		}
+		if !Equal(test.expr, got) {
+			t.Errorf("FAIL: %s: %s decoded to %v", test.xpath, b, got)
+		}
+	}
+}
+
+func TestJSONSchema(t *testing.T) {
+	tests := []struct {
+		expr Expr
+		json string
+	}{
+		{
+			&BinaryExpr{LHS: &Number{Value: 1}, Op: Add, RHS: &NegateExpr{Expr: &String{Value: "2"}}},
+			`{"version":1,"expr":{"kind":"BinaryExpr","op":"+","lhs":{"kind":"Number","value":1},"rhs":{"kind":"NegateExpr","expr":{"kind":"String","value":"2"}}}}`,
+		},
+		{
+			&LocationPath{Abs: true, Steps: []*Step{
+				{Axis: DescendantOrSelf, NodeTest: &NodeTypeTest{Type: Node}},
+				{Axis: Child, NodeTest: &NameTest{Prefix: "ns", Local: "a"}, Predicates: []Expr{&VarRef{Local: "x"}}},
+				{Axis: Attribute, NodeTest: &PITest{Target: "pi"}},
+			}},
+			`{"version":1,"expr":{"kind":"LocationPath","abs":true,"steps":[` +
+				`{"axis":"descendant-or-self","nodeTest":{"kind":"NodeTypeTest","type":"node()"}},` +
+				`{"axis":"child","nodeTest":{"kind":"NameTest","prefix":"ns","local":"a"},"predicates":[{"kind":"VarRef","local":"x"}]},` +
+				`{"axis":"attribute","nodeTest":{"kind":"PITest","target":"pi"}}]}}`,
+		},
+		{
+			&PathExpr{
+				Filter:       &FilterExpr{Expr: &FuncCall{Local: "f"}, Predicates: []Expr{&Number{Value: math.NaN()}}},
+				LocationPath: &LocationPath{},
+			},
+			`{"version":1,"expr":{"kind":"PathExpr","filter":{"kind":"FilterExpr","expr":{"kind":"FuncCall","local":"f"},"predicates":[{"kind":"Number","value":"NaN"}]},"locationPath":{"kind":"LocationPath","abs":false,"steps":[]}}}`,
+		},
+		{
+			&ForExpr{Local: "x", In: &BinaryExpr{LHS: &Number{Value: 1}, Op: To, RHS: &Number{Value: 2}}, Return: &IfExpr{
+				Cond: &QuantifiedExpr{Every: true, Prefix: "ns", Local: "y", In: &SequenceExpr{}, Satisfies: &String{Value: "a"}},
+				Then: &SequenceExpr{Items: []Expr{&VarRef{Local: "x"}}},
+				Else: &SequenceExpr{},
+			}},
+			`{"version":1,"expr":{"kind":"ForExpr","local":"x","in":{"kind":"BinaryExpr","op":"to","lhs":{"kind":"Number","value":1},"rhs":{"kind":"Number","value":2}},` +
+				`"return":{"kind":"IfExpr","cond":{"kind":"QuantifiedExpr","every":true,"prefix":"ns","local":"y","in":{"kind":"SequenceExpr"},"satisfies":{"kind":"String","value":"a"}},` +
+				`"then":{"kind":"SequenceExpr","items":[{"kind":"VarRef","local":"x"}]},"else":{"kind":"SequenceExpr"}}}}`,
+		},
+		{
+			&InstanceOfExpr{Expr: &TreatExpr{Expr: &Number{Value: 1}, Type: &SequenceType{}}, Type: &SequenceType{
+				ItemType:   &KindTest{Test: ElementTest, Local: "*", TypePrefix: "xs", TypeLocal: "anyType", Nillable: true},
+				Occurrence: ZeroOrMore,
+			}},
+			`{"version":1,"expr":{"kind":"InstanceOfExpr","expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{}},` +
+				`"sequenceType":{"itemType":{"kind":"KindTest","test":"element","local":"*","typePrefix":"xs","typeLocal":"anyType","nillable":true},"occurrence":"*"}}}`,
+		},
+		{
+			&InstanceOfExpr{Expr: &VarRef{Local: "f"}, Type: &SequenceType{ItemType: &FunctionTest{
+				ParamTypes: []*SequenceType{{ItemType: &FunctionTest{}, Occurrence: OneOrMore}},
+				ReturnType: &SequenceType{},
+			}}},
+			`{"version":1,"expr":{"kind":"InstanceOfExpr","expr":{"kind":"VarRef","local":"f"},"sequenceType":{"itemType":{"kind":"FunctionTest",` +
+				`"paramTypes":[{"itemType":{"kind":"FunctionTest"},"occurrence":"+"}],"returnType":{}}}}}`,
+		},
+		{
+			&InstanceOfExpr{Expr: &VarRef{Local: "m"}, Type: &SequenceType{ItemType: &MapTest{
+				KeyType:   &AtomicType{Prefix: "xs", Local: "string"},
+				ValueType: &SequenceType{ItemType: &ArrayTest{}, Occurrence: ZeroOrOne},
+			}}},
+			`{"version":1,"expr":{"kind":"InstanceOfExpr","expr":{"kind":"VarRef","local":"m"},"sequenceType":{"itemType":{"kind":"MapTest",` +
+				`"keyType":{"kind":"AtomicType","prefix":"xs","local":"string"},"valueType":{"itemType":{"kind":"ArrayTest"},"occurrence":"?"}}}}}`,
+		},
+	}
+	for _, test := range tests {
+		b, err := EncodeJSON(test.expr)
+		if err != nil {
+			t.Errorf("FAIL: %v: %v", test.expr, err)
+			continue
+		}
+		if string(b) != test.json {
+			t.Errorf("FAIL: %v:\n got: %s\nwant: %s", test.expr, b, test.json)
+		}
+		expr, err := DecodeJSON([]byte(test.json))
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", test.json, err)
+			continue
+		}
+		if !Equal(expr, test.expr) {
+			t.Errorf("FAIL: %s decoded to %v", test.json, expr)
+		}
+	}
+}
+
+func TestJSONSpan(t *testing.T) {
+	expr := MustParse("a\n[1]")
+	b, err := json.Marshal(expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lp LocationPath
+	if err := json.Unmarshal(b, &lp); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := lp.Steps[0].Span, expr.(*LocationPath).Steps[0].Span; got != want {
+		t.Errorf("FAIL: got %v, want %v", got, want)
+	}
+}
+
+func TestInvalidJSON(t *testing.T) {
+	tests := []string{
+		`{"version":2,"expr":{"kind":"Number","value":1}}`,
+		`{"version":1}`,
+		`{"version":1,"expr":{"kind":"Foo"}}`,
+		`{"version":1,"expr":{"kind":"Number","value":"one"}}`,
+		`{"version":1,"expr":{"kind":"BinaryExpr","op":"^","lhs":{"kind":"Number","value":1},"rhs":{"kind":"Number","value":1}}}`,
+		`{"version":1,"expr":{"kind":"BinaryExpr","op":"+","lhs":{"kind":"Number","value":1}}}`,
+		`{"version":1,"expr":{"kind":"LocationPath","steps":[{"axis":"up","nodeTest":{"kind":"NodeTypeTest","type":"node()"}}]}}`,
+		`{"version":1,"expr":{"kind":"LocationPath","steps":[{"axis":"child","nodeTest":{"kind":"NodeTypeTest","type":"element()"}}]}}`,
+		`{"version":1,"expr":{"kind":"LocationPath","steps":[{"axis":"child"}]}}`,
+		`{"version":1,"expr":{"kind":"PathExpr","filter":{"kind":"VarRef","local":"x"},"locationPath":{"kind":"Number","value":1}}}`,
+		`{"version":1,"expr":{"kind":"VarRef"}}`,
+		`{"version":1,"expr":{"kind":"ForExpr","local":"x","in":{"kind":"Number","value":1}}}`,
+		`{"version":1,"expr":{"kind":"IfExpr","cond":{"kind":"Number","value":1},"then":{"kind":"Number","value":1}}}`,
+		`{"version":1,"expr":{"kind":"CastExpr","expr":{"kind":"Number","value":1}}}`,
+		`{"version":1,"expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{"occurrence":"x"}}}`,
+		`{"version":1,"expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{"itemType":{"kind":"NameTest","local":"a"}}}}`,
+		`{"version":1,"expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{"itemType":{"kind":"KindTest","test":"foo"}}}}`,
+		`{"version":1,"expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{"itemType":{"kind":"FunctionTest","paramTypes":[{}]}}}}`,
+		`{"version":1,"expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{"itemType":{"kind":"FunctionTest","returnType":{"occurrence":"x"}}}}}`,
+		`{"version":1,"expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{"itemType":{"kind":"MapTest","keyType":{"kind":"AtomicType","local":"a"}}}}}`,
+		`{"version":1,"expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{"itemType":{"kind":"MapTest","keyType":{"kind":"AnyItem"},"valueType":{}}}}}`,
+		`{"version":1,"expr":{"kind":"TreatExpr","expr":{"kind":"Number","value":1},"sequenceType":{"itemType":{"kind":"ArrayTest","memberType":{"occurrence":"x"}}}}}`,
+	}
+	for _, test := range tests {
+		if expr, err := DecodeJSON([]byte(test)); err == nil {
+			t.Errorf("FAIL: %s: error expected, got %v", test, expr)
+		}
+	}
+}
@@ -8,54 +8,103 @@ package xpathparser
 
 import (
 	"bytes"
+	"sort"
 	"strings"
 	"unicode/utf8"
 )
 
-type kind int
+// TokenKind identifies the type of lexical token.
+type TokenKind int
 
+// Possible values for TokenKind.
 const (
-	eof kind = iota - 1
+	TokenEOF TokenKind = iota - 1
 
 	// operators, note the order must same as Op enum values
-	eq
-	neq
-	lt
-	lte
-	gt
-	gte
-	plus
-	minus
-	multiply
-	mod
-	div
-	and
-	or
-	pipe
-
-	slash
-	slashSlash
-	dot
-	dotDot
-	colon
-	colonColon
-
-	at
-	dollar
-	comma
-	star
-
-	lbracket
-	rbracket
-	lparen
-	rparen
-
-	identifier
-	literal
-	number
+	TokenEQ
+	TokenNEQ
+	TokenLT
+	TokenLTE
+	TokenGT
+	TokenGTE
+	TokenPlus
+	TokenMinus
+	TokenMultiply
+	TokenMod
+	TokenDiv
+	TokenAnd
+	TokenOr
+	TokenPipe
+
+	TokenSlash
+	TokenSlashSlash
+	TokenDot
+	TokenDotDot
+	TokenColon
+	TokenColonColon
+
+	TokenAt
+	TokenDollar
+	TokenComma
+	TokenStar
+
+	TokenLBracket
+	TokenRBracket
+	TokenLParen
+	TokenRParen
+
+	TokenIdentifier // also EQName of XPath 3.0, such as Q{uri}local
+	TokenLiteral
+	TokenNumber
+
+	TokenIllegal    // text rejected by lexer, such as unclosed literal
+	TokenWhitespace // reported only by Scanner in ScanWhitespace mode
+
+	// keywords of XPath 2.0, lexed only where operator is expected
+	TokenTo
+	TokenIn
+	TokenReturn
+	TokenSatisfies
+	TokenThen
+	TokenElse
+	TokenInstance
+	TokenOf
+	TokenTreat
+	TokenAs
+	TokenCastable
+	TokenCast
+
+	TokenQuestion // occurrence indicator of XPath 2.0
+
+	// operators of XPath 2.0, in the order of Op values ValueEQ to Except
+	TokenValueEQ
+	TokenValueNE
+	TokenValueLT
+	TokenValueLE
+	TokenValueGT
+	TokenValueGE
+	TokenIs
+	TokenPrecedes
+	TokenFollows
+	TokenIDiv
+	TokenIntersect
+	TokenExcept
+
+	// tokens of XPath 3.0
+	TokenConcat
+	TokenAssign
+	TokenBang
+	TokenHash
+	TokenLBrace
+	TokenRBrace
+
+	TokenArrow // arrow operator of XPath 3.1
+
+	TokenComment // comment of XPath 2.0, reported only by Scanner in ScanWhitespace mode
+	TokenUnion   // keyword "union" of XPath 2.0, the same operator as '|'
 )
 
-var kindNames = []string{
+var tokenKindNames = []string{
 	`<eof>`,
 	`'='`, `"!="`, `'<'`, `"<="`, `'>'`, `">="`,
 	`'+'`, `'-'`, `'*'`, `"mod"`, `"div"`,
@@ -64,31 +113,63 @@ var kindNames = []string{
 	`'@'`, `'$'`, `','`, `'*'`,
 	`'['`, `']'`, `'('`, `')'`,
 	`<identifier>`, `<literal>`, `<number>`,
+	`<illegal>`, `<whitespace>`,
+	`"to"`, `"in"`, `"return"`, `"satisfies"`, `"then"`, `"else"`,
+	`"instance"`, `"of"`, `"treat"`, `"as"`, `"castable"`, `"cast"`,
+	`'?'`,
+	`"eq"`, `"ne"`, `"lt"`, `"le"`, `"gt"`, `"ge"`,
+	`"is"`, `"<<"`, `">>"`,
+	`"idiv"`, `"intersect"`, `"except"`,
+	`"||"`, `":="`, `'!'`, `'#'`, `'{'`, `'}'`,
+	`"=>"`,
+	`<comment>`,
+	`"union"`,
 }
 
-func (k kind) String() string {
-	return kindNames[k+1]
+// String returns the token as it appears in error messages, such as
+// '=', "and" or <identifier>.
+func (k TokenKind) String() string {
+	return tokenKindNames[k+1]
 }
 
 type token struct {
 	xpath string
-	kind  kind
+	kind  TokenKind
 	begin int
 	end   int
+	err   error // reason of illegal token
 }
 
 func (t token) text() string {
 	return t.xpath[t.begin:t.end]
 }
 
+// value returns the value of literal token, in which the quotes doubled
+// as escape in XPath 2.0 are undoubled.
+func (t token) value() string {
+	quote := t.xpath[t.begin-1 : t.begin]
+	return strings.Replace(t.text(), quote+quote, quote, -1)
+}
+
 type lexer struct {
 	xpath    string
+	version  Version
 	pos      int
+	start    int // offset at which last token started
 	expectOp bool
+	lines    []int      // offsets at which lines start
+	wide     []wideRune // multibyte runes, to compute columns
 }
 
-func (l *lexer) err(msg string) (token, error) {
-	return token{}, &Error{msg, l.xpath, l.pos}
+func (l *lexer) err(code ErrorCode, msg string, expected ...TokenKind) (token, error) {
+	return token{}, &Error{
+		Msg:      msg,
+		XPath:    l.xpath,
+		Offset:   l.pos,
+		Code:     code,
+		Expected: expected,
+		Actual:   TokenIllegal,
+	}
 }
 
 func (l *lexer) char(i int) int {
@@ -106,17 +187,22 @@ func (l *lexer) hasMore() bool {
 	return l.pos < len(l.xpath)
 }
 
-func (l *lexer) token(kind kind, n int) (token, error) {
+func (l *lexer) token(k TokenKind, n int) (token, error) {
 	var t token
 	if n > 0 {
-		t = token{l.xpath, kind, l.pos, l.pos + n}
+		t = token{l.xpath, k, l.pos, l.pos + n, nil}
 		l.consume(n)
 	} else {
-		t = token{l.xpath, kind, l.pos + n, l.pos}
+		t = token{l.xpath, k, l.pos + n, l.pos, nil}
 	}
-	switch kind {
-	case at, colonColon, lparen, lbracket, and, or, mod, div, colon, slash, slashSlash,
-		pipe, dollar, plus, minus, multiply, comma, lt, gt, lte, gte, eq, neq:
+	switch k {
+	case TokenAt, TokenColonColon, TokenLParen, TokenLBracket, TokenAnd, TokenOr, TokenMod, TokenDiv,
+		TokenColon, TokenSlash, TokenSlashSlash, TokenPipe, TokenDollar, TokenPlus, TokenMinus,
+		TokenMultiply, TokenComma, TokenLT, TokenGT, TokenLTE, TokenGTE, TokenEQ, TokenNEQ,
+		TokenTo, TokenIn, TokenReturn, TokenSatisfies, TokenThen, TokenElse, TokenOf, TokenAs,
+		TokenValueEQ, TokenValueNE, TokenValueLT, TokenValueLE, TokenValueGT, TokenValueGE,
+		TokenIs, TokenPrecedes, TokenFollows, TokenIDiv, TokenIntersect, TokenExcept, TokenUnion,
+		TokenConcat, TokenAssign, TokenBang, TokenHash, TokenLBrace, TokenArrow:
 		l.expectOp = false
 	default:
 		l.expectOp = true
@@ -125,92 +211,235 @@ func (l *lexer) token(kind kind, n int) (token, error) {
 }
 
 func (l *lexer) next() (token, error) {
-SkipWS:
-	for l.hasMore() {
-		switch l.char(0) {
-		case ' ', '\t', '\n', '\r':
-			l.consume(1)
-		default:
-			break SkipWS
+	l.skipSpace()
+	for l.isComment() {
+		if err := l.comment(); err != nil {
+			return token{}, err
 		}
+		l.skipSpace()
 	}
+	l.start = l.pos
 
 	switch l.char(0) {
 	case -1:
-		return l.token(eof, 0)
+		return l.token(TokenEOF, 0)
 	case '$':
-		return l.token(dollar, 1)
+		return l.token(TokenDollar, 1)
 	case '"', '\'':
 		return l.literal()
 	case '/':
 		if l.char(1) == '/' {
-			return l.token(slashSlash, 2)
+			return l.token(TokenSlashSlash, 2)
 		}
-		return l.token(slash, 1)
+		return l.token(TokenSlash, 1)
 	case ',':
-		return l.token(comma, 1)
+		return l.token(TokenComma, 1)
 	case '(':
-		return l.token(lparen, 1)
+		return l.token(TokenLParen, 1)
 	case ')':
-		return l.token(rparen, 1)
+		return l.token(TokenRParen, 1)
 	case '[':
-		return l.token(lbracket, 1)
+		return l.token(TokenLBracket, 1)
 	case ']':
-		return l.token(rbracket, 1)
+		return l.token(TokenRBracket, 1)
 	case '+':
-		return l.token(plus, 1)
+		return l.token(TokenPlus, 1)
 	case '-':
-		return l.token(minus, 1)
+		return l.token(TokenMinus, 1)
 	case '<':
-		if l.char(1) == '=' {
-			return l.token(lte, 2)
+		switch {
+		case l.char(1) == '=':
+			return l.token(TokenLTE, 2)
+		case l.char(1) == '<' && l.version >= XPath20:
+			return l.token(TokenPrecedes, 2)
 		}
-		return l.token(lt, 1)
+		return l.token(TokenLT, 1)
 	case '>':
-		if l.char(1) == '=' {
-			return l.token(gte, 2)
+		switch {
+		case l.char(1) == '=':
+			return l.token(TokenGTE, 2)
+		case l.char(1) == '>' && l.version >= XPath20:
+			return l.token(TokenFollows, 2)
 		}
-		return l.token(gt, 1)
+		return l.token(TokenGT, 1)
 	case '=':
-		return l.token(eq, 1)
+		if l.char(1) == '>' && l.version >= XPath31 {
+			return l.token(TokenArrow, 2)
+		}
+		return l.token(TokenEQ, 1)
 	case '!':
-		if l.char(1) == '=' {
-			return l.token(neq, 2)
+		switch {
+		case l.char(1) == '=':
+			return l.token(TokenNEQ, 2)
+		case l.version >= XPath30:
+			return l.token(TokenBang, 1)
 		}
-		return l.err("expected '!='")
+		return l.err(IllegalCharacter, "expected '!='", TokenNEQ)
 	case '|':
-		return l.token(pipe, 1)
+		if l.char(1) == '|' && l.version >= XPath30 {
+			return l.token(TokenConcat, 2)
+		}
+		return l.token(TokenPipe, 1)
 	case '@':
-		return l.token(at, 1)
+		return l.token(TokenAt, 1)
 	case ':':
-		if l.char(1) == ':' {
-			return l.token(colonColon, 2)
+		switch {
+		case l.char(1) == ':':
+			return l.token(TokenColonColon, 2)
+		case l.char(1) == '=' && l.version >= XPath30:
+			return l.token(TokenAssign, 2)
 		}
-		return l.token(colon, 1)
+		return l.token(TokenColon, 1)
 	case '*':
 		if l.expectOp {
-			return l.token(multiply, 1)
+			return l.token(TokenMultiply, 1)
 		}
-		return l.token(star, 1)
+		return l.token(TokenStar, 1)
 	case '.':
 		switch l.char(1) {
 		case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 			return l.number()
 		case '.':
-			return l.token(dotDot, 2)
+			return l.token(TokenDotDot, 2)
 		default:
-			return l.token(dot, 1)
+			return l.token(TokenDot, 1)
 		}
 	case '0', '1', '2', '3', '4', '5', '6', '7', '8', '9':
 		return l.number()
-	default:
-		if l.expectOp {
-			return l.operator()
+	case '?':
+		if l.version >= XPath20 {
+			return l.token(TokenQuestion, 1)
 		}
-		return l.identifier()
+	case '#':
+		if l.version >= XPath30 {
+			return l.token(TokenHash, 1)
+		}
+	case '{':
+		if l.version >= XPath30 {
+			return l.token(TokenLBrace, 1)
+		}
+	case '}':
+		if l.version >= XPath30 {
+			return l.token(TokenRBrace, 1)
+		}
+	}
+	if l.expectOp {
+		return l.operator()
+	}
+	return l.identifier()
+}
+
+// illegal returns the token of kind TokenIllegal for err returned by next,
+// and resumes lexing after it. The token covers the text from the start of
+// the token being lexed till the character at which err occurred, and the
+// characters of any errors immediately following it.
+//
+// It also sets the position of err.
+func (l *lexer) illegal(err *Error) token {
+	_, n := utf8.DecodeRuneInString(l.xpath[err.Offset:])
+	begin := l.start
+	l.pos = err.Offset + n
+	for l.hasMore() {
+		saved := *l
+		_, e := l.next()
+		*l = saved
+		if e == nil || e.(*Error).Offset != l.pos {
+			break
+		}
+		_, n := utf8.DecodeRuneInString(l.xpath[l.pos:])
+		l.consume(n)
 	}
+	pos := l.position(err.Offset)
+	err.Line, err.Column, err.end = pos.Line, pos.Column, l.pos
+	return token{l.xpath, TokenIllegal, begin, l.pos, err}
 }
 
+// position returns the position of given offset in xpath.
+//
+// The offsets of line starts and multibyte runes are computed once,
+// so that positions of many errors and spans are not quadratic in the
+// length of xpath.
+func (l *lexer) position(offset int) Pos {
+	if l.lines == nil {
+		l.lines = []int{0}
+		for i := 0; i < len(l.xpath); {
+			r, size := utf8.DecodeRuneInString(l.xpath[i:])
+			if r == '\n' {
+				l.lines = append(l.lines, i+1)
+			} else if size > 1 {
+				l.wide = append(l.wide, wideRune{i, size - 1 + l.extra(i)})
+			}
+			i += size
+		}
+	}
+	line := sort.SearchInts(l.lines, offset+1) - 1
+	start := l.lines[line]
+	return Pos{offset, line + 1, offset - start - (l.extra(offset) - l.extra(start)) + 1}
+}
+
+// wideRune records the offset of a multibyte rune, and the number of
+// bytes beyond one per rune, till the end of that rune.
+type wideRune struct {
+	offset int
+	extra  int
+}
+
+// extra returns the number of bytes beyond one per rune, before offset.
+// It avoids counting runes from the start of long lines.
+func (l *lexer) extra(offset int) int {
+	i := sort.Search(len(l.wide), func(i int) bool { return l.wide[i].offset >= offset })
+	if i == 0 {
+		return 0
+	}
+	return l.wide[i-1].extra
+}
+
+// skipSpace skips whitespace, and returns the number of bytes skipped.
+func (l *lexer) skipSpace() int {
+	begin := l.pos
+	for l.hasMore() {
+		switch l.char(0) {
+		case ' ', '\t', '\n', '\r':
+			l.consume(1)
+		default:
+			return l.pos - begin
+		}
+	}
+	return l.pos - begin
+}
+
+// isComment tells whether comment of XPath 2.0 starts at current position.
+func (l *lexer) isComment() bool {
+	return l.version >= XPath20 && l.char(0) == '(' && l.char(1) == ':'
+}
+
+// comment skips the comment starting at current position. Comments
+// may be nested, as in "(: outer (: inner :) :)".
+func (l *lexer) comment() error {
+	l.start = l.pos
+	depth := 0
+	for {
+		switch {
+		case l.char(0) == -1:
+			_, err := l.err(UnclosedComment, "unclosed comment")
+			return err
+		case l.char(0) == '(' && l.char(1) == ':':
+			depth++
+			l.consume(2)
+		case l.char(0) == ':' && l.char(1) == ')':
+			depth--
+			l.consume(2)
+			if depth == 0 {
+				return nil
+			}
+		default:
+			l.consume(1)
+		}
+	}
+}
+
+// literal lexes string literal. In XPath 2.0, the quote character is
+// included in literal by writing it twice.
 func (l *lexer) literal() (token, error) {
 	quote := l.char(0)
 	l.consume(1)
@@ -218,16 +447,22 @@ func (l *lexer) literal() (token, error) {
 	for {
 		switch l.char(0) {
 		case quote:
-			t, _ := l.token(literal, begin-l.pos)
+			if l.version >= XPath20 && l.char(1) == quote {
+				l.consume(2)
+				continue
+			}
+			t, _ := l.token(TokenLiteral, begin-l.pos)
 			l.consume(1)
 			return t, nil
 		case -1:
-			return l.err("unclosed literal")
+			return l.err(UnclosedLiteral, "unclosed literal")
 		}
 		l.consume(1)
 	}
 }
 
+// number lexes number literal. In XPath 2.0, it may have an exponent,
+// as in 1.5e-3.
 func (l *lexer) number() (token, error) {
 	begin := l.pos
 	dotAllowed := true
@@ -247,35 +482,103 @@ Loop:
 			break Loop
 		}
 	}
-	return l.token(number, begin-l.pos)
+	if l.version >= XPath20 && (l.char(0) == 'e' || l.char(0) == 'E') {
+		n := 1
+		if l.char(n) == '+' || l.char(n) == '-' {
+			n++
+		}
+		if isDigit(l.char(n)) {
+			l.consume(n)
+			for isDigit(l.char(0)) {
+				l.consume(1)
+			}
+		}
+	}
+	return l.token(TokenNumber, begin-l.pos)
+}
+
+func isDigit(c int) bool {
+	return c >= '0' && c <= '9'
 }
 
 func (l *lexer) operator() (token, error) {
 	remaining := l.xpath[l.pos:]
 	switch {
 	case strings.HasPrefix(remaining, "and"):
-		return l.token(and, 3)
+		return l.token(TokenAnd, 3)
 	case strings.HasPrefix(remaining, "or"):
-		return l.token(or, 2)
+		return l.token(TokenOr, 2)
 	case strings.HasPrefix(remaining, "mod"):
-		return l.token(mod, 3)
+		return l.token(TokenMod, 3)
 	case strings.HasPrefix(remaining, "div"):
-		return l.token(div, 3)
+		return l.token(TokenDiv, 3)
+	}
+	if l.version < XPath20 {
+		return l.err(OperatorExpected, "operatorName expected", TokenAnd, TokenOr, TokenMod, TokenDiv)
+	}
+	for _, k := range keywords20 {
+		if name := k.String(); strings.HasPrefix(remaining, name[1:len(name)-1]) {
+			return l.token(k, len(name)-2)
+		}
 	}
-	return l.err("operatorName expected")
+	return l.err(OperatorExpected, "operatorName expected", append([]TokenKind{TokenAnd, TokenOr, TokenMod, TokenDiv}, keywords20...)...)
+}
+
+// keywords20 are the keywords of XPath 2.0, that are lexed by operator.
+// They are tried in order, so a keyword must precede any keyword that
+// is its prefix.
+var keywords20 = []TokenKind{
+	TokenTo, TokenInstance, TokenIntersect, TokenIn, TokenReturn, TokenSatisfies, TokenThen, TokenElse,
+	TokenOf, TokenTreat, TokenAs, TokenCastable, TokenCast,
+	TokenValueEQ, TokenValueNE, TokenValueLT, TokenValueLE, TokenValueGT, TokenValueGE,
+	TokenIs, TokenIDiv, TokenExcept, TokenUnion,
 }
 
 func (l *lexer) identifier() (token, error) {
+	if l.version >= XPath30 && l.char(0) == 'Q' && l.char(1) == '{' {
+		return l.eqName()
+	}
 	begin := l.pos
 	b, ok := l.readName()
 	if !ok {
-		return l.err("identifier expected")
+		return l.err(IllegalCharacter, "identifier expected")
 	}
 	if !isName(b) {
 		l.pos = begin
-		return l.err("invalid identifier")
+		return l.err(InvalidName, "invalid identifier")
+	}
+	return l.token(TokenIdentifier, begin-l.pos)
+}
+
+// eqName lexes EQName of XPath 3.0, such as Q{http://example.com}local,
+// as single identifier. The local part may also be '*', as in the
+// wildcard Q{http://example.com}*.
+func (l *lexer) eqName() (token, error) {
+	begin := l.pos
+	end := strings.IndexAny(l.xpath[l.pos+2:], "{}")
+	if end == -1 {
+		l.pos = len(l.xpath)
+		return l.err(IllegalCharacter, "expected '}'", TokenRBrace)
+	}
+	l.consume(2 + end)
+	if l.char(0) == '{' {
+		return l.err(IllegalCharacter, "expected '}'", TokenRBrace)
+	}
+	l.consume(1)
+	if l.char(0) == '*' {
+		l.consume(1)
+		return l.token(TokenIdentifier, begin-l.pos)
+	}
+	local := l.pos
+	b, ok := l.readName()
+	if !ok {
+		return l.err(IllegalCharacter, "identifier expected")
+	}
+	if !isName(b) {
+		l.pos = local
+		return l.err(InvalidName, "invalid identifier")
 	}
-	return l.token(identifier, begin-l.pos)
+	return l.token(TokenIdentifier, begin-l.pos)
 }
 
 func (l *lexer) readName() ([]byte, bool) {
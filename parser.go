@@ -11,425 +11,2117 @@ import (
 )
 
 type parser struct {
-	lexer  lexer
-	tokens []token
+	options ParseOptions
+	lexer   lexer
+	tokens  []token
+	end     int // end offset of last matched token
+
+	recovering bool     // report errors and continue, instead of failing
+	errors     []*Error // errors reported in recovery mode
+
+	depth       int // current nesting depth
+	nsteps      int // number of steps parsed
+	npredicates int // number of predicates parsed
+}
+
+// enter increments the nesting depth. It returns error of code
+// LimitExceeded, if the depth exceeds ParseOptions.MaxDepth.
+func (p *parser) enter() error {
+	p.depth++
+	if max := p.options.maxDepth(); p.depth > max {
+		return p.error(LimitExceeded, "nesting depth exceeds limit %d", max)
+	}
+	return nil
+}
+
+// leave decrements the nesting depth.
+func (p *parser) leave() {
+	p.depth--
+}
+
+// leaveTo restores the nesting depth. It is deferred by the loops parsing
+// left associative operators and postfix expressions, which enter a level
+// for each operator, as it nests the expression parsed so far one level
+// deeper: "1 + 2 + 3" is parsed as "(1 + 2) + 3".
+func (p *parser) leaveTo(depth int) {
+	p.depth = depth
+}
+
+// error returns the error at current token.
+func (p *parser) error(code ErrorCode, format string, args ...interface{}) error {
+	return p.errorAt(p.token(0), code, format, args...)
 }
 
-func (p *parser) error(format string, args ...interface{}) error {
-	return &Error{fmt.Sprintf(format, args...), p.lexer.xpath, p.token(0).begin}
+// errorAt returns the error at token t.
+func (p *parser) errorAt(t token, code ErrorCode, format string, args ...interface{}) error {
+	begin, end := t.begin, t.end
+	if t.kind == TokenLiteral {
+		begin, end = begin-1, end+1 // quotes
+	}
+	pos := p.pos(begin)
+	return &Error{
+		Msg:    fmt.Sprintf(format, args...),
+		XPath:  p.lexer.xpath,
+		Offset: begin,
+		Code:   code,
+		Line:   pos.Line,
+		Column: pos.Column,
+		Actual: t.kind,
+		end:    end,
+	}
 }
 
+// unexpectedToken returns the error for current token. If it is an
+// illegal token, the error reported by lexer is returned.
 func (p *parser) unexpectedToken() error {
-	return p.error("unexpected token %s", p.token(0).kind)
+	if t := p.token(0); t.kind == TokenIllegal {
+		return t.err
+	}
+	return p.error(UnexpectedToken, "unexpected token %s", p.token(0).kind)
 }
 
-func (p *parser) expectedTokens(expected ...kind) error {
+// expectedTokens returns the error for current token, which is none of
+// expected. If it is an illegal token, the error reported by lexer is returned.
+func (p *parser) expectedTokens(expected ...TokenKind) error {
+	if t := p.token(0); t.kind == TokenIllegal {
+		return t.err
+	}
 	tokens := make([]string, len(expected))
 	for i, k := range expected {
 		tokens[i] = k.String()
 	}
-	return p.error("expected %s, but got %v", strings.Join(tokens, " or "), p.token(0).kind)
+	err := p.error(UnexpectedToken, "expected %s, but got %v", strings.Join(tokens, " or "), p.token(0).kind)
+	err.(*Error).Expected = expected
+	return err
 }
 
 func (p *parser) token(i int) token {
 	for i > len(p.tokens)-1 {
 		t, err := p.lexer.next()
 		if err != nil {
-			panic(err)
+			t = p.lexer.illegal(err.(*Error))
 		}
 		p.tokens = append(p.tokens, t)
 	}
 	return p.tokens[i]
 }
 
-func (p *parser) match(k kind) token {
+// report records err in recovery mode. Errors at or before the offset
+// of previously reported error are ignored, as they are most likely
+// caused by that error.
+func (p *parser) report(err error) {
+	e := err.(*Error)
+	if n := len(p.errors); n > 0 && e.Offset <= p.errors[n-1].Offset {
+		return
+	}
+	p.errors = append(p.errors, e)
+}
+
+// try returns the result of f. In recovery mode, if f fails, the error
+// is reported, tokens are skipped till the next ']', ')', ',', operator or keyword,
+// and a BadExpr spanning from begin till the skipped tokens is returned.
+func (p *parser) try(f func() (Expr, error)) (Expr, error) {
+	begin := p.begin()
+	expr, err := f()
+	if err == nil || !p.recovering {
+		return expr, err
+	}
+	p.report(err)
+	p.skip(TokenEQ, TokenNEQ, TokenLT, TokenLTE, TokenGT, TokenGTE, TokenPlus, TokenMinus, TokenMultiply, TokenMod, TokenDiv, TokenAnd, TokenOr, TokenPipe, TokenComma,
+		TokenTo, TokenIn, TokenReturn, TokenSatisfies, TokenThen, TokenElse, TokenInstance, TokenTreat, TokenCastable, TokenCast,
+		TokenValueEQ, TokenValueNE, TokenValueLT, TokenValueLE, TokenValueGT, TokenValueGE,
+		TokenIs, TokenPrecedes, TokenFollows, TokenIDiv, TokenIntersect, TokenExcept, TokenUnion, TokenConcat, TokenBang, TokenArrow)
+	end := p.end
+	if end < begin {
+		end = begin
+	}
+	return &BadExpr{Span{p.pos(begin), p.pos(end)}}, nil
+}
+
+// skip skips tokens till one of the given kinds, an unmatched ']', ')' or '}',
+// or eof. Tokens enclosed in brackets, parentheses or braces are skipped as
+// a whole. Illegal tokens skipped are reported.
+func (p *parser) skip(kinds ...TokenKind) {
+	depth := 0
+	for {
+		k := p.token(0).kind
+		if depth == 0 {
+			switch k {
+			case TokenEOF, TokenRBracket, TokenRParen, TokenRBrace:
+				return
+			}
+			for _, stop := range kinds {
+				if k == stop {
+					return
+				}
+			}
+		}
+		switch k {
+		case TokenEOF:
+			return
+		case TokenLBracket, TokenLParen, TokenLBrace:
+			depth++
+		case TokenRBracket, TokenRParen, TokenRBrace:
+			depth--
+		case TokenIllegal:
+			p.report(p.token(0).err)
+		}
+		p.match(k)
+	}
+}
+
+// close matches the closing token k. In recovery mode, if current token
+// is not k, the error is reported and tokens are skipped till k.
+func (p *parser) close(k TokenKind) error {
+	if p.recovering && p.token(0).kind != k {
+		p.report(p.expectedTokens(k))
+		p.skip(k)
+		if p.token(0).kind != k {
+			return nil
+		}
+	}
+	_, err := p.expect(k)
+	return err
+}
+
+// match consumes current token, which must be of kind k.
+func (p *parser) match(k TokenKind) token {
 	t := p.token(0)
 	if t.kind != k {
-		panic(p.error("expected %v, but got %v", k, t.kind))
+		panic(fmt.Sprintf("xpathparser: matching %v, but got %v", k, t.kind))
 	}
 	p.tokens = p.tokens[1:]
+	p.end = t.end
+	if t.kind == TokenLiteral {
+		p.end++ // closing quote
+	}
 	return t
 }
 
-func (p *parser) parse() Expr {
-	expr := p.orExpr()
-	p.match(eof)
-	return expr
+// expect consumes current token, if it is of kind k.
+func (p *parser) expect(k TokenKind) (token, error) {
+	if p.token(0).kind != k {
+		return token{}, p.expectedTokens(k)
+	}
+	return p.match(k), nil
+}
+
+// relex discards the lookahead tokens, so that the tokens following the
+// last matched token are lexed again. expectOp tells whether an operator
+// is expected after the last matched token.
+func (p *parser) relex(expectOp bool) {
+	p.tokens = p.tokens[:0]
+	p.lexer.pos, p.lexer.expectOp = p.end, expectOp
+}
+
+// begin returns the offset at which the current token starts.
+func (p *parser) begin() int {
+	t := p.token(0)
+	if t.kind == TokenLiteral {
+		return t.begin - 1 // opening quote
+	}
+	return t.begin
+}
+
+// span returns the span from begin till the end of last matched token.
+func (p *parser) span(begin int) Span {
+	return Span{p.pos(begin), p.pos(p.end)}
+}
+
+func (p *parser) pos(offset int) Pos {
+	return p.lexer.position(offset)
+}
+
+func (p *parser) parse() (Expr, error) {
+	if max := p.options.MaxLength; max > 0 && len(p.lexer.xpath) > max {
+		pos := p.pos(max)
+		err := &Error{
+			Msg:    fmt.Sprintf("xpath length %d exceeds limit %d", len(p.lexer.xpath), max),
+			XPath:  p.lexer.xpath,
+			Offset: max,
+			Code:   LimitExceeded,
+			Line:   pos.Line,
+			Column: pos.Column,
+			Actual: TokenIllegal,
+			end:    len(p.lexer.xpath),
+		}
+		if p.recovering {
+			p.report(err)
+			return &BadExpr{Span{p.pos(0), p.pos(len(p.lexer.xpath))}}, nil
+		}
+		return nil, err
+	}
+	expr, err := p.try(p.expr)
+	if err != nil {
+		return nil, err
+	}
+	if p.recovering && p.token(0).kind != TokenEOF {
+		p.report(p.unexpectedToken())
+		for p.token(0).kind != TokenEOF {
+			p.match(p.token(0).kind)
+		}
+	}
+	if _, err := p.expect(TokenEOF); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
+// expr parses Expr, which in XPath 2.0 is a sequence of ExprSingle
+// separated by ','.
+func (p *parser) expr() (Expr, error) {
+	if p.options.Version < XPath20 {
+		return p.exprSingle()
+	}
+	begin := p.begin()
+	expr, err := p.exprSingle()
+	if err != nil || p.token(0).kind != TokenComma {
+		return expr, err
+	}
+	items := []Expr{expr}
+	for p.token(0).kind == TokenComma {
+		p.match(TokenComma)
+		item, err := p.exprSingle()
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return &SequenceExpr{items, p.span(begin)}, nil
+}
+
+// exprSingle parses ExprSingle, which in XPath 1.0 is OrExpr.
+func (p *parser) exprSingle() (Expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if p.options.Version >= XPath20 && p.token(0).kind == TokenIdentifier {
+		switch name, next := p.token(0).text(), p.token(1).kind; {
+		case name == "for" && next == TokenDollar:
+			return p.forExpr()
+		case (name == "some" || name == "every") && next == TokenDollar:
+			return p.quantifiedExpr()
+		case name == "if" && next == TokenLParen:
+			return p.ifExpr()
+		case name == "let" && next == TokenDollar && p.options.Version >= XPath30:
+			return p.letExpr()
+		}
+	}
+	return p.orExpr()
+}
+
+func (p *parser) forExpr() (Expr, error) {
+	begin := p.begin()
+	p.match(TokenIdentifier)
+	return p.forBinding(begin)
+}
+
+// forBinding parses the variable binding of for expression, starting
+// at begin, and the rest of the expression.
+func (p *parser) forBinding(begin int) (Expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	v, in, err := p.binding()
+	if err != nil {
+		return nil, err
+	}
+	var ret Expr
+	if p.token(0).kind == TokenComma {
+		p.match(TokenComma)
+		ret, err = p.forBinding(p.begin())
+	} else if _, err = p.expect(TokenReturn); err == nil {
+		ret, err = p.exprSingle()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ForExpr{v.Prefix, v.Local, in, ret, p.span(begin)}, nil
+}
+
+func (p *parser) letExpr() (Expr, error) {
+	begin := p.begin()
+	p.match(TokenIdentifier)
+	return p.letBinding(begin)
+}
+
+// letBinding parses the variable binding of let expression, starting
+// at begin, and the rest of the expression.
+func (p *parser) letBinding(begin int) (Expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	if p.token(0).kind != TokenDollar {
+		return nil, p.expectedTokens(TokenDollar)
+	}
+	v, err := p.variableReference()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenAssign); err != nil {
+		return nil, err
+	}
+	value, err := p.exprSingle()
+	if err != nil {
+		return nil, err
+	}
+	var ret Expr
+	if p.token(0).kind == TokenComma {
+		p.match(TokenComma)
+		ret, err = p.letBinding(p.begin())
+	} else if _, err = p.expect(TokenReturn); err == nil {
+		ret, err = p.exprSingle()
+	}
+	if err != nil {
+		return nil, err
+	}
+	vr := v.(*VarRef)
+	return &LetExpr{vr.Prefix, vr.Local, value, ret, p.span(begin)}, nil
+}
+
+func (p *parser) quantifiedExpr() (Expr, error) {
+	begin := p.begin()
+	every := p.match(TokenIdentifier).text() == "every"
+	return p.quantifiedBinding(begin, every)
+}
+
+// quantifiedBinding parses the variable binding of quantified expression,
+// starting at begin, and the rest of the expression.
+func (p *parser) quantifiedBinding(begin int, every bool) (Expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	v, in, err := p.binding()
+	if err != nil {
+		return nil, err
+	}
+	var satisfies Expr
+	if p.token(0).kind == TokenComma {
+		p.match(TokenComma)
+		satisfies, err = p.quantifiedBinding(p.begin(), every)
+	} else if _, err = p.expect(TokenSatisfies); err == nil {
+		satisfies, err = p.exprSingle()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &QuantifiedExpr{every, v.Prefix, v.Local, in, satisfies, p.span(begin)}, nil
+}
+
+// binding parses "$name in ExprSingle".
+func (p *parser) binding() (*VarRef, Expr, error) {
+	if p.token(0).kind != TokenDollar {
+		return nil, nil, p.expectedTokens(TokenDollar)
+	}
+	v, err := p.variableReference()
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := p.expect(TokenIn); err != nil {
+		return nil, nil, err
+	}
+	in, err := p.exprSingle()
+	if err != nil {
+		return nil, nil, err
+	}
+	return v.(*VarRef), in, nil
 }
 
-func (p *parser) orExpr() Expr {
-	expr := p.andExpr()
-	if p.token(0).kind == or {
-		p.match(or)
-		return &BinaryExpr{expr, Or, p.orExpr()}
+func (p *parser) ifExpr() (Expr, error) {
+	begin := p.begin()
+	p.match(TokenIdentifier)
+	p.match(TokenLParen)
+	cond, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.close(TokenRParen); err != nil {
+		return nil, err
 	}
-	return expr
+	if _, err := p.expect(TokenThen); err != nil {
+		return nil, err
+	}
+	then, err := p.exprSingle()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenElse); err != nil {
+		return nil, err
+	}
+	els, err := p.exprSingle()
+	if err != nil {
+		return nil, err
+	}
+	return &IfExpr{cond, then, els, p.span(begin)}, nil
 }
 
-func (p *parser) andExpr() Expr {
-	expr := p.equalityExpr()
-	if p.token(0).kind == and {
-		p.match(and)
-		return &BinaryExpr{expr, And, p.andExpr()}
+func (p *parser) orExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.andExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.token(0).kind == TokenOr {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		p.match(TokenOr)
+		rhs, err := p.andExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr = &BinaryExpr{expr, Or, rhs, p.span(begin)}
 	}
-	return expr
+	return expr, nil
 }
 
-func (p *parser) equalityExpr() Expr {
-	expr := p.relationalExpr()
+func (p *parser) andExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.equalityExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.token(0).kind == TokenAnd {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		p.match(TokenAnd)
+		rhs, err := p.equalityExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr = &BinaryExpr{expr, And, rhs, p.span(begin)}
+	}
+	return expr, nil
+}
+
+func (p *parser) equalityExpr() (Expr, error) {
+	if p.options.Version >= XPath20 {
+		return p.comparisonExpr()
+	}
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.relationalExpr()
+	if err != nil {
+		return nil, err
+	}
 	for {
-		switch kind := p.token(0).kind; kind {
-		case eq, neq:
-			p.match(kind)
-			expr = &BinaryExpr{expr, Op(kind), p.relationalExpr()}
+		switch k := p.token(0).kind; k {
+		case TokenEQ, TokenNEQ:
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			p.match(k)
+			rhs, err := p.relationalExpr()
+			if err != nil {
+				return nil, err
+			}
+			expr = &BinaryExpr{expr, Op(k), rhs, p.span(begin)}
 		default:
-			return expr
+			return expr, nil
 		}
 	}
 }
 
-func (p *parser) relationalExpr() Expr {
-	expr := p.additiveExpr()
+func (p *parser) relationalExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.additiveExpr()
+	if err != nil {
+		return nil, err
+	}
 	for {
-		switch kind := p.token(0).kind; kind {
-		case lt, lte, gt, gte:
-			p.match(kind)
-			expr = &BinaryExpr{expr, Op(kind), p.additiveExpr()}
+		switch k := p.token(0).kind; k {
+		case TokenLT, TokenLTE, TokenGT, TokenGTE:
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			p.match(k)
+			rhs, err := p.additiveExpr()
+			if err != nil {
+				return nil, err
+			}
+			expr = &BinaryExpr{expr, Op(k), rhs, p.span(begin)}
 		default:
-			return expr
+			return expr, nil
 		}
 	}
 }
 
-func (p *parser) additiveExpr() Expr {
-	expr := p.multiplicativeExpr()
+// comparisonExpr parses ComparisonExpr of XPath 2.0, in which general,
+// value and node comparisons have same precedence and are not associative.
+func (p *parser) comparisonExpr() (Expr, error) {
+	begin := p.begin()
+	expr, err := p.stringConcatExpr()
+	if err != nil {
+		return nil, err
+	}
+	switch k := p.token(0).kind; k {
+	case TokenEQ, TokenNEQ, TokenLT, TokenLTE, TokenGT, TokenGTE,
+		TokenValueEQ, TokenValueNE, TokenValueLT, TokenValueLE, TokenValueGT, TokenValueGE,
+		TokenIs, TokenPrecedes, TokenFollows:
+		p.match(k)
+		rhs, err := p.stringConcatExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr = &BinaryExpr{expr, tokenOp(k), rhs, p.span(begin)}
+	}
+	return expr, nil
+}
+
+// stringConcatExpr parses StringConcatExpr of XPath 3.0. In XPath 2.0,
+// it is just RangeExpr, as '||' is not lexed.
+func (p *parser) stringConcatExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.rangeExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.token(0).kind == TokenConcat {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		p.match(TokenConcat)
+		rhs, err := p.rangeExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr = &BinaryExpr{expr, Concat, rhs, p.span(begin)}
+	}
+	return expr, nil
+}
+
+// tokenOp returns the binary operator of token kind k.
+func tokenOp(k TokenKind) Op {
+	if k >= TokenValueEQ {
+		return ValueEQ + Op(k-TokenValueEQ)
+	}
+	return Op(k)
+}
+
+func (p *parser) rangeExpr() (Expr, error) {
+	begin := p.begin()
+	expr, err := p.additiveExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.token(0).kind == TokenTo {
+		p.match(TokenTo)
+		rhs, err := p.additiveExpr()
+		if err != nil {
+			return nil, err
+		}
+		expr = &BinaryExpr{expr, To, rhs, p.span(begin)}
+	}
+	return expr, nil
+}
+
+func (p *parser) additiveExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.multiplicativeExpr()
+	if err != nil {
+		return nil, err
+	}
 	for {
-		switch kind := p.token(0).kind; kind {
-		case plus, minus:
-			p.match(kind)
-			expr = &BinaryExpr{expr, Op(kind), p.multiplicativeExpr()}
+		switch k := p.token(0).kind; k {
+		case TokenPlus, TokenMinus:
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			p.match(k)
+			rhs, err := p.multiplicativeExpr()
+			if err != nil {
+				return nil, err
+			}
+			expr = &BinaryExpr{expr, Op(k), rhs, p.span(begin)}
 		default:
-			return expr
+			return expr, nil
 		}
 	}
 }
 
-func (p *parser) multiplicativeExpr() Expr {
-	expr := p.unaryExpr()
+func (p *parser) multiplicativeExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	operand := p.unaryExpr
+	if p.options.Version >= XPath20 {
+		operand = p.unionExpr // unary minus binds tighter than union
+	}
+	begin := p.begin()
+	expr, err := operand()
+	if err != nil {
+		return nil, err
+	}
 	for {
-		switch kind := p.token(0).kind; kind {
-		case multiply, div, mod:
-			p.match(kind)
-			expr = &BinaryExpr{expr, Op(kind), p.unaryExpr()}
+		switch k := p.token(0).kind; k {
+		case TokenMultiply, TokenDiv, TokenMod, TokenIDiv:
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			p.match(k)
+			rhs, err := operand()
+			if err != nil {
+				return nil, err
+			}
+			expr = &BinaryExpr{expr, tokenOp(k), rhs, p.span(begin)}
 		default:
-			return expr
+			return expr, nil
 		}
 	}
 }
 
-func (p *parser) unaryExpr() Expr {
-	if p.token(0).kind == minus {
-		p.match(minus)
-		return &NegateExpr{p.unionExpr()}
+// unaryExpr parses UnaryExpr. Unary '+' is allowed only in XPath 2.0.
+func (p *parser) unaryExpr() (Expr, error) {
+	if k := p.token(0).kind; k == TokenMinus || k == TokenPlus && p.options.Version >= XPath20 {
+		begin := p.begin()
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		defer p.leave()
+		p.match(k)
+		expr, err := p.unaryExpr()
+		if err != nil {
+			return nil, err
+		}
+		if k == TokenPlus {
+			return &UnaryPlusExpr{expr, p.span(begin)}, nil
+		}
+		return &NegateExpr{expr, p.span(begin)}, nil
+	}
+	if p.options.Version >= XPath30 {
+		return p.simpleMapExpr()
+	}
+	if p.options.Version >= XPath20 {
+		return p.try(p.pathExpr)
 	}
 	return p.unionExpr()
 }
 
-func (p *parser) unionExpr() Expr {
-	expr := p.pathExpr()
-	if p.token(0).kind == pipe {
-		p.match(pipe)
-		return &BinaryExpr{expr, Union, p.orExpr()}
+func (p *parser) simpleMapExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.try(p.pathExpr)
+	if err != nil {
+		return nil, err
+	}
+	for p.token(0).kind == TokenBang {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		p.match(TokenBang)
+		rhs, err := p.try(p.pathExpr)
+		if err != nil {
+			return nil, err
+		}
+		expr = &SimpleMapExpr{expr, rhs, p.span(begin)}
+	}
+	return expr, nil
+}
+
+func (p *parser) unionExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	operand := func() (Expr, error) {
+		return p.try(p.pathExpr)
+	}
+	if p.options.Version >= XPath20 {
+		operand = p.intersectExceptExpr
+	}
+	begin := p.begin()
+	expr, err := operand()
+	if err != nil {
+		return nil, err
+	}
+	// "union" is a keyword of XPath 2.0 for '|'
+	for k := p.token(0).kind; k == TokenPipe || k == TokenUnion; k = p.token(0).kind {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		p.match(k)
+		rhs, err := operand()
+		if err != nil {
+			return nil, err
+		}
+		expr = &BinaryExpr{expr, Union, rhs, p.span(begin)}
 	}
-	return expr
+	return expr, nil
 }
 
-func (p *parser) pathExpr() Expr {
+func (p *parser) intersectExceptExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.instanceofExpr()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		switch k := p.token(0).kind; k {
+		case TokenIntersect, TokenExcept:
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			p.match(k)
+			rhs, err := p.instanceofExpr()
+			if err != nil {
+				return nil, err
+			}
+			expr = &BinaryExpr{expr, tokenOp(k), rhs, p.span(begin)}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+func (p *parser) instanceofExpr() (Expr, error) {
+	begin := p.begin()
+	expr, err := p.treatExpr()
+	if err != nil || p.token(0).kind != TokenInstance {
+		return expr, err
+	}
+	p.match(TokenInstance)
+	if _, err := p.expect(TokenOf); err != nil {
+		return nil, err
+	}
+	t, err := p.sequenceType()
+	if err != nil {
+		return nil, err
+	}
+	return &InstanceOfExpr{expr, t, p.span(begin)}, nil
+}
+
+func (p *parser) treatExpr() (Expr, error) {
+	begin := p.begin()
+	expr, err := p.castableExpr()
+	if err != nil || p.token(0).kind != TokenTreat {
+		return expr, err
+	}
+	p.match(TokenTreat)
+	if _, err := p.expect(TokenAs); err != nil {
+		return nil, err
+	}
+	t, err := p.sequenceType()
+	if err != nil {
+		return nil, err
+	}
+	return &TreatExpr{expr, t, p.span(begin)}, nil
+}
+
+func (p *parser) castableExpr() (Expr, error) {
+	begin := p.begin()
+	expr, err := p.castExpr()
+	if err != nil || p.token(0).kind != TokenCastable {
+		return expr, err
+	}
+	p.match(TokenCastable)
+	if _, err := p.expect(TokenAs); err != nil {
+		return nil, err
+	}
+	t, err := p.singleType()
+	if err != nil {
+		return nil, err
+	}
+	return &CastableExpr{expr, t, p.span(begin)}, nil
+}
+
+func (p *parser) castExpr() (Expr, error) {
+	operand := p.unaryExpr
+	if p.options.Version >= XPath31 {
+		operand = p.arrowExpr
+	}
+	begin := p.begin()
+	expr, err := operand()
+	if err != nil || p.token(0).kind != TokenCast {
+		return expr, err
+	}
+	p.match(TokenCast)
+	if _, err := p.expect(TokenAs); err != nil {
+		return nil, err
+	}
+	t, err := p.singleType()
+	if err != nil {
+		return nil, err
+	}
+	return &CastExpr{expr, t, p.span(begin)}, nil
+}
+
+// arrowExpr parses ArrowExpr of XPath 3.1, whose operand is UnaryExpr.
+func (p *parser) arrowExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
+	expr, err := p.unaryExpr()
+	if err != nil {
+		return nil, err
+	}
+	for p.token(0).kind == TokenArrow {
+		if err := p.enter(); err != nil {
+			return nil, err
+		}
+		p.match(TokenArrow)
+		call, err := p.arrowCall()
+		if err != nil {
+			return nil, err
+		}
+		expr = &ArrowExpr{expr, call, p.span(begin)}
+	}
+	return expr, nil
+}
+
+// arrowCall parses the function call following '=>'. The function is
+// specified by name, variable reference or parenthesized expression.
+func (p *parser) arrowCall() (Expr, error) {
+	begin := p.begin()
+	var f Expr
+	var err error
 	switch p.token(0).kind {
-	case number, literal:
-		filter := p.filterExpr()
-		switch p.token(0).kind {
-		case slash, slashSlash:
-			panic(p.error("nodeset expected"))
+	case TokenIdentifier:
+		return p.functionCall()
+	case TokenDollar:
+		f, err = p.variableReference()
+	case TokenLParen:
+		p.match(TokenLParen)
+		if f, err = p.expr(); err == nil {
+			err = p.close(TokenRParen)
 		}
-		return filter
-	case lparen, dollar:
-		filter := p.filterExpr()
-		switch p.token(0).kind {
-		case slash, slashSlash:
-			return &PathExpr{filter, p.locationPath(false)}
-		}
-		return filter
-	case identifier:
-		if (p.token(1).kind == lparen && !isNodeTypeName(p.token(0))) || (p.token(1).kind == colon && p.token(3).kind == lparen) {
-			filter := p.filterExpr()
-			switch p.token(0).kind {
-			case slash, slashSlash:
-				return &PathExpr{filter, p.locationPath(false)}
-			}
-			return filter
-		}
-		return p.locationPath(false)
-	case dot, dotDot, star, at:
-		return p.locationPath(false)
-	case slash, slashSlash:
-		return p.locationPath(true)
 	default:
-		panic(p.unexpectedToken())
+		return nil, p.expectedTokens(TokenIdentifier, TokenDollar, TokenLParen)
 	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenLParen); err != nil {
+		return nil, err
+	}
+	args, err := p.arguments()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.close(TokenRParen); err != nil {
+		return nil, err
+	}
+	return &DynamicCallExpr{f, args, p.span(begin)}, nil
 }
 
-func (p *parser) filterExpr() Expr {
+// sequenceType parses SequenceType. The occurrence indicators '*' and '+'
+// are bound to the type, so in "$a instance of xs:integer + 1", '+' is
+// not the addition operator.
+func (p *parser) sequenceType() (*SequenceType, error) {
+	begin := p.begin()
+	if t := p.token(0); t.kind == TokenIdentifier && t.text() == "empty-sequence" && p.token(1).kind == TokenLParen {
+		p.match(TokenIdentifier)
+		p.match(TokenLParen)
+		if _, err := p.expect(TokenRParen); err != nil {
+			return nil, err
+		}
+		return &SequenceType{nil, ExactlyOne, p.span(begin)}, nil
+	}
+	itemType, err := p.itemType()
+	if err != nil {
+		return nil, err
+	}
+	occurrence := ExactlyOne
+	switch k := p.token(0).kind; k {
+	case TokenQuestion, TokenMultiply, TokenPlus:
+		p.match(k)
+		switch k {
+		case TokenQuestion:
+			occurrence = ZeroOrOne
+		case TokenMultiply:
+			occurrence = ZeroOrMore
+		default:
+			occurrence = OneOrMore
+		}
+		// '*' and '+' are lexed as operators, which expect an operand
+		p.relex(true)
+	}
+	return &SequenceType{itemType, occurrence, p.span(begin)}, nil
+}
+
+// singleType parses SingleType, which is an atomic type optionally
+// followed by '?'.
+func (p *parser) singleType() (*SequenceType, error) {
+	begin := p.begin()
+	atomicType, err := p.atomicType()
+	if err != nil {
+		return nil, err
+	}
+	occurrence := ExactlyOne
+	if p.token(0).kind == TokenQuestion {
+		p.match(TokenQuestion)
+		occurrence = ZeroOrOne
+	}
+	return &SequenceType{atomicType, occurrence, p.span(begin)}, nil
+}
+
+func (p *parser) itemType() (ItemType, error) {
+	if p.options.Version >= XPath30 && p.token(0).kind == TokenLParen {
+		return p.parenthesizedItemType()
+	}
+	if p.token(0).kind != TokenIdentifier || p.token(1).kind != TokenLParen {
+		return p.atomicType()
+	}
+	switch name := p.token(0).text(); {
+	case name == "item":
+		begin := p.begin()
+		p.match(TokenIdentifier)
+		p.match(TokenLParen)
+		if _, err := p.expect(TokenRParen); err != nil {
+			return nil, err
+		}
+		return &AnyItem{p.span(begin)}, nil
+	case name == "function" && p.options.Version >= XPath30:
+		return p.functionTest()
+	case name == "map" && p.options.Version >= XPath31:
+		return p.mapTest()
+	case name == "array" && p.options.Version >= XPath31:
+		return p.arrayTest()
+	case p.isNodeTypeName(p.token(0)):
+		nodeTest, err := p.nodeTypeTest()
+		if err != nil {
+			return nil, err
+		}
+		return nodeTest.(ItemType), nil
+	default:
+		names := []string{"item", "empty-sequence"}
+		if p.options.Version >= XPath30 {
+			names = append(names, "function")
+		}
+		if p.options.Version >= XPath31 {
+			names = append(names, "map", "array")
+		}
+		err := p.error(InvalidNodeType, "invalid item type %q", name)
+		return nil, suggest(err, name, append(names, p.nodeTypeNames()...))
+	}
+}
+
+// parenthesizedItemType parses item type enclosed in parentheses, as in
+// (function() as xs:integer)*, where the occurrence indicator applies to
+// the function test rather than its return type.
+func (p *parser) parenthesizedItemType() (ItemType, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	p.match(TokenLParen)
+	itemType, err := p.itemType()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+	return itemType, nil
+}
+
+// functionTest parses FunctionTest of XPath 3.0. The current token is
+// "function", followed by '('.
+func (p *parser) functionTest() (*FunctionTest, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	begin := p.begin()
+	p.match(TokenIdentifier)
+	p.match(TokenLParen)
+	if p.token(0).kind == TokenStar {
+		p.match(TokenStar)
+		if _, err := p.expect(TokenRParen); err != nil {
+			return nil, err
+		}
+		return &FunctionTest{Span: p.span(begin)}, nil
+	}
+	var paramTypes []*SequenceType
+	if p.token(0).kind != TokenRParen {
+		for {
+			t, err := p.sequenceType()
+			if err != nil {
+				return nil, err
+			}
+			paramTypes = append(paramTypes, t)
+			if p.token(0).kind != TokenComma {
+				break
+			}
+			p.match(TokenComma)
+		}
+	}
+	if _, err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenAs); err != nil {
+		return nil, err
+	}
+	returnType, err := p.sequenceType()
+	if err != nil {
+		return nil, err
+	}
+	return &FunctionTest{paramTypes, returnType, p.span(begin)}, nil
+}
+
+// mapTest parses MapTest of XPath 3.1. The current token is "map",
+// followed by '('.
+func (p *parser) mapTest() (*MapTest, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	begin := p.begin()
+	p.match(TokenIdentifier)
+	p.match(TokenLParen)
+	if p.token(0).kind == TokenStar {
+		p.match(TokenStar)
+		if _, err := p.expect(TokenRParen); err != nil {
+			return nil, err
+		}
+		return &MapTest{Span: p.span(begin)}, nil
+	}
+	keyType, err := p.atomicType()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenComma); err != nil {
+		return nil, err
+	}
+	valueType, err := p.sequenceType()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+	return &MapTest{keyType, valueType, p.span(begin)}, nil
+}
+
+// arrayTest parses ArrayTest of XPath 3.1. The current token is "array",
+// followed by '('.
+func (p *parser) arrayTest() (*ArrayTest, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+	begin := p.begin()
+	p.match(TokenIdentifier)
+	p.match(TokenLParen)
+	if p.token(0).kind == TokenStar {
+		p.match(TokenStar)
+		if _, err := p.expect(TokenRParen); err != nil {
+			return nil, err
+		}
+		return &ArrayTest{Span: p.span(begin)}, nil
+	}
+	memberType, err := p.sequenceType()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+	return &ArrayTest{memberType, p.span(begin)}, nil
+}
+
+func (p *parser) atomicType() (*AtomicType, error) {
+	begin := p.begin()
+	prefix, local, err := p.qname()
+	if err != nil {
+		return nil, err
+	}
+	return &AtomicType{prefix, local, p.span(begin)}, nil
+}
+
+// isStarPrefix tells whether the current token, following star, is ':'
+// of wildcard *:local, which must not contain whitespace.
+func (p *parser) isStarPrefix(star token) bool {
+	t0, t1 := p.token(0), p.token(1)
+	return p.options.Version >= XPath20 && t0.kind == TokenColon && t1.kind == TokenIdentifier &&
+		star.end == t0.begin && t0.end == t1.begin
+}
+
+// qname parses QName, or EQName in XPath 3.0, and returns its prefix
+// and local part.
+func (p *parser) qname() (prefix, local string, err error) {
+	if p.isPrefixed(0) {
+		prefix = p.match(TokenIdentifier).text()
+		p.match(TokenColon)
+	}
+	t, err := p.expect(TokenIdentifier)
+	if err != nil {
+		return "", "", err
+	}
+	prefix, local, err = p.localName(prefix, t)
+	if err == nil && local == "*" {
+		err = p.errorAt(t, InvalidName, "wildcard %s not allowed", t.text())
+	}
+	return prefix, local, err
+}
+
+// localName returns the prefix and local part of name, whose local part
+// is identifier t. EQName of XPath 3.0, such as Q{uri}local, is lexed
+// as single identifier; its braced URI is returned as prefix, and its
+// local part may be '*'.
+func (p *parser) localName(prefix string, t token) (string, string, error) {
+	name := t.text()
+	if !isBracedURI(name) {
+		return prefix, name, nil
+	}
+	if prefix != "" {
+		return "", "", p.errorAt(t, InvalidName, "invalid local name %s", name)
+	}
+	i := strings.IndexByte(name, '}') + 1
+	return name[:i], name[i:], nil
+}
+
+// isPrefixed tells whether the token at offset i is the prefix of QName.
+// In XPath 3.1, where ':' also separates the key and value of map entry,
+// QName must not contain whitespace, so that in map {$a : b} the key is
+// variable a.
+func (p *parser) isPrefixed(i int) bool {
+	t0, t1 := p.token(i), p.token(i+1)
+	if t0.kind != TokenIdentifier || t1.kind != TokenColon || isBracedURI(t0.text()) {
+		return false
+	}
+	if p.options.Version < XPath31 {
+		return true
+	}
+	t2 := p.token(i + 2)
+	return t0.end == t1.begin && t1.end == t2.begin && (t2.kind == TokenIdentifier || t2.kind == TokenStar)
+}
+
+func (p *parser) pathExpr() (Expr, error) {
+	begin := p.begin()
+	switch p.token(0).kind {
+	case TokenNumber, TokenLiteral:
+		if p.options.Version >= XPath20 {
+			return p.filterPathExpr(begin)
+		}
+		filter, err := p.filterExpr()
+		if err != nil {
+			return nil, err
+		}
+		switch p.token(0).kind {
+		case TokenSlash, TokenSlashSlash:
+			return nil, p.error(NodeSetExpected, "nodeset expected")
+		}
+		return filter, nil
+	case TokenLParen, TokenDollar:
+		return p.filterPathExpr(begin)
+	case TokenIdentifier:
+		if p.isFilterStart(0) {
+			return p.filterPathExpr(begin)
+		}
+		return p.locationPathExpr(false, begin)
+	case TokenDot, TokenDotDot, TokenStar, TokenAt:
+		return p.locationPathExpr(false, begin)
+	case TokenSlash, TokenSlashSlash:
+		return p.locationPathExpr(true, begin)
+	case TokenLBracket, TokenQuestion:
+		if p.options.Version >= XPath31 {
+			return p.filterPathExpr(begin)
+		}
+	}
+	return nil, p.unexpectedToken()
+}
+
+// isFilterStart tells whether the token at offset i starts a filter
+// expression rather than a location path.
+func (p *parser) isFilterStart(i int) bool {
+	switch t := p.token(i); t.kind {
+	case TokenNumber, TokenLiteral, TokenLParen, TokenDollar:
+		return true
+	case TokenIdentifier:
+		return (p.token(i+1).kind == TokenLParen && !p.isNodeTypeName(t)) ||
+			(p.isPrefixed(i) && p.token(i+2).kind == TokenIdentifier && p.token(i+3).kind == TokenLParen) ||
+			p.isNamedFunctionRef(i) || p.isCurlyConstructor(i)
+	case TokenLBracket, TokenQuestion:
+		return p.options.Version >= XPath31
+	}
+	return false
+}
+
+// isFilterStep tells whether the current token is '/' or '//' followed by
+// filter expression, which is a step of path in XPath 2.0, as in a/string().
+func (p *parser) isFilterStep() bool {
+	switch p.token(0).kind {
+	case TokenSlash, TokenSlashSlash:
+		return p.options.Version >= XPath20 && p.isFilterStart(1)
+	}
+	return false
+}
+
+// filterPathExpr parses filter expression, optionally followed by steps.
+func (p *parser) filterPathExpr(begin int) (Expr, error) {
+	filter, err := p.filterExpr()
+	if err != nil {
+		return nil, err
+	}
+	return p.pathSteps(filter, begin)
+}
+
+// locationPathExpr parses location path, optionally followed by steps.
+func (p *parser) locationPathExpr(abs bool, begin int) (Expr, error) {
+	lp, err := p.locationPath(abs)
+	if err != nil {
+		return nil, err
+	}
+	return p.pathSteps(lp, begin)
+}
+
+// pathSteps parses the steps following expr, which starts at begin.
+// Location steps are parsed as *PathExpr, and the filter expressions of
+// XPath 2.0 as *SlashExpr, nesting the path parsed so far one level
+// deeper: "$a/b/string()" is parsed as "($a/b)/string()".
+func (p *parser) pathSteps(expr Expr, begin int) (Expr, error) {
+	defer p.leaveTo(p.depth)
+	for {
+		switch k := p.token(0).kind; {
+		case p.isFilterStep():
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			if k == TokenSlashSlash {
+				step, err := p.descendantOrSelf()
+				if err != nil {
+					return nil, err
+				}
+				expr = p.appendStep(expr, step, begin)
+			} else {
+				p.match(TokenSlash)
+			}
+			rhs, err := p.filterExpr()
+			if err != nil {
+				return nil, err
+			}
+			expr = &SlashExpr{expr, rhs, p.span(begin)}
+		case k == TokenSlash || k == TokenSlashSlash:
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			lp, err := p.relativeLocationPath()
+			if err != nil {
+				return nil, err
+			}
+			expr = &PathExpr{expr, lp.(*LocationPath), p.span(begin)}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// appendStep returns expr, which starts at begin, followed by step.
+func (p *parser) appendStep(expr Expr, step *Step, begin int) Expr {
+	switch e := expr.(type) {
+	case *LocationPath:
+		e.Steps, e.Span = append(e.Steps, step), p.span(begin)
+		return e
+	case *PathExpr:
+		lp := e.LocationPath
+		lp.Steps, lp.Span.End = append(lp.Steps, step), step.Span.End
+		e.Span = p.span(begin)
+		return e
+	}
+	return &PathExpr{expr, &LocationPath{false, []*Step{step}, step.Span}, p.span(begin)}
+}
+
+func (p *parser) filterExpr() (Expr, error) {
+	defer p.leaveTo(p.depth)
+	begin := p.begin()
 	var expr Expr
+	var err error
 	switch p.token(0).kind {
-	case number:
-		f, err := strconv.ParseFloat(p.match(number).text(), 64)
+	case TokenNumber:
+		f, perr := strconv.ParseFloat(p.token(0).text(), 64)
+		if perr != nil {
+			return nil, p.error(NumberOutOfRange, "number out of range")
+		}
+		p.match(TokenNumber)
+		expr = &Number{f, p.span(begin)}
+	case TokenLiteral:
+		expr = &String{p.match(TokenLiteral).value(), p.span(begin)}
+	case TokenLParen:
+		p.match(TokenLParen)
+		if p.options.Version >= XPath20 && p.token(0).kind == TokenRParen {
+			p.match(TokenRParen)
+			expr = &SequenceExpr{nil, p.span(begin)}
+			break
+		}
+		if expr, err = p.expr(); err != nil {
+			return nil, err
+		}
+		err = p.close(TokenRParen)
+	case TokenIdentifier:
+		switch {
+		case p.options.Version >= XPath30 && p.token(0).text() == "function" && p.token(1).kind == TokenLParen:
+			expr, err = p.inlineFunctionExpr()
+		case p.isNamedFunctionRef(0):
+			expr, err = p.namedFunctionRef()
+		case p.isCurlyConstructor(0):
+			if p.token(0).text() == "map" {
+				expr, err = p.mapConstructor()
+			} else {
+				expr, err = p.arrayConstructor()
+			}
+		default:
+			expr, err = p.functionCall()
+		}
+	case TokenDollar:
+		expr, err = p.variableReference()
+	case TokenLBracket:
+		expr, err = p.arrayConstructor()
+	case TokenQuestion:
+		expr, err = p.lookup(nil, begin)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for {
+		predicates, err := p.predicates()
+		if err != nil {
+			return nil, err
+		}
+		if len(predicates) > 0 {
+			expr = &FilterExpr{expr, predicates, p.span(begin)}
+		}
+		switch k := p.token(0).kind; {
+		case k == TokenLParen && p.options.Version >= XPath30:
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			p.match(TokenLParen)
+			args, err := p.arguments()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.close(TokenRParen); err != nil {
+				return nil, err
+			}
+			expr = &DynamicCallExpr{expr, args, p.span(begin)}
+		case k == TokenQuestion && p.options.Version >= XPath31:
+			if err := p.enter(); err != nil {
+				return nil, err
+			}
+			if expr, err = p.lookup(expr, begin); err != nil {
+				return nil, err
+			}
+		default:
+			return expr, nil
+		}
+	}
+}
+
+// isCurlyConstructor tells whether the token at offset i starts map or
+// curly array constructor of XPath 3.1, such as map {"a": 1}.
+func (p *parser) isCurlyConstructor(i int) bool {
+	if p.options.Version < XPath31 || p.token(i+1).kind != TokenLBrace {
+		return false
+	}
+	name := p.token(i).text()
+	return name == "map" || name == "array"
+}
+
+// mapConstructor parses MapConstructor of XPath 3.1. The current token
+// is "map", followed by '{'.
+func (p *parser) mapConstructor() (Expr, error) {
+	begin := p.begin()
+	p.match(TokenIdentifier)
+	p.match(TokenLBrace)
+	var entries []*MapEntry
+	for p.token(0).kind != TokenRBrace {
+		entryBegin := p.begin()
+		key, err := p.exprSingle()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := p.expect(TokenColon); err != nil {
+			return nil, err
+		}
+		value, err := p.exprSingle()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &MapEntry{key, value, p.span(entryBegin)})
+		if p.token(0).kind != TokenComma {
+			break
+		}
+		p.match(TokenComma)
+		if p.token(0).kind == TokenRBrace {
+			return nil, p.unexpectedToken()
+		}
+	}
+	if err := p.close(TokenRBrace); err != nil {
+		return nil, err
+	}
+	return &MapConstructor{entries, p.span(begin)}, nil
+}
+
+// arrayConstructor parses square array constructor, whose current token
+// is '[', or curly array constructor, whose current token is "array"
+// followed by '{', of XPath 3.1.
+func (p *parser) arrayConstructor() (Expr, error) {
+	begin := p.begin()
+	curly := p.token(0).kind == TokenIdentifier
+	closing := TokenRBracket
+	if curly {
+		p.match(TokenIdentifier)
+		p.match(TokenLBrace)
+		closing = TokenRBrace
+	} else {
+		p.match(TokenLBracket)
+	}
+	var members []Expr
+	for p.token(0).kind != closing {
+		member, err := p.exprSingle()
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+		if p.token(0).kind != TokenComma {
+			break
+		}
+		p.match(TokenComma)
+		if p.token(0).kind == closing {
+			return nil, p.unexpectedToken()
+		}
+	}
+	if err := p.close(closing); err != nil {
+		return nil, err
+	}
+	return &ArrayConstructor{curly, members, p.span(begin)}, nil
+}
+
+// lookup parses the lookup operator of XPath 3.1 applied to expr, which
+// is nil for unary lookup. The current token is '?'.
+func (p *parser) lookup(expr Expr, begin int) (Expr, error) {
+	p.match(TokenQuestion)
+	// '?' is lexed as occurrence indicator, which is followed by operator
+	p.relex(false)
+	var key Expr
+	switch t := p.token(0); t.kind {
+	case TokenIdentifier:
+		if isBracedURI(t.text()) {
+			return nil, p.errorAt(t, UnexpectedToken, "key must be NCName")
+		}
+		key = &String{p.match(TokenIdentifier).text(), p.span(t.begin)}
+	case TokenNumber:
+		if strings.Trim(t.text(), "0123456789") != "" {
+			return nil, p.errorAt(t, UnexpectedToken, "key must be integer")
+		}
+		f, err := strconv.ParseFloat(t.text(), 64)
 		if err != nil {
-			panic(err)
+			return nil, p.errorAt(t, NumberOutOfRange, "number out of range")
+		}
+		p.match(TokenNumber)
+		key = &Number{f, p.span(t.begin)}
+	case TokenStar:
+		p.match(TokenStar)
+	case TokenLParen:
+		keyBegin := p.begin()
+		p.match(TokenLParen)
+		if p.token(0).kind == TokenRParen {
+			p.match(TokenRParen)
+			key = &SequenceExpr{nil, p.span(keyBegin)}
+			break
+		}
+		var err error
+		if key, err = p.expr(); err != nil {
+			return nil, err
+		}
+		if err := p.close(TokenRParen); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.expectedTokens(TokenIdentifier, TokenNumber, TokenLParen, TokenStar)
+	}
+	return &LookupExpr{expr, key, p.span(begin)}, nil
+}
+
+func (p *parser) functionCall() (Expr, error) {
+	begin := p.begin()
+	prefix, local, err := p.functionName()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenLParen); err != nil {
+		return nil, err
+	}
+	args, err := p.arguments()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.close(TokenRParen); err != nil {
+		return nil, err
+	}
+	return &FuncCall{prefix, local, args, p.span(begin)}, nil
+}
+
+// functionName parses the name of function call or named function
+// reference, and checks that it can be used.
+func (p *parser) functionName() (prefix, local string, err error) {
+	begin := p.begin()
+	if p.isPrefixed(0) {
+		prefix = p.match(TokenIdentifier).text()
+		p.match(TokenColon)
+	}
+	t, err := p.expect(TokenIdentifier)
+	if err != nil {
+		return "", "", err
+	}
+	if prefix, local, err = p.localName(prefix, t); err != nil {
+		return "", "", err
+	}
+	if local == "*" {
+		return "", "", p.errorAt(t, InvalidName, "wildcard %s not allowed", t.text())
+	}
+	if prefix == "" && p.isReservedFunctionName(local) {
+		return "", "", p.errorAt(t, UnexpectedToken, "reserved function name %s", local)
+	}
+	if p.options.Functions != nil {
+		name := qname(prefix, local)
+		if !contains(p.options.Functions, name) {
+			t.begin = begin
+			err := p.errorAt(t, UnknownFunction, "unknown function %s", name)
+			return "", "", suggest(err, name, p.options.Functions)
+		}
+	}
+	return prefix, local, nil
+}
+
+// isNamedFunctionRef tells whether the token at offset i starts named
+// function reference of XPath 3.0, such as concat#3.
+func (p *parser) isNamedFunctionRef(i int) bool {
+	if p.isPrefixed(i) {
+		return p.token(i+2).kind == TokenIdentifier && p.token(i+3).kind == TokenHash
+	}
+	return p.token(i+1).kind == TokenHash
+}
+
+func (p *parser) namedFunctionRef() (Expr, error) {
+	begin := p.begin()
+	prefix, local, err := p.functionName()
+	if err != nil {
+		return nil, err
+	}
+	p.match(TokenHash)
+	t, err := p.expect(TokenNumber)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Trim(t.text(), "0123456789") != "" {
+		return nil, p.errorAt(t, UnexpectedToken, "arity must be integer")
+	}
+	arity, err := strconv.Atoi(t.text())
+	if err != nil {
+		return nil, p.errorAt(t, NumberOutOfRange, "arity out of range")
+	}
+	return &NamedFunctionRef{prefix, local, arity, p.span(begin)}, nil
+}
+
+// inlineFunctionExpr parses InlineFunctionExpr of XPath 3.0. The current
+// token is "function", followed by '('.
+func (p *parser) inlineFunctionExpr() (Expr, error) {
+	begin := p.begin()
+	p.match(TokenIdentifier)
+	p.match(TokenLParen)
+	var params []*Param
+	if p.token(0).kind != TokenRParen {
+		for {
+			param, err := p.param()
+			if err != nil {
+				return nil, err
+			}
+			params = append(params, param)
+			if p.token(0).kind != TokenComma {
+				break
+			}
+			p.match(TokenComma)
 		}
-		expr = Number(f)
-	case literal:
-		expr = String(p.match(literal).text())
-	case lparen:
-		p.match(lparen)
-		expr = p.orExpr()
-		p.match(rparen)
-	case identifier:
-		expr = p.functionCall()
-	case dollar:
-		expr = p.variableReference()
 	}
-	predicates := p.predicates()
-	if len(predicates) == 0 {
-		return expr
+	if err := p.close(TokenRParen); err != nil {
+		return nil, err
+	}
+	var t *SequenceType
+	if p.token(0).kind == TokenAs {
+		p.match(TokenAs)
+		var err error
+		if t, err = p.sequenceType(); err != nil {
+			return nil, err
+		}
+	}
+	lbrace, err := p.expect(TokenLBrace)
+	if err != nil {
+		return nil, err
+	}
+	var body Expr
+	if p.options.Version >= XPath31 && p.token(0).kind == TokenRBrace {
+		// empty body of XPath 3.1, which is the empty sequence
+		body = &SequenceExpr{nil, Span{p.pos(lbrace.begin), p.pos(p.token(0).end)}}
+	} else if body, err = p.expr(); err != nil {
+		return nil, err
 	}
-	return &FilterExpr{expr, predicates}
+	if err := p.close(TokenRBrace); err != nil {
+		return nil, err
+	}
+	return &InlineFunctionExpr{params, t, body, p.span(begin)}, nil
 }
 
-func (p *parser) functionCall() *FuncCall {
-	prefix := ""
-	if p.token(1).kind == colon {
-		prefix = p.match(identifier).text()
-		p.match(colon)
+// param parses "$name", optionally followed by "as SequenceType".
+func (p *parser) param() (*Param, error) {
+	begin := p.begin()
+	if _, err := p.expect(TokenDollar); err != nil {
+		return nil, err
+	}
+	prefix, local, err := p.qname()
+	if err != nil {
+		return nil, err
+	}
+	var t *SequenceType
+	if p.token(0).kind == TokenAs {
+		p.match(TokenAs)
+		if t, err = p.sequenceType(); err != nil {
+			return nil, err
+		}
 	}
-	local := p.match(identifier).text()
-	p.match(lparen)
-	args := p.arguments()
-	p.match(rparen)
-	return &FuncCall{prefix, local, args}
+	return &Param{prefix, local, t, p.span(begin)}, nil
 }
 
-func (p *parser) arguments() []Expr {
+func (p *parser) arguments() ([]Expr, error) {
 	var args []Expr
-	for p.token(0).kind != rparen {
-		args = append(args, p.orExpr())
-		if p.token(0).kind == comma {
-			p.match(comma)
+	for p.token(0).kind != TokenRParen {
+		if max := p.options.MaxArgs; max > 0 && len(args) == max {
+			return nil, p.error(LimitExceeded, "number of arguments exceeds limit %d", max)
+		}
+		arg, err := p.exprSingle()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		if p.recovering && p.token(0).kind != TokenComma && p.token(0).kind != TokenRParen {
+			p.report(p.expectedTokens(TokenComma, TokenRParen))
+			p.skip(TokenComma)
+		}
+		if p.token(0).kind == TokenComma {
+			p.match(TokenComma)
 			continue
 		}
 		break
 	}
-	return args
+	return args, nil
 }
 
-func (p *parser) predicates() []Expr {
+func (p *parser) predicates() ([]Expr, error) {
 	var predicates []Expr
-	for p.token(0).kind == lbracket {
-		p.match(lbracket)
-		predicates = append(predicates, p.orExpr())
-		p.match(rbracket)
+	for p.token(0).kind == TokenLBracket {
+		if p.npredicates++; p.options.MaxPredicates > 0 && p.npredicates > p.options.MaxPredicates {
+			return nil, p.error(LimitExceeded, "number of predicates exceeds limit %d", p.options.MaxPredicates)
+		}
+		p.match(TokenLBracket)
+		predicate, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		predicates = append(predicates, predicate)
+		if err := p.close(TokenRBracket); err != nil {
+			return nil, err
+		}
 	}
-	return predicates
+	return predicates, nil
 }
 
-func (p *parser) variableReference() *VarRef {
-	p.match(dollar)
-	prefix := ""
-	if p.token(1).kind == colon {
-		prefix = p.match(identifier).text()
-		p.match(colon)
+func (p *parser) variableReference() (Expr, error) {
+	begin := p.begin()
+	p.match(TokenDollar)
+	prefix, local, err := p.qname()
+	if err != nil {
+		return nil, err
 	}
-	return &VarRef{prefix, p.match(identifier).text()}
+	return &VarRef{prefix, local, p.span(begin)}, nil
 }
 
-func (p *parser) locationPath(abs bool) *LocationPath {
+func (p *parser) locationPath(abs bool) (Expr, error) {
 	switch p.token(0).kind {
-	case slash, slashSlash:
+	case TokenSlash, TokenSlashSlash:
 		if abs {
 			return p.absoluteLocationPath()
 		}
 		return p.relativeLocationPath()
-	case at, identifier, dot, dotDot, star:
+	case TokenAt, TokenIdentifier, TokenDot, TokenDotDot, TokenStar:
 		return p.relativeLocationPath()
 	}
-	panic(p.unexpectedToken())
+	return nil, p.unexpectedToken()
 }
 
-func (p *parser) absoluteLocationPath() *LocationPath {
+func (p *parser) absoluteLocationPath() (Expr, error) {
+	begin := p.begin()
+	if p.isFilterStep() {
+		// the root, followed by '/' and filter expression, as in /string()
+		return &LocationPath{true, nil, Span{p.pos(begin), p.pos(begin + 1)}}, nil
+	}
 	var steps []*Step
+	var err error
 	switch p.token(0).kind {
-	case slash:
-		p.match(slash)
+	case TokenSlash:
+		p.match(TokenSlash)
 		switch p.token(0).kind {
-		case dot, dotDot, at, identifier, star:
-			steps = p.steps()
+		case TokenDot, TokenDotDot, TokenAt, TokenIdentifier, TokenStar:
+			if steps, err = p.steps(); err != nil {
+				return nil, err
+			}
 		}
-	case slashSlash:
-		p.match(slashSlash)
-		steps = append(steps, &Step{DescendantOrSelf, Node, nil})
+	case TokenSlashSlash:
+		step, err := p.descendantOrSelf()
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
 		switch p.token(0).kind {
-		case dot, dotDot, at, identifier, star:
-			steps = append(steps, p.steps()...)
+		case TokenDot, TokenDotDot, TokenAt, TokenIdentifier, TokenStar:
+			more, err := p.steps()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, more...)
 		default:
-			panic(p.error(`locationPath cannot end with "//"`))
+			return nil, p.error(TrailingSlashSlash, `locationPath cannot end with "//"`)
 		}
 	}
-	return &LocationPath{true, steps}
+	return &LocationPath{true, steps, p.span(begin)}, nil
 }
 
-func (p *parser) relativeLocationPath() *LocationPath {
+func (p *parser) relativeLocationPath() (Expr, error) {
+	begin := p.begin()
 	var steps []*Step
 	switch p.token(0).kind {
-	case slash:
-		p.match(slash)
-	case slashSlash:
-		p.match(slashSlash)
-		steps = append(steps, &Step{DescendantOrSelf, Node, nil})
+	case TokenSlash:
+		p.match(TokenSlash)
+	case TokenSlashSlash:
+		step, err := p.descendantOrSelf()
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	}
+	more, err := p.steps()
+	if err != nil {
+		return nil, err
+	}
+	steps = append(steps, more...)
+	return &LocationPath{false, steps, p.span(begin)}, nil
+}
+
+// descendantOrSelf matches "//" and returns the step it abbreviates.
+func (p *parser) descendantOrSelf() (*Step, error) {
+	if err := p.countStep(); err != nil {
+		return nil, err
 	}
-	steps = append(steps, p.steps()...)
-	return &LocationPath{false, steps}
+	begin := p.begin()
+	p.match(TokenSlashSlash)
+	span := p.span(begin)
+	return &Step{DescendantOrSelf, &NodeTypeTest{Node, span}, nil, span}, nil
 }
 
-func (p *parser) steps() []*Step {
+// countStep counts the step at current token. It returns error of code
+// LimitExceeded, if the number of steps exceeds ParseOptions.MaxSteps.
+func (p *parser) countStep() error {
+	if p.nsteps++; p.options.MaxSteps > 0 && p.nsteps > p.options.MaxSteps {
+		return p.error(LimitExceeded, "number of steps exceeds limit %d", p.options.MaxSteps)
+	}
+	return nil
+}
+
+func (p *parser) steps() ([]*Step, error) {
 	var steps []*Step
 	switch p.token(0).kind {
-	case dot, dotDot, at, identifier, star:
-		steps = append(steps, p.step())
-	case eof:
-		return steps
+	case TokenDot, TokenDotDot, TokenAt, TokenIdentifier, TokenStar:
+		step, err := p.step()
+		if err != nil {
+			return nil, err
+		}
+		steps = append(steps, step)
+	case TokenEOF:
+		// XPath 1.0 allows '/' at the end of filter expression, as in $a/
+		if p.options.Version < XPath20 {
+			return steps, nil
+		}
+		return nil, p.expectedTokens(TokenDot, TokenDotDot, TokenAt, TokenIdentifier, TokenStar)
 	default:
-		panic(p.expectedTokens(dot, dotDot, at, identifier, star))
+		return nil, p.expectedTokens(TokenDot, TokenDotDot, TokenAt, TokenIdentifier, TokenStar)
 	}
 	for {
+		if p.isFilterStep() {
+			return steps, nil
+		}
 		switch p.token(0).kind {
-		case slash:
-			p.match(slash)
-		case slashSlash:
-			p.match(slashSlash)
-			steps = append(steps, &Step{DescendantOrSelf, Node, nil})
+		case TokenSlash:
+			p.match(TokenSlash)
+		case TokenSlashSlash:
+			step, err := p.descendantOrSelf()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
 		default:
-			return steps
+			return steps, nil
 		}
 		switch p.token(0).kind {
-		case dot, dotDot, at, identifier, star:
-			steps = append(steps, p.step())
+		case TokenDot, TokenDotDot, TokenAt, TokenIdentifier, TokenStar:
+			step, err := p.step()
+			if err != nil {
+				return nil, err
+			}
+			steps = append(steps, step)
 		default:
-			panic(p.expectedTokens(dot, dotDot, at, identifier, star))
+			return nil, p.expectedTokens(TokenDot, TokenDotDot, TokenAt, TokenIdentifier, TokenStar)
 		}
 	}
 }
 
-func (p *parser) step() *Step {
+func (p *parser) step() (*Step, error) {
+	if err := p.countStep(); err != nil {
+		return nil, err
+	}
+	begin := p.begin()
 	var axis Axis
 	var nodeTest NodeTest
+	var err error
 	switch p.token(0).kind {
-	case dot:
-		p.match(dot)
-		axis, nodeTest = Self, Node
-	case dotDot:
-		p.match(dotDot)
-		axis, nodeTest = Parent, Node
+	case TokenDot:
+		p.match(TokenDot)
+		axis, nodeTest = Self, &NodeTypeTest{Node, p.span(begin)}
+	case TokenDotDot:
+		p.match(TokenDotDot)
+		axis, nodeTest = Parent, &NodeTypeTest{Node, p.span(begin)}
 	default:
 		switch p.token(0).kind {
-		case at:
-			p.match(at)
+		case TokenAt:
+			p.match(TokenAt)
 			axis = Attribute
-		case identifier:
-			if p.token(1).kind == colonColon {
-				axis = p.axisSpecifier()
+		case TokenIdentifier:
+			if p.token(1).kind == TokenColonColon {
+				if axis, err = p.axisSpecifier(); err != nil {
+					return nil, err
+				}
 			} else {
 				axis = Child
 			}
-		case star:
+		case TokenStar:
 			axis = Child
 		}
-		nodeTest = p.nodeTest(axis)
+		if nodeTest, err = p.nodeTest(axis); err != nil {
+			return nil, err
+		}
+	}
+	predicates, err := p.predicates()
+	if err != nil {
+		return nil, err
 	}
-	return &Step{axis, nodeTest, p.predicates()}
+	return &Step{axis, nodeTest, predicates, p.span(begin)}, nil
 }
 
-func (p *parser) nodeTest(axis Axis) NodeTest {
+func (p *parser) nodeTest(axis Axis) (NodeTest, error) {
 	switch p.token(0).kind {
-	case identifier:
-		if p.token(1).kind == lparen {
-			return p.nodeTypeTest(axis)
+	case TokenIdentifier:
+		if p.token(1).kind == TokenLParen {
+			return p.nodeTypeTest()
 		}
 		return p.nameTest(axis)
-	case star:
+	case TokenStar:
 		return p.nameTest(axis)
 	}
-	panic(p.expectedTokens(identifier, star))
+	return nil, p.expectedTokens(TokenIdentifier, TokenStar)
 }
 
-func (p *parser) nodeTypeTest(axis Axis) NodeTest {
-	ntype := p.match(identifier).text()
-	p.match(lparen)
-	var nodeTest NodeTest
+func (p *parser) nodeTypeTest() (NodeTest, error) {
+	if !p.isNodeTypeName(p.token(0)) {
+		name := p.token(0).text()
+		return nil, suggest(p.error(InvalidNodeType, "invalid nodeType %q", name), name, p.nodeTypeNames())
+	}
+	if contains(testKindNames, p.token(0).text()) {
+		kindTest, err := p.kindTest()
+		if err != nil {
+			return nil, err
+		}
+		return kindTest, nil
+	}
+	begin := p.begin()
+	ntype := p.match(TokenIdentifier).text()
+	p.match(TokenLParen)
+	var target string
+	var nodeType NodeType
 	switch ntype {
 	case "processing-instruction":
-		piName := ""
-		if p.token(0).kind == literal {
-			piName = p.match(literal).text()
+		switch p.token(0).kind {
+		case TokenLiteral:
+			target = p.match(TokenLiteral).value()
+		case TokenIdentifier:
+			if p.options.Version >= XPath20 && !isBracedURI(p.token(0).text()) {
+				target = p.match(TokenIdentifier).text()
+			}
 		}
-		nodeTest = PITest(piName)
 	case "node":
-		nodeTest = Node
+		nodeType = Node
 	case "text":
-		nodeTest = Text
-	case "comment":
-		nodeTest = Comment
+		nodeType = Text
 	default:
-		panic(p.error("invalid nodeType %q", ntype))
+		nodeType = Comment
+	}
+	if _, err := p.expect(TokenRParen); err != nil {
+		return nil, err
 	}
-	p.match(rparen)
-	return nodeTest
+	if ntype == "processing-instruction" {
+		return &PITest{target, p.span(begin)}, nil
+	}
+	return &NodeTypeTest{nodeType, p.span(begin)}, nil
 }
 
-func (p *parser) nameTest(axis Axis) NodeTest {
+// kindTest parses the kind tests of XPath 2.0, that XPath 1.0 lacks.
+// The current token is the name of the test, followed by '('.
+func (p *parser) kindTest() (*KindTest, error) {
+	begin := p.begin()
+	name := p.match(TokenIdentifier).text()
+	p.match(TokenLParen)
+	kindTest := new(KindTest)
+	for i, n := range testKindNames {
+		if n == name {
+			kindTest.Test = TestKind(i)
+		}
+	}
+	var err error
+	switch kindTest.Test {
+	case DocumentTest:
+		if t := p.token(0); t.kind == TokenIdentifier {
+			if (t.text() == "element" || t.text() == "schema-element") && p.token(1).kind == TokenLParen {
+				kindTest.Element, err = p.kindTest()
+			} else {
+				err = p.error(InvalidNodeType, "element or schema-element test expected")
+			}
+		}
+	case ElementTest, AttributeTest:
+		if p.token(0).kind == TokenRParen {
+			break
+		}
+		if p.token(0).kind == TokenStar {
+			p.match(TokenStar)
+			kindTest.Local = "*"
+		} else if kindTest.Prefix, kindTest.Local, err = p.qname(); err != nil {
+			break
+		}
+		if p.token(0).kind != TokenComma {
+			break
+		}
+		p.match(TokenComma)
+		if kindTest.TypePrefix, kindTest.TypeLocal, err = p.qname(); err != nil {
+			break
+		}
+		if kindTest.Test == ElementTest && p.token(0).kind == TokenQuestion {
+			p.match(TokenQuestion)
+			kindTest.Nillable = true
+		}
+	case NamespaceNodeTest:
+		// no arguments
+	default:
+		kindTest.Prefix, kindTest.Local, err = p.qname()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := p.expect(TokenRParen); err != nil {
+		return nil, err
+	}
+	kindTest.Span = p.span(begin)
+	return kindTest, nil
+}
+
+func (p *parser) nameTest(axis Axis) (NodeTest, error) {
+	begin := p.begin()
 	var prefix string
-	if p.token(0).kind == identifier && p.token(1).kind == colon {
-		prefix = p.match(identifier).text()
-		p.match(colon)
+	if p.isPrefixed(0) {
+		prefix = p.match(TokenIdentifier).text()
+		p.match(TokenColon)
 	}
 	var local string
 	switch p.token(0).kind {
-	case identifier:
-		local = p.match(identifier).text()
-	case star:
-		p.match(star)
+	case TokenIdentifier:
+		var err error
+		if prefix, local, err = p.localName(prefix, p.match(TokenIdentifier)); err != nil {
+			return nil, err
+		}
+	case TokenStar:
+		star := p.match(TokenStar)
 		local = "*"
+		if p.isStarPrefix(star) {
+			// wildcard *:local of XPath 2.0
+			p.match(TokenColon)
+			t := p.match(TokenIdentifier)
+			if isBracedURI(t.text()) {
+				return nil, p.errorAt(t, InvalidName, "invalid local name %s", t.text())
+			}
+			prefix, local = "*", t.text()
+		}
 	default:
 		// let us assume localName as empty-string and continue
 	}
-	return &NameTest{prefix, local}
+	return &NameTest{prefix, local, p.span(begin)}, nil
 }
 
-func (p *parser) axisSpecifier() Axis {
+func (p *parser) axisSpecifier() (Axis, error) {
 	name := p.token(0).text()
 	axis, ok := name2Axis[name]
 	if !ok {
-		panic(p.error("invalid axis %s", name))
+		return 0, suggest(p.error(InvalidAxis, "invalid axis %s", name), name, axisNames)
 	}
-	p.match(identifier)
-	p.match(colonColon)
-	return axis
+	p.match(TokenIdentifier)
+	p.match(TokenColonColon)
+	return axis, nil
+}
+
+// nodeTypeTestNames are the names used in node type tests.
+var nodeTypeTestNames = []string{"comment", "text", "processing-instruction", "node"}
+
+// reservedFunctionNames cannot be used as names of functions
+// without prefix, in XPath 2.0 and later.
+var reservedFunctionNames = []string{
+	"attribute", "comment", "document-node", "element", "empty-sequence", "if", "item",
+	"node", "processing-instruction", "schema-attribute", "schema-element", "text", "typeswitch",
 }
 
-func isNodeTypeName(t token) bool {
-	switch t.text() {
-	case "node", "comment", "text", "processing-instruction":
+// reservedFunctionNames30 are reserved in addition, in XPath 3.0 and later.
+var reservedFunctionNames30 = []string{"function", "namespace-node", "switch"}
+
+// reservedFunctionNames31 are reserved in addition, in XPath 3.1.
+var reservedFunctionNames31 = []string{"array", "map"}
+
+// isReservedFunctionName tells whether name cannot be used as name of
+// function without prefix.
+func (p *parser) isReservedFunctionName(name string) bool {
+	switch {
+	case p.options.Version >= XPath31 && contains(reservedFunctionNames31, name):
 		return true
-	default:
-		return false
+	case p.options.Version >= XPath30 && contains(reservedFunctionNames30, name):
+		return true
+	case p.options.Version >= XPath20:
+		return contains(reservedFunctionNames, name)
+	}
+	return false
+}
+
+// isNodeTypeName tells whether t, followed by '(', is a node test rather
+// than a function call.
+func (p *parser) isNodeTypeName(t token) bool {
+	return contains(p.nodeTypeNames(), t.text())
+}
+
+// nodeTypeNames returns the names used in node tests, that are followed
+// by '('. XPath 2.0 adds the names of KindTest, and XPath 3.0 adds
+// namespace-node.
+func (p *parser) nodeTypeNames() []string {
+	switch {
+	case p.options.Version >= XPath30:
+		return append(nodeTypeTestNames[:len(nodeTypeTestNames):len(nodeTypeTestNames)], testKindNames...)
+	case p.options.Version >= XPath20:
+		return append(nodeTypeTestNames[:len(nodeTypeTestNames):len(nodeTypeTestNames)], testKindNames[:NamespaceNodeTest]...)
 	}
+	return nodeTypeTestNames
 }
@@ -0,0 +1,621 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import "fmt"
+
+// An ApplyFunc is invoked by Apply for each node n before and/or after the node's children, using a Cursor describing
+// the current node and providing operations on it.
+//
+// The return value of ApplyFunc controls the syntax tree traversal.
+// See Apply for details.
+type ApplyFunc func(*Cursor) bool
+
+// Apply traverses a copy of the expression tree recursively, starting with
+// root, and calling pre and post for each node as described below.
+// Apply returns the rewritten copy. The tree passed in is never modified,
+// so it is safe to rewrite trees that are shared or cached.
+//
+// If pre is not nil, it is called for each node before the node's children
+// are traversed (pre-order). If pre returns false, no children are traversed,
+// and post is not called for that node.
+//
+// If post is not nil, and a prior call of pre didn't return false, post is
+// called for each node after its children are traversed (post-order). If post
+// returns false, traversal is terminated and Apply returns immediately.
+//
+// Only fields that refer to TreeNodes are traversed; they are traversed in
+// document order. The children of a *Step are its NodeTest followed by its
+// Predicates. The children of type expressions, such as *InstanceOfExpr,
+// are its Expr followed by its Type. The children of an *InlineFunctionExpr
+// are its Params, Type and Body. The children of a *MapConstructor are its
+// Entries, whose children are Key and Value. The children of a
+// *FunctionTest are its ParamTypes and ReturnType, those of a *MapTest
+// are its KeyType and ValueType, and the child of an *ArrayTest is its
+// MemberType. Nodes added by Replace,
+// InsertBefore and InsertAfter are used as is and are not copied.
+func Apply(root TreeNode, pre, post ApplyFunc) (result TreeNode) {
+	parent := &rootNode{cloneNode(root)}
+	defer func() {
+		if r := recover(); r != nil && r != abort {
+			panic(r)
+		}
+		result = parent.root
+	}()
+	a := &application{pre: pre, post: post}
+	a.apply(parent, "Node", nil, parent.root)
+	return
+}
+
+var abort = new(int) // singleton, to signal termination of Apply
+
+// rootNode is the parent of root node passed to Apply.
+type rootNode struct {
+	root TreeNode
+}
+
+func (*rootNode) String() string { return "" }
+func (*rootNode) node()          {}
+
+// A Cursor describes a node encountered during Apply.
+// Information about the node and its parent is available
+// from the Node, Parent, Name, and Index methods.
+//
+// If p is a variable of type and value of the current parent node
+// c.Parent(), and f is the field identifier with name c.Name(),
+// the following invariants hold:
+//
+//	p.f            == c.Node()  if c.Index() <  0
+//	p.f[c.Index()] == c.Node()  if c.Index() >= 0
+//
+// The methods Replace, Delete, InsertBefore, and InsertAfter
+// can be used to change the expression tree.
+type Cursor struct {
+	parent TreeNode
+	name   string
+	iter   *iterator // valid if non-nil
+	node   TreeNode
+}
+
+// Node returns the current Node.
+func (c *Cursor) Node() TreeNode { return c.node }
+
+// Parent returns the parent of the current Node.
+// It returns nil for the root node.
+func (c *Cursor) Parent() TreeNode {
+	if _, ok := c.parent.(*rootNode); ok {
+		return nil
+	}
+	return c.parent
+}
+
+// Name returns the name of the parent Node field that contains the current Node.
+// If the parent is a *LocationPath and the current Node is a *Step, for instance,
+// Name returns "Steps".
+func (c *Cursor) Name() string { return c.name }
+
+// Index reports the index >= 0 of the current Node in the slice of Nodes that
+// contains it, or a value < 0 if the current Node is not part of a slice.
+// The index of the current node changes if InsertBefore is called while
+// processing the current node.
+func (c *Cursor) Index() int {
+	if c.iter != nil {
+		return c.iter.index
+	}
+	return -1
+}
+
+// Replace replaces the current Node with n.
+// The replacement node is not walked by Apply.
+//
+// Replace panics if n is not assignable to the field holding the current
+// Node, for example if a *Step is replaced with an Expr.
+func (c *Cursor) Replace(n TreeNode) {
+	if c.iter != nil {
+		switch list := c.list().(type) {
+		case *[]Expr:
+			(*list)[c.iter.index] = toExpr(n)
+		case *[]*Step:
+			(*list)[c.iter.index] = toStep(n)
+		case *[]*Param:
+			(*list)[c.iter.index] = toParam(n)
+		case *[]*MapEntry:
+			(*list)[c.iter.index] = toMapEntry(n)
+		case *[]*SequenceType:
+			(*list)[c.iter.index] = toSequenceType(n)
+		}
+		c.node = n
+		return
+	}
+	switch p := c.parent.(type) {
+	case *rootNode:
+		p.root = n
+	case *FilterExpr:
+		p.Expr = toExpr(n)
+	case *PathExpr:
+		if c.name == "Filter" {
+			p.Filter = toExpr(n)
+		} else {
+			lp, ok := n.(*LocationPath)
+			if !ok {
+				panic(fmt.Sprintf("xpathparser: cannot replace LocationPath with %T", n))
+			}
+			p.LocationPath = lp
+		}
+	case *SlashExpr:
+		if c.name == "LHS" {
+			p.LHS = toExpr(n)
+		} else {
+			p.RHS = toExpr(n)
+		}
+	case *BinaryExpr:
+		if c.name == "LHS" {
+			p.LHS = toExpr(n)
+		} else {
+			p.RHS = toExpr(n)
+		}
+	case *NegateExpr:
+		p.Expr = toExpr(n)
+	case *UnaryPlusExpr:
+		p.Expr = toExpr(n)
+	case *ForExpr:
+		if c.name == "In" {
+			p.In = toExpr(n)
+		} else {
+			p.Return = toExpr(n)
+		}
+	case *QuantifiedExpr:
+		if c.name == "In" {
+			p.In = toExpr(n)
+		} else {
+			p.Satisfies = toExpr(n)
+		}
+	case *IfExpr:
+		switch c.name {
+		case "Cond":
+			p.Cond = toExpr(n)
+		case "Then":
+			p.Then = toExpr(n)
+		default:
+			p.Else = toExpr(n)
+		}
+	case *InstanceOfExpr:
+		if c.name == "Expr" {
+			p.Expr = toExpr(n)
+		} else {
+			p.Type = toSequenceType(n)
+		}
+	case *TreatExpr:
+		if c.name == "Expr" {
+			p.Expr = toExpr(n)
+		} else {
+			p.Type = toSequenceType(n)
+		}
+	case *CastableExpr:
+		if c.name == "Expr" {
+			p.Expr = toExpr(n)
+		} else {
+			p.Type = toSequenceType(n)
+		}
+	case *CastExpr:
+		if c.name == "Expr" {
+			p.Expr = toExpr(n)
+		} else {
+			p.Type = toSequenceType(n)
+		}
+	case *LetExpr:
+		if c.name == "Expr" {
+			p.Expr = toExpr(n)
+		} else {
+			p.Return = toExpr(n)
+		}
+	case *InlineFunctionExpr:
+		if c.name == "Type" {
+			p.Type = toSequenceType(n)
+		} else {
+			p.Body = toExpr(n)
+		}
+	case *Param:
+		p.Type = toSequenceType(n)
+	case *DynamicCallExpr:
+		p.Func = toExpr(n)
+	case *SimpleMapExpr:
+		if c.name == "LHS" {
+			p.LHS = toExpr(n)
+		} else {
+			p.RHS = toExpr(n)
+		}
+	case *MapEntry:
+		if c.name == "Key" {
+			p.Key = toExpr(n)
+		} else {
+			p.Value = toExpr(n)
+		}
+	case *LookupExpr:
+		if c.name == "Expr" {
+			p.Expr = toExpr(n)
+		} else {
+			p.Key = toExpr(n)
+		}
+	case *ArrowExpr:
+		if c.name == "Expr" {
+			p.Expr = toExpr(n)
+		} else {
+			p.Call = toExpr(n)
+		}
+	case *SequenceType:
+		it, ok := n.(ItemType)
+		if !ok {
+			panic(fmt.Sprintf("xpathparser: cannot replace ItemType with %T", n))
+		}
+		p.ItemType = it
+	case *KindTest:
+		kt, ok := n.(*KindTest)
+		if !ok {
+			panic(fmt.Sprintf("xpathparser: cannot replace Element with %T", n))
+		}
+		p.Element = kt
+	case *FunctionTest:
+		p.ReturnType = toSequenceType(n)
+	case *MapTest:
+		if c.name == "KeyType" {
+			at, ok := n.(*AtomicType)
+			if !ok {
+				panic(fmt.Sprintf("xpathparser: cannot replace KeyType with %T", n))
+			}
+			p.KeyType = at
+		} else {
+			p.ValueType = toSequenceType(n)
+		}
+	case *ArrayTest:
+		p.MemberType = toSequenceType(n)
+	case *Step:
+		nt, ok := n.(NodeTest)
+		if !ok {
+			panic(fmt.Sprintf("xpathparser: cannot replace NodeTest with %T", n))
+		}
+		p.NodeTest = nt
+	default:
+		panic(fmt.Sprintf("xpathparser: cannot replace field %s of %T", c.name, c.parent))
+	}
+	c.node = n
+}
+
+// Delete deletes the current Node from its containing slice.
+// If the current Node is not part of a slice, Delete panics.
+func (c *Cursor) Delete() {
+	i := c.index()
+	switch list := c.list().(type) {
+	case *[]Expr:
+		*list = append((*list)[:i], (*list)[i+1:]...)
+	case *[]*Step:
+		*list = append((*list)[:i], (*list)[i+1:]...)
+	case *[]*Param:
+		*list = append((*list)[:i], (*list)[i+1:]...)
+	case *[]*MapEntry:
+		*list = append((*list)[:i], (*list)[i+1:]...)
+	case *[]*SequenceType:
+		*list = append((*list)[:i], (*list)[i+1:]...)
+	}
+	c.iter.step--
+}
+
+// InsertAfter inserts n after the current Node in its containing slice.
+// If the current Node is not part of a slice, InsertAfter panics.
+// Apply does not walk n.
+func (c *Cursor) InsertAfter(n TreeNode) {
+	i := c.index()
+	c.insert(i+1, n)
+	c.iter.step++
+}
+
+// InsertBefore inserts n before the current Node in its containing slice.
+// If the current Node is not part of a slice, InsertBefore panics.
+// Apply will not walk n.
+func (c *Cursor) InsertBefore(n TreeNode) {
+	i := c.index()
+	c.insert(i, n)
+	c.iter.index++
+}
+
+func (c *Cursor) index() int {
+	if c.iter == nil {
+		panic(fmt.Sprintf("xpathparser: field %s of %T is not a slice", c.name, c.parent))
+	}
+	return c.iter.index
+}
+
+func (c *Cursor) insert(i int, n TreeNode) {
+	switch list := c.list().(type) {
+	case *[]Expr:
+		*list = append(*list, nil)
+		copy((*list)[i+1:], (*list)[i:])
+		(*list)[i] = toExpr(n)
+	case *[]*Step:
+		*list = append(*list, nil)
+		copy((*list)[i+1:], (*list)[i:])
+		(*list)[i] = toStep(n)
+	case *[]*Param:
+		*list = append(*list, nil)
+		copy((*list)[i+1:], (*list)[i:])
+		(*list)[i] = toParam(n)
+	case *[]*MapEntry:
+		*list = append(*list, nil)
+		copy((*list)[i+1:], (*list)[i:])
+		(*list)[i] = toMapEntry(n)
+	case *[]*SequenceType:
+		*list = append(*list, nil)
+		copy((*list)[i+1:], (*list)[i:])
+		(*list)[i] = toSequenceType(n)
+	}
+}
+
+// list returns pointer to the slice field of parent that contains
+// the current node. It returns either *[]Expr, *[]*Step, *[]*Param,
+// *[]*MapEntry or *[]*SequenceType.
+func (c *Cursor) list() interface{} {
+	return listField(c.parent, c.name)
+}
+
+func listField(parent TreeNode, name string) interface{} {
+	switch p := parent.(type) {
+	case *LocationPath:
+		return &p.Steps
+	case *Step:
+		return &p.Predicates
+	case *FilterExpr:
+		return &p.Predicates
+	case *FuncCall:
+		return &p.Args
+	case *SequenceExpr:
+		return &p.Items
+	case *InlineFunctionExpr:
+		return &p.Params
+	case *DynamicCallExpr:
+		return &p.Args
+	case *MapConstructor:
+		return &p.Entries
+	case *ArrayConstructor:
+		return &p.Members
+	case *FunctionTest:
+		return &p.ParamTypes
+	}
+	panic(fmt.Sprintf("xpathparser: field %s of %T is not a slice", name, parent))
+}
+
+func toExpr(n TreeNode) Expr {
+	expr, ok := n.(Expr)
+	if !ok {
+		panic(fmt.Sprintf("xpathparser: %T is not an Expr", n))
+	}
+	return expr
+}
+
+func toSequenceType(n TreeNode) *SequenceType {
+	t, ok := n.(*SequenceType)
+	if !ok {
+		panic(fmt.Sprintf("xpathparser: %T is not a *SequenceType", n))
+	}
+	return t
+}
+
+func toParam(n TreeNode) *Param {
+	param, ok := n.(*Param)
+	if !ok {
+		panic(fmt.Sprintf("xpathparser: %T is not a *Param", n))
+	}
+	return param
+}
+
+func toMapEntry(n TreeNode) *MapEntry {
+	entry, ok := n.(*MapEntry)
+	if !ok {
+		panic(fmt.Sprintf("xpathparser: %T is not a *MapEntry", n))
+	}
+	return entry
+}
+
+func toStep(n TreeNode) *Step {
+	step, ok := n.(*Step)
+	if !ok {
+		panic(fmt.Sprintf("xpathparser: %T is not a *Step", n))
+	}
+	return step
+}
+
+type iterator struct {
+	index, step int
+}
+
+type application struct {
+	pre, post ApplyFunc
+	cursor    Cursor
+	iter      iterator
+}
+
+func (a *application) apply(parent TreeNode, name string, iter *iterator, n TreeNode) {
+	saved := a.cursor
+	a.cursor.parent = parent
+	a.cursor.name = name
+	a.cursor.iter = iter
+	a.cursor.node = n
+
+	if a.pre != nil && !a.pre(&a.cursor) {
+		a.cursor = saved
+		return
+	}
+
+	// walk children
+	// (the order of the cases matches the order of the corresponding node types in Walk)
+	switch n := a.cursor.node.(type) {
+	case nil:
+		// nothing to do
+	case *LocationPath:
+		a.applyList(n, "Steps")
+	case *Step:
+		a.apply(n, "NodeTest", nil, n.NodeTest)
+		a.applyList(n, "Predicates")
+	case *FilterExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+		a.applyList(n, "Predicates")
+	case *PathExpr:
+		a.apply(n, "Filter", nil, n.Filter)
+		if n.LocationPath != nil {
+			a.apply(n, "LocationPath", nil, n.LocationPath)
+		}
+	case *SlashExpr:
+		a.apply(n, "LHS", nil, n.LHS)
+		a.apply(n, "RHS", nil, n.RHS)
+	case *BinaryExpr:
+		a.apply(n, "LHS", nil, n.LHS)
+		a.apply(n, "RHS", nil, n.RHS)
+	case *NegateExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+	case *UnaryPlusExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+	case *FuncCall:
+		a.applyList(n, "Args")
+	case *ForExpr:
+		a.apply(n, "In", nil, n.In)
+		a.apply(n, "Return", nil, n.Return)
+	case *QuantifiedExpr:
+		a.apply(n, "In", nil, n.In)
+		a.apply(n, "Satisfies", nil, n.Satisfies)
+	case *IfExpr:
+		a.apply(n, "Cond", nil, n.Cond)
+		a.apply(n, "Then", nil, n.Then)
+		a.apply(n, "Else", nil, n.Else)
+	case *SequenceExpr:
+		a.applyList(n, "Items")
+	case *InstanceOfExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+		a.apply(n, "Type", nil, n.Type)
+	case *TreatExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+		a.apply(n, "Type", nil, n.Type)
+	case *CastableExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+		a.apply(n, "Type", nil, n.Type)
+	case *CastExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+		a.apply(n, "Type", nil, n.Type)
+	case *LetExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+		a.apply(n, "Return", nil, n.Return)
+	case *InlineFunctionExpr:
+		a.applyList(n, "Params")
+		if n.Type != nil {
+			a.apply(n, "Type", nil, n.Type)
+		}
+		a.apply(n, "Body", nil, n.Body)
+	case *Param:
+		if n.Type != nil {
+			a.apply(n, "Type", nil, n.Type)
+		}
+	case *DynamicCallExpr:
+		a.apply(n, "Func", nil, n.Func)
+		a.applyList(n, "Args")
+	case *SimpleMapExpr:
+		a.apply(n, "LHS", nil, n.LHS)
+		a.apply(n, "RHS", nil, n.RHS)
+	case *MapConstructor:
+		a.applyList(n, "Entries")
+	case *MapEntry:
+		a.apply(n, "Key", nil, n.Key)
+		a.apply(n, "Value", nil, n.Value)
+	case *ArrayConstructor:
+		a.applyList(n, "Members")
+	case *LookupExpr:
+		if n.Expr != nil {
+			a.apply(n, "Expr", nil, n.Expr)
+		}
+		if n.Key != nil {
+			a.apply(n, "Key", nil, n.Key)
+		}
+	case *ArrowExpr:
+		a.apply(n, "Expr", nil, n.Expr)
+		a.apply(n, "Call", nil, n.Call)
+	case *SequenceType:
+		if n.ItemType != nil {
+			a.apply(n, "ItemType", nil, n.ItemType)
+		}
+	case *KindTest:
+		if n.Element != nil {
+			a.apply(n, "Element", nil, n.Element)
+		}
+	case *FunctionTest:
+		a.applyList(n, "ParamTypes")
+		if n.ReturnType != nil {
+			a.apply(n, "ReturnType", nil, n.ReturnType)
+		}
+	case *MapTest:
+		if n.KeyType != nil {
+			a.apply(n, "KeyType", nil, n.KeyType)
+		}
+		if n.ValueType != nil {
+			a.apply(n, "ValueType", nil, n.ValueType)
+		}
+	case *ArrayTest:
+		if n.MemberType != nil {
+			a.apply(n, "MemberType", nil, n.MemberType)
+		}
+	case *VarRef, *Number, *String, *BadExpr, *NameTest, *NodeTypeTest, *PITest, *AtomicType, *AnyItem, *NamedFunctionRef:
+		// nothing to do
+	default:
+		panic(fmt.Sprintf("xpathparser.Apply: unexpected node type %T", n))
+	}
+
+	if a.post != nil && !a.post(&a.cursor) {
+		panic(abort)
+	}
+
+	a.cursor = saved
+}
+
+func (a *application) applyList(parent TreeNode, name string) {
+	// avoid heap-allocating a new iterator for each applyList call; reuse a.iter instead
+	saved := a.iter
+	a.iter.index = 0
+	for {
+		// must reload parent.name each time, since cursor modifications might change it
+		var n TreeNode
+		switch list := listField(parent, name).(type) {
+		case *[]Expr:
+			if a.iter.index >= len(*list) {
+				a.iter = saved
+				return
+			}
+			n = (*list)[a.iter.index]
+		case *[]*Step:
+			if a.iter.index >= len(*list) {
+				a.iter = saved
+				return
+			}
+			n = (*list)[a.iter.index]
+		case *[]*Param:
+			if a.iter.index >= len(*list) {
+				a.iter = saved
+				return
+			}
+			n = (*list)[a.iter.index]
+		case *[]*MapEntry:
+			if a.iter.index >= len(*list) {
+				a.iter = saved
+				return
+			}
+			n = (*list)[a.iter.index]
+		case *[]*SequenceType:
+			if a.iter.index >= len(*list) {
+				a.iter = saved
+				return
+			}
+			n = (*list)[a.iter.index]
+		}
+
+		a.iter.step = 1
+		a.apply(parent, name, &a.iter, n)
+		a.iter.index += a.iter.step
+	}
+}
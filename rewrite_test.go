@@ -0,0 +1,146 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		version Version
+		xpath   string
+		pre     ApplyFunc
+		want    string
+	}{
+		{
+			xpath: `a/@b[c]`,
+			pre: func(c *Cursor) bool {
+				if nt, ok := c.Node().(*NameTest); ok {
+					if step := c.Parent().(*Step); step.Axis != Attribute {
+						c.Replace(&NameTest{Prefix: "ns", Local: nt.Local})
+					}
+				}
+				return true
+			},
+			want: `child::ns:a/attribute::b[child::ns:c]`,
+		},
+		{
+			xpath: `$x + f($y, $x)`,
+			pre: func(c *Cursor) bool {
+				if vr, ok := c.Node().(*VarRef); ok && vr.Local == "x" {
+					c.Replace(&String{Value: "value"})
+				}
+				return true
+			},
+			want: `("value" + f($y, "value"))`,
+		},
+		{
+			xpath: `a[1][3]`,
+			pre: func(c *Cursor) bool {
+				if n, ok := c.Node().(*Number); ok && n.Value == 3 {
+					c.InsertBefore(&Number{Value: 2})
+					c.InsertAfter(&Number{Value: 4})
+				}
+				return true
+			},
+			want: `child::a[1][2][3][4]`,
+		},
+		{
+			xpath: `concat(1, 2, 3, 2)`,
+			pre: func(c *Cursor) bool {
+				if n, ok := c.Node().(*Number); ok && n.Value == 2 {
+					c.Delete()
+				}
+				return true
+			},
+			want: `concat(1, 3)`,
+		},
+		{
+			xpath: `a/b/c`,
+			pre: func(c *Cursor) bool {
+				if step, ok := c.Node().(*Step); ok && step.NodeTest.String() == "b" {
+					c.Delete()
+					return false
+				}
+				return true
+			},
+			want: `child::a/child::c`,
+		},
+		{
+			xpath: `a and b`,
+			pre: func(c *Cursor) bool {
+				if c.Parent() == nil {
+					c.Replace(&NegateExpr{Expr: c.Node().(Expr)})
+					return false
+				}
+				return true
+			},
+			want: `-(child::a and child::b)`,
+		},
+		{
+			version: XPath30,
+			xpath:   `$f instance of function(xs:int, node()) as item()`,
+			pre: func(c *Cursor) bool {
+				if t, ok := c.Node().(*SequenceType); ok && c.Name() == "ParamTypes" {
+					if _, ok := t.ItemType.(*AtomicType); ok {
+						c.Delete()
+					} else {
+						c.InsertAfter(&SequenceType{ItemType: &AnyItem{}, Occurrence: ZeroOrMore})
+					}
+				}
+				if _, ok := c.Node().(*AnyItem); ok {
+					c.Replace(&AtomicType{Prefix: "xs", Local: "string"})
+				}
+				return true
+			},
+			want: `($f instance of function(node(), item()*) as xs:string)`,
+		},
+		{
+			version: XPath31,
+			xpath:   `$m treat as map(xs:string, array(item()))`,
+			pre: func(c *Cursor) bool {
+				if c.Name() == "KeyType" {
+					c.Replace(&AtomicType{Prefix: "xs", Local: "integer"})
+				}
+				if _, ok := c.Node().(*AnyItem); ok {
+					c.Replace(&MapTest{})
+				}
+				return true
+			},
+			want: `($m treat as map(xs:integer, array(map(*))))`,
+		},
+	}
+	for _, test := range tests {
+		expr, err := (&ParseOptions{Version: test.version}).Parse(test.xpath)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", test.xpath, err)
+			continue
+		}
+		before := expr.String()
+		got := Apply(expr, test.pre, nil).String()
+		if got != test.want {
+			t.Errorf("FAIL: %s: got %s, want %s", test.xpath, got, test.want)
+		}
+		if after := expr.String(); after != before {
+			t.Errorf("FAIL: %s: original modified to %s", test.xpath, after)
+		}
+	}
+}
+
+func TestApplyStop(t *testing.T) {
+	var visited int
+	expr := MustParse(`f(1, 2, 3)`)
+	Apply(expr, nil, func(c *Cursor) bool {
+		visited++
+		n, ok := c.Node().(*Number)
+		return !ok || n.Value != 2
+	})
+	if visited != 2 {
+		t.Errorf("FAIL: got %d, want 2", visited)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+// Token is a lexical token of xpath expression, as returned by Scanner.
+type Token struct {
+	Kind  TokenKind
+	Text  string // source text, including quotes of literal
+	Begin int    // byte offset of the first character
+	End   int    // byte offset immediately after the last character
+	Err   *Error // reason of TokenIllegal token; nil for other kinds
+}
+
+// A ScanMode value is a set of flags (or 0). They control scanning.
+type ScanMode uint
+
+// Possible values for ScanMode.
+const (
+	// ScanWhitespace reports whitespace between tokens as TokenWhitespace
+	// tokens, and comments of XPath 2.0 as TokenComment tokens, so that
+	// the tokens cover the whole xpath expression. Otherwise they are
+	// skipped.
+	ScanWhitespace ScanMode = 1 << iota
+
+	// ScanLenient reports text that cannot be tokenized, such as unclosed
+	// literal or '!' not followed by '=', as TokenIllegal tokens and
+	// continues till the end of input, instead of returning error.
+	// This is useful to work on incomplete expressions being typed.
+	ScanLenient
+)
+
+// Scanner splits xpath expression into tokens, as done by Parse.
+//
+// Note that some tokens can only be told apart with the context of the
+// previous token. For example, '*' is TokenMultiply after an operand and
+// TokenStar otherwise, and a name after an operand must be an operator
+// name such as "and" or "div". Scanner takes care of this, just as Parse.
+type Scanner struct {
+	lexer lexer
+	mode  ScanMode
+	err   error
+}
+
+// NewScanner returns Scanner for given xpath expression, written in
+// given version of XPath grammar. The version decides the keywords,
+// operators and literals recognized, such as "to" or "1e3".
+func NewScanner(xpath string, version Version, mode ScanMode) *Scanner {
+	return &Scanner{lexer: lexer{xpath: xpath, version: version}, mode: mode}
+}
+
+// Scan returns the next token. At the end of input, it returns a token
+// of kind TokenEOF, every time it is called.
+//
+// If the input cannot be tokenized, Scan returns an error of type *Error,
+// and every call after that returns the same error. In ScanLenient mode,
+// it returns a TokenIllegal token instead, and never fails.
+func (s *Scanner) Scan() (Token, error) {
+	if s.err != nil {
+		return Token{}, s.err
+	}
+	if s.mode&ScanWhitespace != 0 {
+		if n := s.lexer.skipSpace(); n > 0 {
+			return s.token(TokenWhitespace, s.lexer.pos-n, s.lexer.pos), nil
+		}
+		if s.lexer.isComment() {
+			begin := s.lexer.pos
+			if s.lexer.comment() == nil {
+				return s.token(TokenComment, begin, s.lexer.pos), nil
+			}
+			s.lexer.pos = begin // unclosed comment is reported by next
+		}
+	}
+	t, err := s.lexer.next()
+	if err != nil {
+		t = s.lexer.illegal(err.(*Error))
+		if s.mode&ScanLenient == 0 {
+			s.err = err
+			return Token{}, err
+		}
+		token := s.token(t.kind, t.begin, t.end)
+		token.Err = err.(*Error)
+		return token, nil
+	}
+	if t.kind == TokenLiteral {
+		return s.token(t.kind, t.begin-1, t.end+1), nil // quotes
+	}
+	return s.token(t.kind, t.begin, t.end), nil
+}
+
+func (s *Scanner) token(kind TokenKind, begin, end int) Token {
+	return Token{kind, s.lexer.xpath[begin:end], begin, end, nil}
+}
@@ -0,0 +1,204 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+func scanAll(xpath string, version Version, mode ScanMode) ([]Token, error) {
+	s := NewScanner(xpath, version, mode)
+	var tokens []Token
+	for {
+		t, err := s.Scan()
+		if err != nil {
+			return tokens, err
+		}
+		tokens = append(tokens, t)
+		if t.Kind == TokenEOF {
+			return tokens, nil
+		}
+	}
+}
+
+func TestScanner(t *testing.T) {
+	tests := []struct {
+		xpath string
+		want  string
+	}{
+		{`*`, `'*' *`},
+		{`* * *`, `'*' * | '*' * | '*' *`},
+		{`a div div`, `<identifier> a | "div" div | <identifier> div`},
+		{`//a[@b = "c"]`, `"//" // | <identifier> a | '[' [ | '@' @ | <identifier> b | '=' = | <literal> "c" | ']' ]`},
+		{`$x:y != -1.5`, `'$' $ | <identifier> x | ':' : | <identifier> y | "!=" != | '-' - | <number> 1.5`},
+		{`child::node()`, `<identifier> child | "::" :: | <identifier> node | '(' ( | ')' )`},
+	}
+	for _, test := range tests {
+		tokens, err := scanAll(test.xpath, XPath10, 0)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", test.xpath, err)
+			continue
+		}
+		var got []string
+		for _, token := range tokens[:len(tokens)-1] {
+			if token.Text != test.xpath[token.Begin:token.End] {
+				t.Errorf("FAIL: %s: text %q does not match offsets %d-%d", test.xpath, token.Text, token.Begin, token.End)
+			}
+			got = append(got, fmt.Sprintf("%v %s", token.Kind, token.Text))
+		}
+		if strings.Join(got, " | ") != test.want {
+			t.Errorf("FAIL: %s: got %s, want %s", test.xpath, strings.Join(got, " | "), test.want)
+		}
+	}
+}
+
+func TestScannerWhitespace(t *testing.T) {
+	xpath := " a\n\t|  'b c' "
+	tokens, err := scanAll(xpath, XPath10, ScanWhitespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []TokenKind
+	var text string
+	for _, token := range tokens {
+		kinds = append(kinds, token.Kind)
+		text += token.Text
+	}
+	want := []TokenKind{TokenWhitespace, TokenIdentifier, TokenWhitespace, TokenPipe, TokenWhitespace, TokenLiteral, TokenWhitespace, TokenEOF}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("FAIL: got %v, want %v", kinds, want)
+	}
+	if text != xpath {
+		t.Errorf("FAIL: tokens cover %q, want %q", text, xpath)
+	}
+}
+
+func TestScannerError(t *testing.T) {
+	s := NewScanner("a =\n 'b", XPath10, 0)
+	for i := 0; i < 2; i++ {
+		s.Scan()
+	}
+	for i := 0; i < 2; i++ {
+		_, err := s.Scan()
+		e, ok := err.(*Error)
+		if !ok || e.Code != UnclosedLiteral || e.Line != 2 || e.Column != 4 {
+			t.Fatalf("FAIL: got %#v", err)
+		}
+	}
+
+	s = NewScanner("a", XPath10, 0)
+	for i := 0; i < 3; i++ {
+		if tok, err := s.Scan(); err != nil || (i > 0 && tok.Kind != TokenEOF) {
+			t.Fatalf("FAIL: got %v, %v", tok, err)
+		}
+	}
+}
+
+func TestScannerLenient(t *testing.T) {
+	tests := []struct {
+		xpath string
+		want  string
+	}{
+		{`a = 'abc`, `<identifier> a | '=' = | <illegal> 'abc`},
+		{`$x !`, `'$' $ | <identifier> x | <illegal> !`},
+		{`!!= 1`, `<illegal> ! | "!=" != | <number> 1`},
+		{`a !b`, `<identifier> a | <illegal> !b`},
+		{`a ; b`, `<identifier> a | <illegal> ; | <illegal> b`},
+		{`f(#)`, `<identifier> f | '(' ( | <illegal> # | ')' )`},
+	}
+	for _, test := range tests {
+		tokens, err := scanAll(test.xpath, XPath10, ScanLenient)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", test.xpath, err)
+			continue
+		}
+		var got []string
+		for _, token := range tokens[:len(tokens)-1] {
+			if (token.Kind == TokenIllegal) != (token.Err != nil) {
+				t.Errorf("FAIL: %s: token %v has error %v", test.xpath, token.Kind, token.Err)
+			}
+			got = append(got, fmt.Sprintf("%v %s", token.Kind, token.Text))
+		}
+		if strings.Join(got, " | ") != test.want {
+			t.Errorf("FAIL: %s: got %s, want %s", test.xpath, strings.Join(got, " | "), test.want)
+		}
+	}
+
+	xpath := ` 'a' ! "b`
+	tokens, err := scanAll(xpath, XPath10, ScanLenient|ScanWhitespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var text string
+	for _, token := range tokens {
+		text += token.Text
+	}
+	if text != xpath {
+		t.Errorf("FAIL: tokens cover %q, want %q", text, xpath)
+	}
+}
+
+func TestScannerVersion(t *testing.T) {
+	tests := []struct {
+		version Version
+		xpath   string
+		want    string
+	}{
+		{XPath10, `a to b`, `<identifier> a | <illegal> to | <illegal> b`},
+		{XPath20, `a to b`, `<identifier> a | "to" to | <identifier> b`},
+		{XPath10, `'it''s' 1e3`, `<literal> 'it' | <literal> 's' | <number> 1 | <illegal> e | <number> 3`},
+		{XPath20, `'it''s' 1e3`, `<literal> 'it''s' | <number> 1e3`},
+		{XPath10, `(: c :)`, `'(' ( | ':' : | <identifier> c | ':' : | ')' )`},
+		{XPath20, `(: c (: d :) :) a`, `<identifier> a`},
+		{XPath20, `$f := a || b`, `'$' $ | <identifier> f | ':' : | '=' = | <identifier> a | '|' | | '|' | | <identifier> b`},
+		{XPath30, `$f := a || b`, `'$' $ | <identifier> f | ":=" := | <identifier> a | "||" || | <identifier> b`},
+		{XPath30, `a => f()`, `<identifier> a | '=' = | '>' > | <identifier> f | '(' ( | ')' )`},
+		{XPath10, `a union b`, `<identifier> a | <illegal> union | <illegal> b`},
+		{XPath20, `a union b`, `<identifier> a | "union" union | <identifier> b`},
+		{XPath31, `a => f()`, `<identifier> a | "=>" => | <identifier> f | '(' ( | ')' )`},
+		{XPath20, `Q{u}a`, `<identifier> Q | <illegal> {u}a`},
+		{XPath30, `Q{u}a * Q{u}*`, `<identifier> Q{u}a | '*' * | <identifier> Q{u}*`},
+	}
+	for _, test := range tests {
+		tokens, err := scanAll(test.xpath, test.version, ScanLenient)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", test.xpath, err)
+			continue
+		}
+		var got []string
+		for _, token := range tokens[:len(tokens)-1] {
+			got = append(got, fmt.Sprintf("%v %s", token.Kind, token.Text))
+		}
+		if strings.Join(got, " | ") != test.want {
+			t.Errorf("FAIL: %v: %s: got %s, want %s", test.version, test.xpath, strings.Join(got, " | "), test.want)
+		}
+	}
+	xpath := " a (: c (: d :) :)+ (: e"
+	tokens, err := scanAll(xpath, XPath20, ScanLenient|ScanWhitespace)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kinds []TokenKind
+	var text string
+	for _, token := range tokens {
+		kinds = append(kinds, token.Kind)
+		text += token.Text
+	}
+	want := []TokenKind{TokenWhitespace, TokenIdentifier, TokenWhitespace, TokenComment, TokenPlus, TokenWhitespace, TokenIllegal, TokenEOF}
+	if !reflect.DeepEqual(kinds, want) {
+		t.Errorf("FAIL: got %v, want %v", kinds, want)
+	}
+	if text != xpath {
+		t.Errorf("FAIL: tokens cover %q, want %q", text, xpath)
+	}
+	if e := tokens[len(tokens)-2].Err; e == nil || e.Code != UnclosedComment {
+		t.Errorf("FAIL: got %v, want UnclosedComment", e)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestions is the maximum number of suggestions carried by Error.
+const maxSuggestions = 3
+
+// suggest sets the suggestions for misspelled name on err, and mentions
+// them in its message. It returns err.
+func suggest(err error, name string, candidates []string) error {
+	e := err.(*Error)
+	e.Suggestions = suggestions(name, candidates)
+	if n := len(e.Suggestions); n > 0 {
+		list := e.Suggestions[0]
+		if n > 1 {
+			list = strings.Join(e.Suggestions[:n-1], ", ") + " or " + e.Suggestions[n-1]
+		}
+		e.Msg += "; did you mean " + list + "?"
+	}
+	return err
+}
+
+// suggestions returns the candidates close to name by edit distance,
+// closest first. Candidates at the same distance are sorted by name.
+func suggestions(name string, candidates []string) []string {
+	type suggestion struct {
+		name     string
+		distance int
+	}
+	max := len([]rune(name)) / 3
+	if max < 2 {
+		max = 2
+	}
+	var list []suggestion
+	for _, c := range candidates {
+		if d := editDistance(name, c); d <= max && c != name {
+			list = append(list, suggestion{c, d})
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].distance != list[j].distance {
+			return list[i].distance < list[j].distance
+		}
+		return list[i].name < list[j].name
+	})
+	var result []string
+	for _, s := range list {
+		if len(result) == maxSuggestions {
+			break
+		}
+		if !contains(result, s.name) {
+			result = append(result, s.name)
+		}
+	}
+	return result
+}
+
+// editDistance returns the Levenshtein distance between a and b,
+// counted in runes.
+func editDistance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
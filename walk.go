@@ -0,0 +1,210 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node TreeNode) (w Visitor)
+}
+
+// Walk traverses the expression tree in document order. It starts by calling
+// v.Visit(node); node must not be nil. If the visitor w returned by
+// v.Visit(node) is not nil, Walk is invoked recursively with visitor w
+// for each of the non-nil children of node, followed by a call of w.Visit(nil).
+//
+// The children of a *Step are its NodeTest followed by its Predicates.
+// The children of type expressions, such as *InstanceOfExpr, are its Expr
+// followed by its *SequenceType, whose child is its ItemType. The children
+// of an *InlineFunctionExpr are its Params, its Type, if any, and its Body.
+// The child of a *Param is its Type, if any. The children of a *MapEntry
+// are its Key and Value, and the children of a *LookupExpr are its Expr and
+// Key, if not nil. The children of a *FunctionTest are its ParamTypes and
+// its ReturnType, those of a *MapTest are its KeyType and ValueType, and
+// the child of an *ArrayTest is its MemberType, if not nil.
+func Walk(v Visitor, node TreeNode) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *LocationPath:
+		for _, step := range n.Steps {
+			Walk(v, step)
+		}
+	case *Step:
+		Walk(v, n.NodeTest)
+		walkExprs(v, n.Predicates)
+	case *FilterExpr:
+		Walk(v, n.Expr)
+		walkExprs(v, n.Predicates)
+	case *PathExpr:
+		Walk(v, n.Filter)
+		if n.LocationPath != nil {
+			Walk(v, n.LocationPath)
+		}
+	case *SlashExpr:
+		Walk(v, n.LHS)
+		Walk(v, n.RHS)
+	case *BinaryExpr:
+		Walk(v, n.LHS)
+		Walk(v, n.RHS)
+	case *NegateExpr:
+		Walk(v, n.Expr)
+	case *UnaryPlusExpr:
+		Walk(v, n.Expr)
+	case *FuncCall:
+		walkExprs(v, n.Args)
+	case *ForExpr:
+		Walk(v, n.In)
+		Walk(v, n.Return)
+	case *QuantifiedExpr:
+		Walk(v, n.In)
+		Walk(v, n.Satisfies)
+	case *IfExpr:
+		Walk(v, n.Cond)
+		Walk(v, n.Then)
+		Walk(v, n.Else)
+	case *SequenceExpr:
+		walkExprs(v, n.Items)
+	case *InstanceOfExpr:
+		Walk(v, n.Expr)
+		Walk(v, n.Type)
+	case *TreatExpr:
+		Walk(v, n.Expr)
+		Walk(v, n.Type)
+	case *CastableExpr:
+		Walk(v, n.Expr)
+		Walk(v, n.Type)
+	case *CastExpr:
+		Walk(v, n.Expr)
+		Walk(v, n.Type)
+	case *LetExpr:
+		Walk(v, n.Expr)
+		Walk(v, n.Return)
+	case *InlineFunctionExpr:
+		for _, param := range n.Params {
+			Walk(v, param)
+		}
+		if n.Type != nil {
+			Walk(v, n.Type)
+		}
+		Walk(v, n.Body)
+	case *Param:
+		if n.Type != nil {
+			Walk(v, n.Type)
+		}
+	case *DynamicCallExpr:
+		Walk(v, n.Func)
+		walkExprs(v, n.Args)
+	case *SimpleMapExpr:
+		Walk(v, n.LHS)
+		Walk(v, n.RHS)
+	case *MapConstructor:
+		for _, entry := range n.Entries {
+			Walk(v, entry)
+		}
+	case *MapEntry:
+		Walk(v, n.Key)
+		Walk(v, n.Value)
+	case *ArrayConstructor:
+		walkExprs(v, n.Members)
+	case *LookupExpr:
+		if n.Expr != nil {
+			Walk(v, n.Expr)
+		}
+		if n.Key != nil {
+			Walk(v, n.Key)
+		}
+	case *ArrowExpr:
+		Walk(v, n.Expr)
+		Walk(v, n.Call)
+	case *SequenceType:
+		if n.ItemType != nil {
+			Walk(v, n.ItemType)
+		}
+	case *KindTest:
+		if n.Element != nil {
+			Walk(v, n.Element)
+		}
+	case *FunctionTest:
+		for _, t := range n.ParamTypes {
+			Walk(v, t)
+		}
+		if n.ReturnType != nil {
+			Walk(v, n.ReturnType)
+		}
+	case *MapTest:
+		if n.KeyType != nil {
+			Walk(v, n.KeyType)
+		}
+		if n.ValueType != nil {
+			Walk(v, n.ValueType)
+		}
+	case *ArrayTest:
+		if n.MemberType != nil {
+			Walk(v, n.MemberType)
+		}
+	case *VarRef, *Number, *String, *BadExpr, *NameTest, *NodeTypeTest, *PITest, *AtomicType, *AnyItem, *NamedFunctionRef:
+		// nothing to do
+	default:
+		panic(fmt.Sprintf("xpathparser.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkExprs(v Visitor, exprs []Expr) {
+	for _, expr := range exprs {
+		Walk(v, expr)
+	}
+}
+
+type inspector func(TreeNode) bool
+
+func (f inspector) Visit(node TreeNode) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the expression tree in document order. It starts by
+// calling f(node); node must not be nil. If f returns true, Inspect invokes f
+// recursively for each of the non-nil children of node, followed by a call
+// of f(nil).
+func Inspect(node TreeNode, f func(TreeNode) bool) {
+	Walk(inspector(f), node)
+}
+
+type traverser struct {
+	pre  func(TreeNode) bool
+	post func(TreeNode)
+	node TreeNode // node whose children are being visited
+}
+
+func (t *traverser) Visit(node TreeNode) Visitor {
+	if node == nil {
+		if t.post != nil {
+			t.post(t.node)
+		}
+		return nil
+	}
+	if t.pre != nil && !t.pre(node) {
+		return nil
+	}
+	return &traverser{t.pre, t.post, node}
+}
+
+// Traverse traverses the expression tree in document order, calling pre
+// before and post after visiting the children of each node. If pre returns
+// false, the children of that node are skipped and post is not called for it.
+// Either of pre and post may be nil.
+func Traverse(node TreeNode, pre func(TreeNode) bool, post func(TreeNode)) {
+	Walk(&traverser{pre, post, nil}, node)
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+func TestInspect(t *testing.T) {
+	expr := MustParse(`a[1]/@b | f($x, -2)`)
+	var visited []string
+	Inspect(expr, func(n TreeNode) bool {
+		if n == nil {
+			visited = append(visited, "end")
+		} else {
+			visited = append(visited, fmt.Sprintf("%T", n))
+		}
+		return true
+	})
+	got := strings.Join(visited, " ")
+	want := "*xpathparser.BinaryExpr " +
+		"*xpathparser.LocationPath " +
+		"*xpathparser.Step *xpathparser.NameTest end *xpathparser.Number end end " +
+		"*xpathparser.Step *xpathparser.NameTest end end " +
+		"end " +
+		"*xpathparser.FuncCall *xpathparser.VarRef end *xpathparser.NegateExpr *xpathparser.Number end end end " +
+		"end"
+	if got != want {
+		t.Errorf("FAIL:\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestTraverse(t *testing.T) {
+	expr := MustParse(`f(a[g(1)], $x)/b[2]`)
+	var pre, post []string
+	Traverse(expr, func(n TreeNode) bool {
+		pre = append(pre, n.String())
+		_, isStep := n.(*Step)
+		return !isStep // prune steps
+	}, func(n TreeNode) {
+		post = append(post, n.String())
+	})
+	wantPre := []string{
+		`(f(child::a[g(1)], $x))/child::b[2]`,
+		`f(child::a[g(1)], $x)`,
+		`child::a[g(1)]`,
+		`child::a[g(1)]`,
+		`$x`,
+		`child::b[2]`,
+		`child::b[2]`,
+	}
+	wantPost := []string{
+		`child::a[g(1)]`,
+		`$x`,
+		`f(child::a[g(1)], $x)`,
+		`child::b[2]`,
+		`(f(child::a[g(1)], $x))/child::b[2]`,
+	}
+	if fmt.Sprint(pre) != fmt.Sprint(wantPre) {
+		t.Errorf("FAIL: pre\n got: %q\nwant: %q", pre, wantPre)
+	}
+	if fmt.Sprint(post) != fmt.Sprint(wantPost) {
+		t.Errorf("FAIL: post\n got: %q\nwant: %q", post, wantPost)
+	}
+}
@@ -6,22 +6,31 @@ package xpathparser
 
 import (
 	"fmt"
-	"runtime"
 	"strconv"
 	"strings"
 )
 
-// Error is the error type returned by Parse function.
-//
-// It represents a syntax error in the XPath expression.
-type Error struct {
-	Msg    string
-	XPath  string
-	Offset int
+// Pos describes a position in the xpath expression.
+type Pos struct {
+	Offset int `json:"offset"` // byte offset, starting at 0
+	Line   int `json:"line"`   // line number, starting at 1
+	Column int `json:"column"` // column number in runes, starting at 1
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
 }
 
-func (e *Error) Error() string {
-	return fmt.Sprintf("%s in xpath %s at offset %d", e.Msg, e.XPath, e.Offset)
+// Span describes the part of the xpath expression a node is parsed from.
+// Every node carries its Span. The Span of a node not produced by Parse,
+// such as one built by hand or decoded from JSON, is the zero Span.
+type Span struct {
+	Start Pos `json:"start"` // position of the first character
+	End   Pos `json:"end"`   // position immediately after the last character
+}
+
+func (s Span) String() string {
+	return fmt.Sprintf("%v-%v", s.Start, s.End)
 }
 
 // Axis specifies the tree relationship between the nodes selected by the location step and the context node.
@@ -72,7 +81,7 @@ func init() {
 	}
 }
 
-// NodeType represents test on node type.
+// NodeType is the type of nodes matched by NodeTypeTest.
 type NodeType int
 
 // Possible values for NodeType.
@@ -88,6 +97,23 @@ func (nt NodeType) String() string {
 	return nodeTypeNames[nt]
 }
 
+// Version identifies the version of XPath language.
+type Version int
+
+// Possible values for Version.
+const (
+	XPath10 Version = iota // https://www.w3.org/TR/xpath/
+	XPath20                // https://www.w3.org/TR/xpath20/, adding for, if, some, every, ',', type expressions and operators such as to, eq and is
+	XPath30                // https://www.w3.org/TR/xpath-30/, adding let, inline functions, function references and calls, '!' and '||'
+	XPath31                // https://www.w3.org/TR/xpath-31/, adding map and array constructors, lookup operator '?' and '=>'
+)
+
+var versionNames = []string{"1.0", "2.0", "3.0", "3.1"}
+
+func (v Version) String() string {
+	return versionNames[v]
+}
+
 // Op represents XPath binrary operator.
 type Op int
 
@@ -106,42 +132,208 @@ const (
 	Div
 	And
 	Or
-	Union
+	Union // also written as keyword "union" in XPath 2.0
+	To    // range operator of XPath 2.0
+
+	// value comparisons of XPath 2.0
+	ValueEQ
+	ValueNE
+	ValueLT
+	ValueLE
+	ValueGT
+	ValueGE
+
+	// node comparisons of XPath 2.0
+	Is
+	Precedes
+	Follows
+
+	// other operators of XPath 2.0
+	IDiv
+	Intersect
+	Except
+
+	Concat // string concatenation operator of XPath 3.0
 )
 
+var opNames = []string{
+	"=", "!=", "<", "<=", ">", ">=",
+	"+", "-", "*", "mod", "div",
+	"and", "or", "|",
+	"to",
+	"eq", "ne", "lt", "le", "gt", "ge",
+	"is", "<<", ">>",
+	"idiv", "intersect", "except",
+	"||",
+}
+
 func (op Op) String() string {
-	str := kind(op).String()
-	return str[1 : len(str)-1]
+	return opNames[op]
+}
+
+var name2Op = make(map[string]Op)
+
+func init() {
+	for i, name := range opNames {
+		name2Op[name] = Op(i)
+	}
 }
 
-// An Expr is an interface holding one of the types:
-// *LocationPath, *FilterExpr, *PathExpr, *BinaryExpr, *NegateExpr, *VarRef, *FuncCall, Number or String
-type Expr interface{}
+// ExprKind identifies the concrete type of an Expr.
+type ExprKind int
+
+// Possible values for ExprKind.
+const (
+	KindLocationPath ExprKind = iota
+	KindFilterExpr
+	KindPathExpr
+	KindBinaryExpr
+	KindNegateExpr
+	KindVarRef
+	KindFuncCall
+	KindNumber
+	KindString
+	KindBadExpr
+	KindForExpr
+	KindQuantifiedExpr
+	KindIfExpr
+	KindSequenceExpr
+	KindInstanceOfExpr
+	KindTreatExpr
+	KindCastableExpr
+	KindCastExpr
+	KindLetExpr
+	KindInlineFunctionExpr
+	KindNamedFunctionRef
+	KindDynamicCallExpr
+	KindSimpleMapExpr
+	KindMapConstructor
+	KindArrayConstructor
+	KindLookupExpr
+	KindArrowExpr
+	KindUnaryPlusExpr
+	KindSlashExpr
+)
+
+var exprKindNames = []string{
+	"LocationPath",
+	"FilterExpr",
+	"PathExpr",
+	"BinaryExpr",
+	"NegateExpr",
+	"VarRef",
+	"FuncCall",
+	"Number",
+	"String",
+	"BadExpr",
+	"ForExpr",
+	"QuantifiedExpr",
+	"IfExpr",
+	"SequenceExpr",
+	"InstanceOfExpr",
+	"TreatExpr",
+	"CastableExpr",
+	"CastExpr",
+	"LetExpr",
+	"InlineFunctionExpr",
+	"NamedFunctionRef",
+	"DynamicCallExpr",
+	"SimpleMapExpr",
+	"MapConstructor",
+	"ArrayConstructor",
+	"LookupExpr",
+	"ArrowExpr",
+	"UnaryPlusExpr",
+	"SlashExpr",
+}
+
+func (k ExprKind) String() string {
+	return exprKindNames[k]
+}
+
+// A TreeNode is a node of the expression tree. It is implemented only by the
+// types implementing Expr, NodeTest or ItemType, and by *Step, *SequenceType, *Param and *MapEntry.
+type TreeNode interface {
+	fmt.Stringer
+	node()
+}
+
+// An Expr is an XPath expression. It is implemented only by the types:
+// *LocationPath, *FilterExpr, *PathExpr, *BinaryExpr, *NegateExpr, *VarRef, *FuncCall, *Number, *String,
+// *BadExpr, the types of XPath 2.0: *ForExpr, *QuantifiedExpr, *IfExpr, *SequenceExpr,
+// *InstanceOfExpr, *TreatExpr, *CastableExpr, *CastExpr, *UnaryPlusExpr, *SlashExpr, and the types of XPath 3.0:
+// *LetExpr, *InlineFunctionExpr, *NamedFunctionRef, *DynamicCallExpr, *SimpleMapExpr, and the types
+// of XPath 3.1: *MapConstructor, *ArrayConstructor, *LookupExpr and *ArrowExpr.
+//
+// Kind reports which of these types the Expr holds, so that callers
+// can switch over all of them exhaustively.
+type Expr interface {
+	TreeNode
+	Kind() ExprKind
+	expr()
+}
 
 // BinaryExpr represents a binary operation.
 type BinaryExpr struct {
-	LHS Expr
-	Op  Op
-	RHS Expr
+	LHS  Expr
+	Op   Op
+	RHS  Expr
+	Span Span
 }
 
 func (b *BinaryExpr) String() string {
 	return fmt.Sprintf("(%s %s %s)", b.LHS, b.Op, b.RHS)
 }
 
+// Kind returns KindBinaryExpr.
+func (b *BinaryExpr) Kind() ExprKind {
+	return KindBinaryExpr
+}
+
+func (*BinaryExpr) expr() {}
+func (*BinaryExpr) node() {}
+
 // NegateExpr represents unary operator `-`.
 type NegateExpr struct {
 	Expr Expr
+	Span Span
 }
 
 func (n *NegateExpr) String() string {
 	return fmt.Sprintf("-%s", n.Expr)
 }
 
+// Kind returns KindNegateExpr.
+func (n *NegateExpr) Kind() ExprKind {
+	return KindNegateExpr
+}
+
+func (*NegateExpr) expr() {}
+func (*NegateExpr) node() {}
+
+// UnaryPlusExpr represents unary operator `+` of XPath 2.0.
+type UnaryPlusExpr struct {
+	Expr Expr
+	Span Span
+}
+
+func (u *UnaryPlusExpr) String() string {
+	return fmt.Sprintf("+%s", u.Expr)
+}
+
+// Kind returns KindUnaryPlusExpr.
+func (u *UnaryPlusExpr) Kind() ExprKind {
+	return KindUnaryPlusExpr
+}
+
+func (*UnaryPlusExpr) expr() {}
+func (*UnaryPlusExpr) node() {}
+
 // LocationPath represents XPath location path.
 type LocationPath struct {
 	Abs   bool
 	Steps []*Step
+	Span  Span
 }
 
 func (lp *LocationPath) String() string {
@@ -155,79 +347,208 @@ func (lp *LocationPath) String() string {
 	return fmt.Sprintf("%s", strings.Join(s, "/"))
 }
 
+// Kind returns KindLocationPath.
+func (lp *LocationPath) Kind() ExprKind {
+	return KindLocationPath
+}
+
+func (*LocationPath) expr() {}
+func (*LocationPath) node() {}
+
 // FilterExpr represents https://www.w3.org/TR/xpath/#NT-FilterExpr.
 type FilterExpr struct {
 	Expr       Expr
 	Predicates []Expr
+	Span       Span
 }
 
 func (f *FilterExpr) String() string {
 	return fmt.Sprintf("(%s)%s", f.Expr, predicatesString(f.Predicates))
 }
 
+// Kind returns KindFilterExpr.
+func (f *FilterExpr) Kind() ExprKind {
+	return KindFilterExpr
+}
+
+func (*FilterExpr) expr() {}
+func (*FilterExpr) node() {}
+
 // PathExpr represents https://www.w3.org/TR/xpath/#NT-PathExpr.
 type PathExpr struct {
 	Filter       Expr
 	LocationPath *LocationPath
+	Span         Span
 }
 
 func (p *PathExpr) String() string {
 	return fmt.Sprintf("(%s)/%s", p.Filter, p.LocationPath)
 }
 
+// Kind returns KindPathExpr.
+func (p *PathExpr) Kind() ExprKind {
+	return KindPathExpr
+}
+
+func (*PathExpr) expr() {}
+func (*PathExpr) node() {}
+
+// SlashExpr represents path operator '/' of XPath 2.0, whose right
+// operand is a filter expression rather than a location step, such as
+// "a/string()", which evaluates RHS for each node of LHS. Location steps
+// following RHS are parsed as *PathExpr with SlashExpr as its Filter.
+type SlashExpr struct {
+	LHS  Expr
+	RHS  Expr
+	Span Span
+}
+
+func (s *SlashExpr) String() string {
+	return fmt.Sprintf("(%s)/(%s)", s.LHS, s.RHS)
+}
+
+// Kind returns KindSlashExpr.
+func (s *SlashExpr) Kind() ExprKind {
+	return KindSlashExpr
+}
+
+func (*SlashExpr) expr() {}
+func (*SlashExpr) node() {}
+
 // Step represents XPath location step.
 type Step struct {
 	Axis       Axis
 	NodeTest   NodeTest
 	Predicates []Expr
+	Span       Span
 }
 
 func (s *Step) String() string {
 	return fmt.Sprintf("%v::%s%s", s.Axis, s.NodeTest, predicatesString(s.Predicates))
 }
 
-// A NodeTest is an interface holding one of the types:
-// NodeType, *NameTest, or PITest.
-type NodeTest interface{}
+func (*Step) node() {}
+
+// NodeTestKind identifies the concrete type of a NodeTest.
+type NodeTestKind int
+
+// Possible values for NodeTestKind.
+const (
+	KindNameTest NodeTestKind = iota
+	KindNodeTypeTest
+	KindPITest
+	KindKindTest
+)
+
+var nodeTestKindNames = []string{
+	"NameTest",
+	"NodeTypeTest",
+	"PITest",
+	"KindTest",
+}
+
+func (k NodeTestKind) String() string {
+	return nodeTestKindNames[k]
+}
+
+// A NodeTest is the node test of a location step. It is implemented only by the types:
+// *NameTest, *NodeTypeTest, *PITest and *KindTest of XPath 2.0.
+//
+// Kind reports which of these types the NodeTest holds, like Expr.Kind.
+type NodeTest interface {
+	TreeNode
+	Kind() NodeTestKind
+	nodeTest()
+}
 
 // NameTest represents https://www.w3.org/TR/xpath/#NT-NameTest.
+// Local is "*" for any local name, as in prefix:*, and Prefix is "*"
+// for any namespace, as in *:local of XPath 2.0.
+//
+// The Prefix of name written as EQName of XPath 3.0, such as
+// Q{http://example.com}local, is its braced URI "Q{http://example.com}".
+// The prefixes of other nodes follow the same convention.
 type NameTest struct {
 	Prefix string
 	Local  string
+	Span   Span
 }
 
 func (nt *NameTest) String() string {
-	if nt.Prefix == "" {
-		return nt.Local
-	}
-	return fmt.Sprintf("%s:%s", nt.Prefix, nt.Local)
+	return qname(nt.Prefix, nt.Local)
 }
 
+// Kind returns KindNameTest.
+func (nt *NameTest) Kind() NodeTestKind {
+	return KindNameTest
+}
+
+func (*NameTest) nodeTest() {}
+func (*NameTest) node()     {}
+
+// NodeTypeTest represents test on node type, such as text().
+type NodeTypeTest struct {
+	Type NodeType
+	Span Span
+}
+
+func (nt *NodeTypeTest) String() string {
+	return nt.Type.String()
+}
+
+// Kind returns KindNodeTypeTest.
+func (nt *NodeTypeTest) Kind() NodeTestKind {
+	return KindNodeTypeTest
+}
+
+func (*NodeTypeTest) nodeTest() {}
+func (*NodeTypeTest) itemType() {}
+func (*NodeTypeTest) node()     {}
+
 // PITest represents processing-instruction test.
-type PITest string
+type PITest struct {
+	Target string // empty if not specified
+	Span   Span
+}
 
-func (pt PITest) String() string {
-	return fmt.Sprintf("processing-instruction(%q)", string(pt))
+func (pt *PITest) String() string {
+	return fmt.Sprintf("processing-instruction(%q)", pt.Target)
 }
 
+// Kind returns KindPITest.
+func (pt *PITest) Kind() NodeTestKind {
+	return KindPITest
+}
+
+func (*PITest) nodeTest() {}
+func (*PITest) itemType() {}
+func (*PITest) node()     {}
+
 // VarRef represents https://www.w3.org/TR/xpath/#NT-VariableReference.
 type VarRef struct {
 	Prefix string
 	Local  string
+	Span   Span
 }
 
 func (vr *VarRef) String() string {
-	if vr.Prefix == "" {
-		return fmt.Sprintf("$%s", vr.Local)
-	}
-	return fmt.Sprintf("$%s:%s", vr.Prefix, vr.Local)
+	return "$" + qname(vr.Prefix, vr.Local)
+}
+
+// Kind returns KindVarRef.
+func (vr *VarRef) Kind() ExprKind {
+	return KindVarRef
 }
 
+func (*VarRef) expr() {}
+func (*VarRef) node() {}
+
 // FuncCall represents https://www.w3.org/TR/xpath/#section-Function-Calls.
 type FuncCall struct {
 	Prefix string
 	Local  string
 	Args   []Expr
+	Span   Span
 }
 
 func (fc *FuncCall) String() string {
@@ -235,48 +556,793 @@ func (fc *FuncCall) String() string {
 	for i, param := range fc.Args {
 		p[i] = fmt.Sprint(param)
 	}
-	if fc.Prefix == "" {
-		return fmt.Sprintf("%s(%s)", fc.Local, strings.Join(p, ", "))
-	}
-	return fmt.Sprintf("%s:%s(%s)", fc.Prefix, fc.Local, strings.Join(p, ", "))
+	return fmt.Sprintf("%s(%s)", qname(fc.Prefix, fc.Local), strings.Join(p, ", "))
+}
+
+// Kind returns KindFuncCall.
+func (fc *FuncCall) Kind() ExprKind {
+	return KindFuncCall
 }
 
+func (*FuncCall) expr() {}
+func (*FuncCall) node() {}
+
 // Number represents number literal.
-type Number float64
+type Number struct {
+	Value float64
+	Span  Span
+}
 
-func (n Number) String() string {
-	return strconv.FormatFloat(float64(n), 'f', -1, 64)
+func (n *Number) String() string {
+	return strconv.FormatFloat(n.Value, 'f', -1, 64)
 }
 
+// Kind returns KindNumber.
+func (n *Number) Kind() ExprKind {
+	return KindNumber
+}
+
+func (*Number) expr() {}
+func (*Number) node() {}
+
 // String represents string literal.
-type String string
+type String struct {
+	Value string
+	Span  Span
+}
+
+func (s *String) String() string {
+	return strconv.Quote(s.Value)
+}
+
+// Kind returns KindString.
+func (s *String) Kind() ExprKind {
+	return KindString
+}
+
+func (*String) expr() {}
+func (*String) node() {}
+
+// BadExpr is a placeholder for an expression containing syntax errors.
+// It appears only in the trees returned by ParseAll.
+type BadExpr struct {
+	Span Span
+}
+
+func (be *BadExpr) String() string {
+	return "BadExpr"
+}
+
+// Kind returns KindBadExpr.
+func (be *BadExpr) Kind() ExprKind {
+	return KindBadExpr
+}
+
+func (*BadExpr) expr() {}
+func (*BadExpr) node() {}
+
+// ForExpr represents https://www.w3.org/TR/xpath20/#id-for-expressions.
+// A for expression with multiple variables, such as
+// "for $x in X, $y in Y return R", is represented as nested ForExpr,
+// as in "for $x in X return for $y in Y return R".
+type ForExpr struct {
+	Prefix string // prefix of variable name
+	Local  string // local part of variable name
+	In     Expr
+	Return Expr
+	Span   Span
+}
+
+func (f *ForExpr) String() string {
+	return fmt.Sprintf("(for %s in %s return %s)", "$"+qname(f.Prefix, f.Local), f.In, f.Return)
+}
+
+// Kind returns KindForExpr.
+func (f *ForExpr) Kind() ExprKind {
+	return KindForExpr
+}
+
+func (*ForExpr) expr() {}
+func (*ForExpr) node() {}
+
+// QuantifiedExpr represents https://www.w3.org/TR/xpath20/#id-quantified-expressions.
+// Like ForExpr, an expression with multiple variables is represented as
+// nested QuantifiedExpr.
+type QuantifiedExpr struct {
+	Every     bool   // every, instead of some
+	Prefix    string // prefix of variable name
+	Local     string // local part of variable name
+	In        Expr
+	Satisfies Expr
+	Span      Span
+}
+
+func (q *QuantifiedExpr) String() string {
+	quantifier := "some"
+	if q.Every {
+		quantifier = "every"
+	}
+	return fmt.Sprintf("(%s %s in %s satisfies %s)", quantifier, "$"+qname(q.Prefix, q.Local), q.In, q.Satisfies)
+}
+
+// Kind returns KindQuantifiedExpr.
+func (q *QuantifiedExpr) Kind() ExprKind {
+	return KindQuantifiedExpr
+}
+
+func (*QuantifiedExpr) expr() {}
+func (*QuantifiedExpr) node() {}
+
+// IfExpr represents https://www.w3.org/TR/xpath20/#id-conditionals.
+type IfExpr struct {
+	Cond Expr
+	Then Expr
+	Else Expr
+	Span Span
+}
 
-func (s String) String() string {
-	return strconv.Quote(string(s))
+func (i *IfExpr) String() string {
+	return fmt.Sprintf("(if (%s) then %s else %s)", i.Cond, i.Then, i.Else)
 }
 
+// Kind returns KindIfExpr.
+func (i *IfExpr) Kind() ExprKind {
+	return KindIfExpr
+}
+
+func (*IfExpr) expr() {}
+func (*IfExpr) node() {}
+
+// SequenceExpr represents https://www.w3.org/TR/xpath20/#construct_seq,
+// such as "(1, 2)" or the empty sequence "()". A parenthesized single
+// expression is not a SequenceExpr.
+type SequenceExpr struct {
+	Items []Expr
+	Span  Span
+}
+
+func (s *SequenceExpr) String() string {
+	items := make([]string, len(s.Items))
+	for i, item := range s.Items {
+		items[i] = fmt.Sprint(item)
+	}
+	return fmt.Sprintf("(%s)", strings.Join(items, ", "))
+}
+
+// Kind returns KindSequenceExpr.
+func (s *SequenceExpr) Kind() ExprKind {
+	return KindSequenceExpr
+}
+
+func (*SequenceExpr) expr() {}
+func (*SequenceExpr) node() {}
+
+// InstanceOfExpr represents https://www.w3.org/TR/xpath20/#id-instance-of.
+type InstanceOfExpr struct {
+	Expr Expr
+	Type *SequenceType
+	Span Span
+}
+
+func (i *InstanceOfExpr) String() string {
+	return fmt.Sprintf("(%s instance of %s)", i.Expr, i.Type)
+}
+
+// Kind returns KindInstanceOfExpr.
+func (i *InstanceOfExpr) Kind() ExprKind {
+	return KindInstanceOfExpr
+}
+
+func (*InstanceOfExpr) expr() {}
+func (*InstanceOfExpr) node() {}
+
+// TreatExpr represents https://www.w3.org/TR/xpath20/#id-treat.
+type TreatExpr struct {
+	Expr Expr
+	Type *SequenceType
+	Span Span
+}
+
+func (t *TreatExpr) String() string {
+	return fmt.Sprintf("(%s treat as %s)", t.Expr, t.Type)
+}
+
+// Kind returns KindTreatExpr.
+func (t *TreatExpr) Kind() ExprKind {
+	return KindTreatExpr
+}
+
+func (*TreatExpr) expr() {}
+func (*TreatExpr) node() {}
+
+// CastableExpr represents https://www.w3.org/TR/xpath20/#id-castable.
+// Type is a single type: its ItemType is *AtomicType, and its Occurrence
+// is either ExactlyOne or ZeroOrOne.
+type CastableExpr struct {
+	Expr Expr
+	Type *SequenceType
+	Span Span
+}
+
+func (c *CastableExpr) String() string {
+	return fmt.Sprintf("(%s castable as %s)", c.Expr, c.Type)
+}
+
+// Kind returns KindCastableExpr.
+func (c *CastableExpr) Kind() ExprKind {
+	return KindCastableExpr
+}
+
+func (*CastableExpr) expr() {}
+func (*CastableExpr) node() {}
+
+// CastExpr represents https://www.w3.org/TR/xpath20/#id-cast.
+// Type is a single type, as in CastableExpr.
+type CastExpr struct {
+	Expr Expr
+	Type *SequenceType
+	Span Span
+}
+
+func (c *CastExpr) String() string {
+	return fmt.Sprintf("(%s cast as %s)", c.Expr, c.Type)
+}
+
+// Kind returns KindCastExpr.
+func (c *CastExpr) Kind() ExprKind {
+	return KindCastExpr
+}
+
+func (*CastExpr) expr() {}
+func (*CastExpr) node() {}
+
+// LetExpr represents https://www.w3.org/TR/xpath-30/#id-let-expressions.
+// Like ForExpr, an expression with multiple variables is represented as
+// nested LetExpr.
+type LetExpr struct {
+	Prefix string // prefix of variable name
+	Local  string // local part of variable name
+	Expr   Expr   // value bound to the variable
+	Return Expr
+	Span   Span
+}
+
+func (l *LetExpr) String() string {
+	return fmt.Sprintf("(let %s := %s return %s)", "$"+qname(l.Prefix, l.Local), l.Expr, l.Return)
+}
+
+// Kind returns KindLetExpr.
+func (l *LetExpr) Kind() ExprKind {
+	return KindLetExpr
+}
+
+func (*LetExpr) expr() {}
+func (*LetExpr) node() {}
+
+// InlineFunctionExpr represents https://www.w3.org/TR/xpath-30/#id-inline-func,
+// such as "function($a as xs:integer) as xs:integer { $a * $a }".
+// The empty Body of XPath 3.1, as in "function() {}", is represented as
+// empty *SequenceExpr, whose Span covers the braces.
+type InlineFunctionExpr struct {
+	Params []*Param
+	Type   *SequenceType // declared return type, nil if not declared
+	Body   Expr
+	Span   Span
+}
+
+func (f *InlineFunctionExpr) String() string {
+	params := make([]string, len(f.Params))
+	for i, param := range f.Params {
+		params[i] = param.String()
+	}
+	s := fmt.Sprintf("function(%s)", strings.Join(params, ", "))
+	if f.Type != nil {
+		s += fmt.Sprintf(" as %s", f.Type)
+	}
+	return fmt.Sprintf("%s { %s }", s, f.Body)
+}
+
+// Kind returns KindInlineFunctionExpr.
+func (f *InlineFunctionExpr) Kind() ExprKind {
+	return KindInlineFunctionExpr
+}
+
+func (*InlineFunctionExpr) expr() {}
+func (*InlineFunctionExpr) node() {}
+
+// Param is a parameter of InlineFunctionExpr.
+type Param struct {
+	Prefix string        // prefix of parameter name
+	Local  string        // local part of parameter name
+	Type   *SequenceType // declared type, nil if not declared
+	Span   Span
+}
+
+func (p *Param) String() string {
+	if p.Type == nil {
+		return "$" + qname(p.Prefix, p.Local)
+	}
+	return fmt.Sprintf("$%s as %s", qname(p.Prefix, p.Local), p.Type)
+}
+
+func (*Param) node() {}
+
+// NamedFunctionRef represents https://www.w3.org/TR/xpath-30/#id-named-function-ref,
+// such as fn:concat#3.
+type NamedFunctionRef struct {
+	Prefix string
+	Local  string
+	Arity  int
+	Span   Span
+}
+
+func (r *NamedFunctionRef) String() string {
+	return fmt.Sprintf("%s#%d", qname(r.Prefix, r.Local), r.Arity)
+}
+
+// Kind returns KindNamedFunctionRef.
+func (r *NamedFunctionRef) Kind() ExprKind {
+	return KindNamedFunctionRef
+}
+
+func (*NamedFunctionRef) expr() {}
+func (*NamedFunctionRef) node() {}
+
+// DynamicCallExpr represents https://www.w3.org/TR/xpath-30/#id-dynamic-function-invocation,
+// such as $f(1), which calls the function item Func evaluates to.
+type DynamicCallExpr struct {
+	Func Expr
+	Args []Expr
+	Span Span
+}
+
+func (d *DynamicCallExpr) String() string {
+	args := make([]string, len(d.Args))
+	for i, arg := range d.Args {
+		args[i] = fmt.Sprint(arg)
+	}
+	return fmt.Sprintf("(%s)(%s)", d.Func, strings.Join(args, ", "))
+}
+
+// Kind returns KindDynamicCallExpr.
+func (d *DynamicCallExpr) Kind() ExprKind {
+	return KindDynamicCallExpr
+}
+
+func (*DynamicCallExpr) expr() {}
+func (*DynamicCallExpr) node() {}
+
+// SimpleMapExpr represents https://www.w3.org/TR/xpath-30/#id-map-operator,
+// such as "a ! string()", which evaluates RHS for each item of LHS.
+// The operator is left associative, like the binary operators.
+type SimpleMapExpr struct {
+	LHS  Expr
+	RHS  Expr
+	Span Span
+}
+
+func (s *SimpleMapExpr) String() string {
+	return fmt.Sprintf("(%s ! %s)", s.LHS, s.RHS)
+}
+
+// Kind returns KindSimpleMapExpr.
+func (s *SimpleMapExpr) Kind() ExprKind {
+	return KindSimpleMapExpr
+}
+
+func (*SimpleMapExpr) expr() {}
+func (*SimpleMapExpr) node() {}
+
+// MapConstructor represents https://www.w3.org/TR/xpath-31/#id-map-constructors,
+// such as map {"a": 1, "b": 2}.
+type MapConstructor struct {
+	Entries []*MapEntry
+	Span    Span
+}
+
+func (m *MapConstructor) String() string {
+	entries := make([]string, len(m.Entries))
+	for i, entry := range m.Entries {
+		entries[i] = entry.String()
+	}
+	return fmt.Sprintf("map { %s }", strings.Join(entries, ", "))
+}
+
+// Kind returns KindMapConstructor.
+func (m *MapConstructor) Kind() ExprKind {
+	return KindMapConstructor
+}
+
+func (*MapConstructor) expr() {}
+func (*MapConstructor) node() {}
+
+// MapEntry is an entry of MapConstructor.
+type MapEntry struct {
+	Key   Expr
+	Value Expr
+	Span  Span
+}
+
+func (e *MapEntry) String() string {
+	return fmt.Sprintf("%s: %s", e.Key, e.Value)
+}
+
+func (*MapEntry) node() {}
+
+// ArrayConstructor represents https://www.w3.org/TR/xpath-31/#id-array-constructors.
+// Members of square array constructor, such as [1, (2, 3)], are the members
+// of the array. Members of curly array constructor, such as array {1, 2},
+// are the comma separated expressions in braces, whose items are the
+// members of the array.
+type ArrayConstructor struct {
+	Curly   bool
+	Members []Expr
+	Span    Span
+}
+
+func (a *ArrayConstructor) String() string {
+	members := make([]string, len(a.Members))
+	for i, member := range a.Members {
+		members[i] = fmt.Sprint(member)
+	}
+	if a.Curly {
+		return fmt.Sprintf("array { %s }", strings.Join(members, ", "))
+	}
+	return fmt.Sprintf("[%s]", strings.Join(members, ", "))
+}
+
+// Kind returns KindArrayConstructor.
+func (a *ArrayConstructor) Kind() ExprKind {
+	return KindArrayConstructor
+}
+
+func (*ArrayConstructor) expr() {}
+func (*ArrayConstructor) node() {}
+
+// LookupExpr represents https://www.w3.org/TR/xpath-31/#id-lookup, such as
+// $m?a, $a?1, $m?($k) or $m?*. Expr is nil for unary lookup, such as ?a
+// in predicate. NCName key is represented as String and parenthesized key
+// as the expression in parentheses.
+type LookupExpr struct {
+	Expr Expr // nil for unary lookup
+	Key  Expr // nil for wildcard '*'
+	Span Span
+}
+
+func (l *LookupExpr) String() string {
+	key := "*"
+	if l.Key != nil {
+		key = fmt.Sprintf("(%s)", l.Key)
+	}
+	if l.Expr == nil {
+		return "?" + key
+	}
+	return fmt.Sprintf("(%s)?%s", l.Expr, key)
+}
+
+// Kind returns KindLookupExpr.
+func (l *LookupExpr) Kind() ExprKind {
+	return KindLookupExpr
+}
+
+func (*LookupExpr) expr() {}
+func (*LookupExpr) node() {}
+
+// ArrowExpr represents https://www.w3.org/TR/xpath-31/#id-arrow-operator,
+// such as "$s => upper-case()". Call is the *FuncCall or *DynamicCallExpr,
+// to which the value of Expr is passed as first argument, in addition to
+// its Args. The operator is left associative, so "a => f() => g()" is
+// represented as nested ArrowExpr.
+type ArrowExpr struct {
+	Expr Expr
+	Call Expr
+	Span Span
+}
+
+func (a *ArrowExpr) String() string {
+	return fmt.Sprintf("(%s => %s)", a.Expr, a.Call)
+}
+
+// Kind returns KindArrowExpr.
+func (a *ArrowExpr) Kind() ExprKind {
+	return KindArrowExpr
+}
+
+func (*ArrowExpr) expr() {}
+func (*ArrowExpr) node() {}
+
+// Occurrence is the occurrence indicator of SequenceType.
+type Occurrence int
+
+// Possible values for Occurrence.
+const (
+	ExactlyOne Occurrence = iota // no occurrence indicator
+	ZeroOrOne                    // '?'
+	ZeroOrMore                   // '*'
+	OneOrMore                    // '+'
+)
+
+var occurrenceNames = []string{"", "?", "*", "+"}
+
+// String returns the occurrence indicator, which is empty for ExactlyOne.
+func (o Occurrence) String() string {
+	return occurrenceNames[o]
+}
+
+// SequenceType represents https://www.w3.org/TR/xpath20/#id-sequencetype-syntax.
+// The empty-sequence() is represented by nil ItemType.
+type SequenceType struct {
+	ItemType   ItemType
+	Occurrence Occurrence
+	Span       Span
+}
+
+func (st *SequenceType) String() string {
+	if st.ItemType == nil {
+		return "empty-sequence()"
+	}
+	if ft, ok := st.ItemType.(*FunctionTest); ok && ft.ReturnType != nil && st.Occurrence != ExactlyOne {
+		// the occurrence indicator would otherwise bind to the return type
+		return "(" + ft.String() + ")" + st.Occurrence.String()
+	}
+	return st.ItemType.String() + st.Occurrence.String()
+}
+
+func (*SequenceType) node() {}
+
+// An ItemType is the item type of SequenceType. It is implemented only by the types:
+// *AtomicType, *AnyItem, *NodeTypeTest, *PITest, *KindTest, *FunctionTest, *MapTest and *ArrayTest.
+type ItemType interface {
+	TreeNode
+	itemType()
+}
+
+// AtomicType represents atomic type, such as xs:integer.
+type AtomicType struct {
+	Prefix string
+	Local  string
+	Span   Span
+}
+
+func (at *AtomicType) String() string {
+	return qname(at.Prefix, at.Local)
+}
+
+func (*AtomicType) itemType() {}
+func (*AtomicType) node()     {}
+
+// AnyItem represents item(), which matches any node or atomic value.
+type AnyItem struct {
+	Span Span
+}
+
+func (*AnyItem) String() string {
+	return "item()"
+}
+
+func (*AnyItem) itemType() {}
+func (*AnyItem) node()     {}
+
+// FunctionTest represents function test of XPath 3.0, such as function(*)
+// or function(xs:string, item()*) as xs:integer.
+type FunctionTest struct {
+	ParamTypes []*SequenceType
+	ReturnType *SequenceType // nil in function(*), which matches any function
+	Span       Span
+}
+
+func (ft *FunctionTest) String() string {
+	if ft.ReturnType == nil {
+		return "function(*)"
+	}
+	params := make([]string, len(ft.ParamTypes))
+	for i, t := range ft.ParamTypes {
+		params[i] = t.String()
+	}
+	return fmt.Sprintf("function(%s) as %s", strings.Join(params, ", "), ft.ReturnType)
+}
+
+func (*FunctionTest) itemType() {}
+func (*FunctionTest) node()     {}
+
+// MapTest represents map test of XPath 3.1, such as map(*) or
+// map(xs:string, item()*).
+type MapTest struct {
+	KeyType   *AtomicType   // nil in map(*), which matches any map
+	ValueType *SequenceType // nil in map(*)
+	Span      Span
+}
+
+func (mt *MapTest) String() string {
+	if mt.KeyType == nil {
+		return "map(*)"
+	}
+	return fmt.Sprintf("map(%s, %s)", mt.KeyType, mt.ValueType)
+}
+
+func (*MapTest) itemType() {}
+func (*MapTest) node()     {}
+
+// ArrayTest represents array test of XPath 3.1, such as array(*) or
+// array(xs:integer+).
+type ArrayTest struct {
+	MemberType *SequenceType // nil in array(*), which matches any array
+	Span       Span
+}
+
+func (at *ArrayTest) String() string {
+	if at.MemberType == nil {
+		return "array(*)"
+	}
+	return fmt.Sprintf("array(%s)", at.MemberType)
+}
+
+func (*ArrayTest) itemType() {}
+func (*ArrayTest) node()     {}
+
+// TestKind identifies the kind of nodes matched by KindTest.
+type TestKind int
+
+// Possible values for TestKind.
+const (
+	DocumentTest TestKind = iota
+	ElementTest
+	AttributeTest
+	SchemaElementTest
+	SchemaAttributeTest
+	NamespaceNodeTest // XPath 3.0
+)
+
+var testKindNames = []string{"document-node", "element", "attribute", "schema-element", "schema-attribute", "namespace-node"}
+
+// String returns the name of the test, such as "element".
+func (k TestKind) String() string {
+	return testKindNames[k]
+}
+
+// KindTest represents the kind tests of XPath 2.0 that XPath 1.0 lacks,
+// such as element(name, type) or document-node(element(name)), and the
+// namespace-node() of XPath 3.0. Kind tests of XPath 1.0 are represented
+// by NodeTypeTest and PITest.
+type KindTest struct {
+	Test TestKind
+
+	// Prefix and Local are the element or attribute name. Local is "*"
+	// for any name, and empty if name is not specified. The schema tests
+	// always specify a name.
+	Prefix string
+	Local  string
+
+	// TypePrefix and TypeLocal are the type name of element and attribute
+	// tests. TypeLocal is empty if type name is not specified.
+	TypePrefix string
+	TypeLocal  string
+
+	Nillable bool      // type name followed by '?', in element test
+	Element  *KindTest // element or schema-element test of document test, if any
+	Span     Span
+}
+
+func (kt *KindTest) String() string {
+	var args []string
+	switch {
+	case kt.Element != nil:
+		args = append(args, kt.Element.String())
+	case kt.Local != "":
+		args = append(args, qname(kt.Prefix, kt.Local))
+		if kt.TypeLocal != "" {
+			typeName := qname(kt.TypePrefix, kt.TypeLocal)
+			if kt.Nillable {
+				typeName += "?"
+			}
+			args = append(args, typeName)
+		}
+	}
+	return fmt.Sprintf("%s(%s)", kt.Test, strings.Join(args, ", "))
+}
+
+// Kind returns KindKindTest.
+func (kt *KindTest) Kind() NodeTestKind {
+	return KindKindTest
+}
+
+func (*KindTest) nodeTest() {}
+func (*KindTest) itemType() {}
+func (*KindTest) node()     {}
+
 // MustParse is like Parse but panics if the xpath expression has error.
 // It simplifies safe initialization of global variables holding parsed expressions.
 func MustParse(xpath string) Expr {
-	p := &parser{lexer: lexer{xpath: xpath}}
+	expr, err := Parse(xpath)
+	if err != nil {
+		panic(err)
+	}
+	return expr
+}
+
+// Parse parses given xpath 1.0 expression. Use ParseOptions
+// to parse expressions of later versions.
+// The error returned, if any, is of type *Error.
+func Parse(xpath string) (Expr, error) {
+	return new(ParseOptions).Parse(xpath)
+}
+
+// ParseAll parses given xpath 1.0 expression like Parse, but does not stop
+// at the first syntax error. Instead, it reports the error, skips ahead to
+// the next ']', ')', ',' or operator and continues parsing from there.
+//
+// It returns a best-effort tree, in which each unparseable part of the
+// expression is replaced with a *BadExpr, and the syntax errors ordered
+// by offset. The tree is never nil, and errors is empty if and only if
+// Parse succeeds for xpath.
+func ParseAll(xpath string) (expr Expr, errors []*Error) {
+	return new(ParseOptions).ParseAll(xpath)
+}
+
+// ParseOptions controls parsing. The zero value is used by Parse and ParseAll.
+//
+// The limits protect against xpaths from untrusted sources. Exceeding
+// any limit is an error of code LimitExceeded.
+type ParseOptions struct {
+	// Version is the version of XPath grammar used. The zero value
+	// is XPath10.
+	Version Version
+
+	// Functions lists the names of functions that may be called, such as
+	// "concat" or "ext:node-set". If not nil, a call to any other function
+	// is an error of code UnknownFunction.
+	Functions []string
+
+	// MaxLength is the maximum length of xpath in bytes.
+	// Zero means no limit.
+	MaxLength int
+
+	// MaxDepth is the maximum nesting of parenthesized expressions,
+	// predicates, function arguments, unary minus and operators. Each
+	// operator of a left associative chain, such as "1 + 2 + 3", and each
+	// dynamic call or lookup applied to an expression, nests one level,
+	// as they do in the tree. It bounds the recursion of the parser and
+	// of the functions walking the tree, such as Format. Zero means
+	// DefaultMaxDepth.
+	MaxDepth int
+
+	// MaxSteps is the maximum number of location steps in xpath,
+	// including the steps abbreviated by "//". Zero means no limit.
+	MaxSteps int
+
+	// MaxPredicates is the maximum number of predicates in xpath.
+	// Zero means no limit.
+	MaxPredicates int
+
+	// MaxArgs is the maximum number of arguments in a function call.
+	// Zero means no limit.
+	MaxArgs int
+}
+
+// DefaultMaxDepth is the nesting limit used, if ParseOptions.MaxDepth
+// is zero.
+const DefaultMaxDepth = 1000
+
+func (o *ParseOptions) maxDepth() int {
+	if o.MaxDepth > 0 {
+		return o.MaxDepth
+	}
+	return DefaultMaxDepth
+}
+
+// Parse parses given xpath expression using the options.
+// See the package-level Parse.
+func (o *ParseOptions) Parse(xpath string) (Expr, error) {
+	p := &parser{lexer: lexer{xpath: xpath, version: o.Version}, options: *o}
 	return p.parse()
 }
 
-// Parse parses given xpath 1.0 expression.
-func Parse(xpath string) (expr Expr, err error) {
-	defer func() {
-		if r := recover(); r != nil {
-			if _, ok := r.(runtime.Error); ok {
-				panic(r)
-			}
-			if _, ok := r.(error); ok {
-				err = r.(error)
-			} else {
-				err = fmt.Errorf("%v", r)
-			}
-		}
-	}()
-	return MustParse(xpath), nil
+// ParseAll parses given xpath expression using the options.
+// See the package-level ParseAll.
+func (o *ParseOptions) ParseAll(xpath string) (expr Expr, errors []*Error) {
+	p := &parser{lexer: lexer{xpath: xpath, version: o.Version}, options: *o, recovering: true}
+	expr, _ = p.parse()
+	return expr, p.errors
 }
 
 func predicatesString(predicates []Expr) string {
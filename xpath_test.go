@@ -7,230 +7,352 @@ package xpathparser_test
 import (
 	"encoding/xml"
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	. "github.com/santhosh-tekuri/xpathparser"
 )
 
+var invalidXPaths = []string{
+	``,
+	`1.2.3`,
+	`"one`,
+	`'one`,
+	`hero::*`,
+	`$`,
+	`$$`,
+	`+`,
+	`!`,
+	`!=`,
+	`abc def`,
+	`abc and`,
+	`child::`,
+	`/abc/`,
+	`abc/`,
+	`@`,
+	`/@`,
+	`child::abcd()`,
+	`;abc`,
+	`abc;def`,
+	`/;`,
+	`[`,
+	`a[`,
+	`a[1`,
+	`a[]`,
+	`@-name`,
+	`@1one`,
+	`@.one`,
+	`abc^def`,
+	`abc#def`,
+	`foo(`,
+	`foo(1`,
+	`foo(1,`,
+	`a|`,
+	`//`,
+	`//+1`,
+	`(.)/123`,
+	`123/`,
+	`abc[]`,
+	strings.Repeat("9", 400),
+}
+
 func TestInvalidXPaths(t *testing.T) {
-	tests := []string{
-		``,
-		`1.2.3`,
-		`"one`,
-		`'one`,
-		`hero::*`,
-		`$`,
-		`$$`,
-		`+`,
-		`!`,
-		`!=`,
-		`abc def`,
-		`abc and`,
-		`child::`,
-		`/abc/`,
-		`abc/`,
-		`@`,
-		`/@`,
-		`child::abcd()`,
-		`;abc`,
-		`abc;def`,
-		`/;`,
-		`[`,
-		`a[`,
-		`a[1`,
-		`a[]`,
-		`@-name`,
-		`@1one`,
-		`@.one`,
-		`abc^def`,
-		`abc#def`,
-		`foo(`,
-		`foo(1`,
-		`foo(1,`,
-		`a|`,
-		`//`,
-		`//+1`,
-		`(.)/123`,
-		`123/`,
-		`abc[]`,
-	}
-	for _, test := range tests {
+	for _, test := range invalidXPaths {
 		if _, err := Parse(test); err == nil {
 			t.Errorf("FAIL: error expected for %s", test)
+		} else if _, ok := err.(*Error); !ok {
+			t.Errorf("FAIL: *Error expected for %s, got %T", test, err)
 		} else {
 			t.Log(err)
 		}
 	}
 }
 
+func TestParseAll(t *testing.T) {
+	tests := []struct {
+		xpath   string
+		want    string
+		offsets []int
+	}{
+		{`a[1 2] + f(1 +, 3) and b[`, `a[1] + f(1 + BadExpr, 3) and b[BadExpr]`, []int{4, 14, 25}},
+		{`a[@]/b[)]`, `a[BadExpr]/b[BadExpr]`, []int{3, 7}},
+		{`foo(a b, c)`, `foo(a, c)`, []int{6}},
+		{`(1 + )[2] = 3`, `(1 + BadExpr)[2] = 3`, []int{5}},
+		{`1/a | b`, `BadExpr | b`, []int{1}},
+		{`a | | b`, `a | BadExpr | b`, []int{4}},
+		{`a ! b`, `a`, []int{2}},
+		{`a) + b`, `a`, []int{1}},
+		{`'abc`, `BadExpr`, []int{4}},
+		{`a[b = 'c] or d`, `a[b = BadExpr]`, []int{14}},
+	}
+	config := &PrintConfig{Mode: Abbreviate | MinimalParens}
+	for _, test := range tests {
+		expr, errs := ParseAll(test.xpath)
+		if got := config.Format(expr); got != test.want {
+			t.Errorf("FAIL: %s: got %s, want %s", test.xpath, got, test.want)
+		}
+		var offsets []int
+		for _, err := range errs {
+			offsets = append(offsets, err.Offset)
+		}
+		if !reflect.DeepEqual(offsets, test.offsets) {
+			t.Errorf("FAIL: %s: got errors %v, want at offsets %v", test.xpath, errs, test.offsets)
+		}
+	}
+
+	// first error must be the one reported by Parse
+	for _, xpath := range invalidXPaths {
+		_, err := Parse(xpath)
+		expr, errs := ParseAll(xpath)
+		if expr == nil || len(errs) == 0 || errs[0].Offset != err.(*Error).Offset {
+			t.Errorf("FAIL: %s: got %v, %v, want %v", xpath, expr, errs, err)
+		}
+	}
+	for _, test := range roundTrips() {
+		expr, errs := (&ParseOptions{Version: test.version}).ParseAll(test.xpath)
+		if len(errs) != 0 || !Equal(expr, test.expr) {
+			t.Errorf("FAIL: %s: got %v, %v", test.xpath, expr, errs)
+		}
+	}
+}
+
+func TestParseLimits(t *testing.T) {
+	deep := 100000
+	tests := []struct {
+		options ParseOptions
+		xpath   string
+		offset  int
+	}{
+		{ParseOptions{}, strings.Repeat("(", deep) + "1" + strings.Repeat(")", deep), DefaultMaxDepth},
+		{ParseOptions{}, strings.Repeat("a[", deep) + "1" + strings.Repeat("]", deep), 2 * DefaultMaxDepth},
+		{ParseOptions{}, strings.Repeat("f(", deep) + strings.Repeat(")", deep), 2 * DefaultMaxDepth},
+		{ParseOptions{}, strings.Repeat("-", deep) + "1", DefaultMaxDepth - 1},
+		{ParseOptions{}, strings.Repeat("1+", deep) + "1", 2*DefaultMaxDepth - 1},
+		{ParseOptions{}, strings.Repeat("a|", deep) + "a", 2*DefaultMaxDepth - 1},
+		{ParseOptions{Version: XPath30}, "$f" + strings.Repeat("()", deep), 2 * DefaultMaxDepth},
+		{ParseOptions{Version: XPath31}, "$m" + strings.Repeat("?a", 2*DefaultMaxDepth), 2 * DefaultMaxDepth},
+		{ParseOptions{Version: XPath20}, "a" + strings.Repeat("/f()", deep), 4*DefaultMaxDepth - 3},
+		{ParseOptions{Version: XPath30}, "1 instance of " + strings.Repeat("function(", deep), 14 + 9*(DefaultMaxDepth-1)},
+		{ParseOptions{Version: XPath30}, "1 instance of " + strings.Repeat("(", deep), 14 + DefaultMaxDepth - 1},
+		{ParseOptions{Version: XPath31}, "1 instance of " + strings.Repeat("array(", deep), 14 + 6*(DefaultMaxDepth-1)},
+		{ParseOptions{MaxDepth: 3}, `(((1)))`, 3},
+		{ParseOptions{MaxDepth: 3}, `a[b[c[1]]]`, 6},
+		{ParseOptions{MaxDepth: 3}, `1+2*3+4-5`, 7},
+		{ParseOptions{MaxDepth: 3}, `a or b or c or d`, 12},
+		{ParseOptions{MaxLength: 5}, `a/b/c/d`, 5},
+		{ParseOptions{MaxSteps: 3}, `a/b/c/d`, 6},
+		{ParseOptions{MaxSteps: 3}, `a//b/c`, 5},
+		{ParseOptions{MaxSteps: 3}, `a[b/c]/d`, 7},
+		{ParseOptions{MaxPredicates: 2}, `a[1][2][3]`, 7},
+		{ParseOptions{MaxPredicates: 2}, `$x[a[1]][2]`, 8},
+		{ParseOptions{MaxArgs: 2}, `concat(1, 2, 3)`, 13},
+	}
+	for _, test := range tests {
+		_, err := test.options.Parse(test.xpath)
+		e, ok := err.(*Error)
+		if !ok || e.Code != LimitExceeded || e.Offset != test.offset {
+			t.Errorf("FAIL: %.20s: got %.60v, want LimitExceeded at offset %d", test.xpath, err, test.offset)
+			continue
+		}
+		expr, errs := test.options.ParseAll(test.xpath)
+		if expr == nil || len(errs) == 0 || errs[0].Code != LimitExceeded || errs[0].Offset != test.offset {
+			t.Errorf("FAIL: %.20s: ParseAll got %d errors, want LimitExceeded at offset %d", test.xpath, len(errs), test.offset)
+		}
+	}
+
+	options := ParseOptions{MaxLength: 7, MaxDepth: 3, MaxSteps: 4, MaxPredicates: 2, MaxArgs: 2}
+	for _, xpath := range []string{`((1))`, `a/b/c/d`, `a[1][2]`, `f(1, 2)`, `a//b/c`, `1+2+3`} {
+		if _, err := options.Parse(xpath); err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+		}
+	}
+
+	// trees within the limit are walked without overflowing the stack
+	for _, xpath := range []string{
+		strings.Repeat("1+", DefaultMaxDepth-2) + "1",
+		strings.Repeat("(", DefaultMaxDepth-1) + "1" + strings.Repeat(")", DefaultMaxDepth-1),
+	} {
+		expr, err := Parse(xpath)
+		if err != nil {
+			t.Errorf("FAIL: %.20s: %v", xpath, err)
+			continue
+		}
+		if got, err := Parse(Format(expr)); err != nil || !Equal(got, expr) {
+			t.Errorf("FAIL: %.20s: formatted text does not parse back: %v", xpath, err)
+		}
+	}
+}
+
 func TestCompiledXPaths(t *testing.T) {
 	tests := map[string]Expr{
-		`1`:    Number(1),
-		`-1`:   &NegateExpr{Number(1)},
-		`1.5`:  Number(1.5),
-		`.5`:   Number(.5),
-		`01.5`: Number(1.5),
-		`1+2`:  &BinaryExpr{Number(1), Add, Number(2)},
-		`1-2`:  &BinaryExpr{Number(1), Subtract, Number(2)},
-		`1*2`:  &BinaryExpr{Number(1), Multiply, Number(2)},
+		`1`:    &Number{Value: 1},
+		`-1`:   &NegateExpr{Expr: &Number{Value: 1}},
+		`--1`:  &NegateExpr{Expr: &NegateExpr{Expr: &Number{Value: 1}}},
+		`1.5`:  &Number{Value: 1.5},
+		`.5`:   &Number{Value: .5},
+		`01.5`: &Number{Value: 1.5},
+		`1+2`:  &BinaryExpr{LHS: &Number{Value: 1}, Op: Add, RHS: &Number{Value: 2}},
+		`1-2`:  &BinaryExpr{LHS: &Number{Value: 1}, Op: Subtract, RHS: &Number{Value: 2}},
+		`1*2`:  &BinaryExpr{LHS: &Number{Value: 1}, Op: Multiply, RHS: &Number{Value: 2}},
 		`1+2*3`: &BinaryExpr{
-			Number(1),
-			Add,
-			&BinaryExpr{Number(2), Multiply, Number(3)},
+			LHS: &Number{Value: 1},
+			Op:  Add,
+			RHS: &BinaryExpr{LHS: &Number{Value: 2}, Op: Multiply, RHS: &Number{Value: 3}},
 		},
 		`(1+2)*3`: &BinaryExpr{
-			&BinaryExpr{Number(1), Add, Number(2)},
-			Multiply,
-			Number(3),
+			LHS: &BinaryExpr{LHS: &Number{Value: 1}, Op: Add, RHS: &Number{Value: 2}},
+			Op:  Multiply,
+			RHS: &Number{Value: 3},
 		},
-		`$var`:    &VarRef{"", "var"},
-		`$ns:var`: &VarRef{"ns", "var"},
-		`1=2`:     &BinaryExpr{Number(1), EQ, Number(2)},
-		`1!=2`:    &BinaryExpr{Number(1), NEQ, Number(2)},
-		`1 and 2`: &BinaryExpr{Number(1), And, Number(2)},
-		`1 or2`:   &BinaryExpr{Number(1), Or, Number(2)},
-		`1 mod2`:  &BinaryExpr{Number(1), Mod, Number(2)},
-		`1 div2`:  &BinaryExpr{Number(1), Div, Number(2)},
-		`1 <2`:    &BinaryExpr{Number(1), LT, Number(2)},
-		`1 <=2`:   &BinaryExpr{Number(1), LTE, Number(2)},
-		`1 >2`:    &BinaryExpr{Number(1), GT, Number(2)},
-		`1 >=2`:   &BinaryExpr{Number(1), GTE, Number(2)},
-		`"str"`:   String("str"),
-		`'str'`:   String("str"),
-		`/a`: &LocationPath{true, []*Step{
-			{Child, &NameTest{"", "a"}, nil},
+		`$var`:    &VarRef{Local: "var"},
+		`$ns:var`: &VarRef{Prefix: "ns", Local: "var"},
+		`1=2`:     &BinaryExpr{LHS: &Number{Value: 1}, Op: EQ, RHS: &Number{Value: 2}},
+		`1!=2`:    &BinaryExpr{LHS: &Number{Value: 1}, Op: NEQ, RHS: &Number{Value: 2}},
+		`1 and 2`: &BinaryExpr{LHS: &Number{Value: 1}, Op: And, RHS: &Number{Value: 2}},
+		`1 or2`:   &BinaryExpr{LHS: &Number{Value: 1}, Op: Or, RHS: &Number{Value: 2}},
+		`1 mod2`:  &BinaryExpr{LHS: &Number{Value: 1}, Op: Mod, RHS: &Number{Value: 2}},
+		`1 div2`:  &BinaryExpr{LHS: &Number{Value: 1}, Op: Div, RHS: &Number{Value: 2}},
+		`1 <2`:    &BinaryExpr{LHS: &Number{Value: 1}, Op: LT, RHS: &Number{Value: 2}},
+		`1 <=2`:   &BinaryExpr{LHS: &Number{Value: 1}, Op: LTE, RHS: &Number{Value: 2}},
+		`1 >2`:    &BinaryExpr{LHS: &Number{Value: 1}, Op: GT, RHS: &Number{Value: 2}},
+		`1 >=2`:   &BinaryExpr{LHS: &Number{Value: 1}, Op: GTE, RHS: &Number{Value: 2}},
+		`"str"`:   &String{Value: "str"},
+		`'str'`:   &String{Value: "str"},
+		`/a`: &LocationPath{Abs: true, Steps: []*Step{
+			{Axis: Child, NodeTest: &NameTest{Local: "a"}},
 		}},
 		`abc ander`: &BinaryExpr{
-			&LocationPath{false, []*Step{
-				{Child, &NameTest{"", "abc"}, nil},
+			LHS: &LocationPath{Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "abc"}},
 			}},
-			And,
-			&LocationPath{false, []*Step{
-				{Child, &NameTest{"", "er"}, nil},
+			Op: And,
+			RHS: &LocationPath{Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "er"}},
 			}},
 		},
 		`abc|er`: &BinaryExpr{
-			&LocationPath{false, []*Step{
-				{Child, &NameTest{"", "abc"}, nil},
+			LHS: &LocationPath{Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "abc"}},
 			}},
-			Union,
-			&LocationPath{false, []*Step{
-				{Child, &NameTest{"", "er"}, nil},
+			Op: Union,
+			RHS: &LocationPath{Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "er"}},
 			}},
 		},
-		`a[1]`: &LocationPath{false, []*Step{
-			{Child, &NameTest{"", "a"}, []Expr{Number(1)}},
+		`a[1]`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NameTest{Local: "a"}, Predicates: []Expr{&Number{Value: 1}}},
 		}},
-		`a[1][2]`: &LocationPath{false, []*Step{
-			{Child, &NameTest{"", "a"}, []Expr{Number(1), Number(2)}},
+		`a[1][2]`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NameTest{Local: "a"}, Predicates: []Expr{&Number{Value: 1}, &Number{Value: 2}}},
 		}},
-		`foo(1)`: &FuncCall{"", "foo", []Expr{
-			Number(1),
+		`foo(1)`: &FuncCall{Local: "foo", Args: []Expr{
+			&Number{Value: 1},
 		}},
-		`foo(1,2)`: &FuncCall{"", "foo", []Expr{
-			Number(1),
-			Number(2),
+		`foo(1,2)`: &FuncCall{Local: "foo", Args: []Expr{
+			&Number{Value: 1},
+			&Number{Value: 2},
 		}},
-		`foo(1, ns:bar(2), /a)`: &FuncCall{"", "foo", []Expr{
-			Number(1),
-			&FuncCall{"ns", "bar", []Expr{
-				Number(2),
+		`foo(1, ns:bar(2), /a)`: &FuncCall{Local: "foo", Args: []Expr{
+			&Number{Value: 1},
+			&FuncCall{Prefix: "ns", Local: "bar", Args: []Expr{
+				&Number{Value: 2},
 			}},
-			&LocationPath{true, []*Step{
-				{Child, &NameTest{"", "a"}, nil},
+			&LocationPath{Abs: true, Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "a"}},
 			}},
 		}},
-		`.`: &LocationPath{false, []*Step{
-			{Self, Node, nil},
+		`.`: &LocationPath{Steps: []*Step{
+			{Axis: Self, NodeTest: &NodeTypeTest{Type: Node}},
 		}},
-		`..`: &LocationPath{false, []*Step{
-			{Parent, Node, nil},
+		`..`: &LocationPath{Steps: []*Step{
+			{Axis: Parent, NodeTest: &NodeTypeTest{Type: Node}},
 		}},
 		`(/a/b)[5]`: &FilterExpr{
-			&LocationPath{true, []*Step{
-				{Child, &NameTest{"", "a"}, nil},
-				{Child, &NameTest{"", "b"}, nil},
+			Expr: &LocationPath{Abs: true, Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "a"}},
+				{Axis: Child, NodeTest: &NameTest{Local: "b"}},
 			}},
-			[]Expr{Number(5)},
+			Predicates: []Expr{&Number{Value: 5}},
 		},
 		`(/a/b)/c`: &PathExpr{
-			&LocationPath{true, []*Step{
-				{Child, &NameTest{"", "a"}, nil},
-				{Child, &NameTest{"", "b"}, nil},
+			Filter: &LocationPath{Abs: true, Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "a"}},
+				{Axis: Child, NodeTest: &NameTest{Local: "b"}},
 			}},
-			&LocationPath{false, []*Step{
-				{Child, &NameTest{"", "c"}, nil},
+			LocationPath: &LocationPath{Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "c"}},
 			}},
 		},
-		`a//b`: &LocationPath{false, []*Step{
-			{Child, &NameTest{"", "a"}, nil},
-			{DescendantOrSelf, Node, nil},
-			{Child, &NameTest{"", "b"}, nil},
+		`a//b`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NameTest{Local: "a"}},
+			{Axis: DescendantOrSelf, NodeTest: &NodeTypeTest{Type: Node}},
+			{Axis: Child, NodeTest: &NameTest{Local: "b"}},
 		}},
-		`//emp`: &LocationPath{true, []*Step{
-			{DescendantOrSelf, Node, nil},
-			{Child, &NameTest{"", "emp"}, nil},
+		`//emp`: &LocationPath{Abs: true, Steps: []*Step{
+			{Axis: DescendantOrSelf, NodeTest: &NodeTypeTest{Type: Node}},
+			{Axis: Child, NodeTest: &NameTest{Local: "emp"}},
 		}},
-		`*//emp`: &LocationPath{false, []*Step{
-			{Child, &NameTest{"", "*"}, nil},
-			{DescendantOrSelf, Node, nil},
-			{Child, &NameTest{"", "emp"}, nil},
+		`*//emp`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NameTest{Local: "*"}},
+			{Axis: DescendantOrSelf, NodeTest: &NodeTypeTest{Type: Node}},
+			{Axis: Child, NodeTest: &NameTest{Local: "emp"}},
 		}},
-		`processing-instruction('xsl')`: &LocationPath{false, []*Step{
-			{Child, PITest("xsl"), nil},
+		`processing-instruction('xsl')`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &PITest{Target: "xsl"}},
 		}},
-		`node()`: &LocationPath{false, []*Step{
-			{Child, Node, nil},
+		`node()`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NodeTypeTest{Type: Node}},
 		}},
-		`text()`: &LocationPath{false, []*Step{
-			{Child, Text, nil},
+		`text()`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NodeTypeTest{Type: Text}},
 		}},
-		`comment()`: &LocationPath{false, []*Step{
-			{Child, Comment, nil},
+		`comment()`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NodeTypeTest{Type: Comment}},
 		}},
-		`ns1:emp`: &LocationPath{false, []*Step{
-			{Child, &NameTest{"ns1", "emp"}, nil},
+		`ns1:emp`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NameTest{Prefix: "ns1", Local: "emp"}},
 		}},
-		`a:`: &LocationPath{false, []*Step{
-			{Child, &NameTest{"a", ""}, nil},
+		`a:`: &LocationPath{Steps: []*Step{
+			{Axis: Child, NodeTest: &NameTest{Prefix: "a"}},
 		}},
 		`document('test.xml')/*`: &PathExpr{
-			&FuncCall{"", "document", []Expr{
-				String("test.xml"),
+			Filter: &FuncCall{Local: "document", Args: []Expr{
+				&String{Value: "test.xml"},
 			}},
-			&LocationPath{false, []*Step{
-				{Child, &NameTest{"", "*"}, nil},
+			LocationPath: &LocationPath{Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "*"}},
 			}},
 		},
-		`//book[author = editor]/price`: &LocationPath{true, []*Step{
-			{DescendantOrSelf, Node, nil},
-			{Child, &NameTest{"", "book"}, []Expr{
+		`//book[author = editor]/price`: &LocationPath{Abs: true, Steps: []*Step{
+			{Axis: DescendantOrSelf, NodeTest: &NodeTypeTest{Type: Node}},
+			{Axis: Child, NodeTest: &NameTest{Local: "book"}, Predicates: []Expr{
 				&BinaryExpr{
-					&LocationPath{false, []*Step{
-						{Child, &NameTest{"", "author"}, nil},
+					LHS: &LocationPath{Steps: []*Step{
+						{Axis: Child, NodeTest: &NameTest{Local: "author"}},
 					}},
-					EQ,
-					&LocationPath{false, []*Step{
-						{Child, &NameTest{"", "editor"}, nil},
+					Op: EQ,
+					RHS: &LocationPath{Steps: []*Step{
+						{Axis: Child, NodeTest: &NameTest{Local: "editor"}},
 					}},
 				},
 			}},
-			{Child, &NameTest{"", "price"}, nil},
+			{Axis: Child, NodeTest: &NameTest{Local: "price"}},
 		}},
 		`(a)//b`: &PathExpr{
-			&LocationPath{false, []*Step{
-				{Child, &NameTest{"", "a"}, nil},
+			Filter: &LocationPath{Steps: []*Step{
+				{Axis: Child, NodeTest: &NameTest{Local: "a"}},
 			}},
-			&LocationPath{false, []*Step{
-				{DescendantOrSelf, Node, nil},
-				{Child, &NameTest{"", "b"}, nil},
+			LocationPath: &LocationPath{Steps: []*Step{
+				{Axis: DescendantOrSelf, NodeTest: &NodeTypeTest{Type: Node}},
+				{Axis: Child, NodeTest: &NameTest{Local: "b"}},
 			}},
 		},
 		`(.)/`: &PathExpr{
-			&LocationPath{false, []*Step{
-				{Self, Node, nil},
+			Filter: &LocationPath{Steps: []*Step{
+				{Axis: Self, NodeTest: &NodeTypeTest{Type: Node}},
 			}},
-			&LocationPath{false, nil},
+			LocationPath: &LocationPath{},
 		},
 	}
 	for k, v := range tests {
@@ -253,11 +375,21 @@ func equals(v1, v2 interface{}) bool {
 	switch v1 := v1.(type) {
 	case nil:
 		return v2 == nil
-	case Number, String, NodeType, PITest:
-		return v1 == v2
+	case *NodeTypeTest:
+		v2, ok := v2.(*NodeTypeTest)
+		return ok && v1.Type == v2.Type
+	case *PITest:
+		v2, ok := v2.(*PITest)
+		return ok && v1.Target == v2.Target
+	case *Number:
+		v2, ok := v2.(*Number)
+		return ok && v1.Value == v2.Value
+	case *String:
+		v2, ok := v2.(*String)
+		return ok && v1.Value == v2.Value
 	case *VarRef:
 		v2, ok := v2.(*VarRef)
-		return ok && *v1 == *v2
+		return ok && v1.Prefix == v2.Prefix && v1.Local == v2.Local
 	case *NegateExpr:
 		v2, ok := v2.(*NegateExpr)
 		return ok && equals(v1.Expr, v2.Expr)
@@ -280,7 +412,7 @@ func equals(v1, v2 interface{}) bool {
 		return ok && v1.Axis == v2.Axis && equals(v1.NodeTest, v2.NodeTest) && equals(v1.Predicates, v2.Predicates)
 	case *NameTest:
 		v2, ok := v2.(*NameTest)
-		return ok && *v1 == *v2
+		return ok && v1.Prefix == v2.Prefix && v1.Local == v2.Local
 	case []Expr:
 		v2, ok := v2.([]Expr)
 		if !ok || len(v1) != len(v2) {
@@ -305,3 +437,546 @@ func equals(v1, v2 interface{}) bool {
 		panic(fmt.Sprintf("equals for %T not implemented yet", v1))
 	}
 }
+
+func TestExprKind(t *testing.T) {
+	tests := map[string]ExprKind{
+		`/a`:      KindLocationPath,
+		`$a[1]`:   KindFilterExpr,
+		`$a/b`:    KindPathExpr,
+		`1+2`:     KindBinaryExpr,
+		`-1`:      KindNegateExpr,
+		`$a`:      KindVarRef,
+		`f()`:     KindFuncCall,
+		`1`:       KindNumber,
+		`'one'`:   KindString,
+		`(a)//b`:  KindPathExpr,
+		`a|b`:     KindBinaryExpr,
+		`(/a)[1]`: KindFilterExpr,
+	}
+	for xpath, kind := range tests {
+		expr, err := Parse(xpath)
+		if err != nil {
+			t.Errorf("FAIL: %v", err)
+			continue
+		}
+		if expr.Kind() != kind {
+			t.Errorf("FAIL: kind of %s: got %v, want %v", xpath, expr.Kind(), kind)
+		}
+	}
+}
+
+func TestNodeTestKind(t *testing.T) {
+	tests := map[string]NodeTestKind{
+		`a`:                          KindNameTest,
+		`@*`:                         KindNameTest,
+		`node()`:                     KindNodeTypeTest,
+		`..`:                         KindNodeTypeTest,
+		`processing-instruction(pi)`: KindPITest,
+		`element(a)`:                 KindKindTest,
+	}
+	options := &ParseOptions{Version: XPath20}
+	for xpath, kind := range tests {
+		expr, err := options.Parse(xpath)
+		if err != nil {
+			t.Errorf("FAIL: %v", err)
+			continue
+		}
+		nodeTest := expr.(*LocationPath).Steps[0].NodeTest
+		if nodeTest.Kind() != kind {
+			t.Errorf("FAIL: kind of %s: got %v, want %v", xpath, nodeTest.Kind(), kind)
+		}
+	}
+}
+
+func TestPrecedence(t *testing.T) {
+	tests := []struct {
+		xpath string
+		want  string
+	}{
+		// or
+		{`a or b or c`, `(a or b) or c`},
+		{`a or b and c`, `a or (b and c)`},
+		{`a and b or c`, `(a and b) or c`},
+		// and
+		{`a and b and c`, `(a and b) and c`},
+		{`a and b = c`, `a and (b = c)`},
+		{`a = b and c`, `(a = b) and c`},
+		// equality
+		{`a = b != c`, `(a = b) != c`},
+		{`a = b < c`, `a = (b < c)`},
+		{`a < b = c`, `(a < b) = c`},
+		// relational
+		{`a < b <= c > d >= e`, `(((a < b) <= c) > d) >= e`},
+		{`a < b + c`, `a < (b + c)`},
+		{`a + b < c`, `(a + b) < c`},
+		// additive
+		{`1 - 2 - 3`, `(1 - 2) - 3`},
+		{`1 - 2 + 3`, `(1 - 2) + 3`},
+		{`1 + 2 * 3`, `1 + (2 * 3)`},
+		{`1 * 2 + 3`, `(1 * 2) + 3`},
+		// multiplicative
+		{`1 div 2 mod 3 * 4`, `((1 div 2) mod 3) * 4`},
+		{`-1 * 2`, `(-1) * 2`},
+		{`1 * -2`, `1 * (-2)`},
+		// unary
+		{`-a | b`, `-(a | b)`},
+		{`-a = b`, `(-a) = b`},
+		{`--1`, `-(-1)`},
+		{`- - $x`, `-(-$x)`},
+		{`1 - -1`, `1 - (-1)`},
+		{`---a | b`, `-(-(-(a | b)))`},
+		// union
+		{`a | b | c`, `(a | b) | c`},
+		{`a | b = c`, `(a | b) = c`},
+		{`a = b | c`, `a = (b | c)`},
+		{`a | b and c`, `(a | b) and c`},
+		{`a | b or c | d`, `(a | b) or (c | d)`},
+		{`a | b + 1`, `(a | b) + 1`},
+		{`a | b * c`, `(a | b) * c`},
+		{`a | b[1] | c`, `(a | b[1]) | c`},
+		{`(a | b)[1] | c`, `(a | b)[1] | c`},
+		{`a | b/c | d`, `(a | b/c) | d`},
+		{`f(a | b, c)`, `f(a | b, c)`},
+	}
+	config := &PrintConfig{Mode: Abbreviate}
+	for _, test := range tests {
+		expr, err := Parse(test.xpath)
+		if err != nil {
+			t.Errorf("FAIL: %v", err)
+			continue
+		}
+		if got := config.Format(expr); got != test.want {
+			t.Errorf("FAIL: %s: got %s, want %s", test.xpath, got, test.want)
+		}
+	}
+}
+
+func TestXPath20(t *testing.T) {
+	precedence := []struct {
+		xpath string
+		want  string
+	}{
+		{`for $x in a, $y in b return $x + $y`, `for $x in a return for $y in b return $x + $y`},
+		{`some $x in a, $y in b satisfies c`, `some $x in a satisfies some $y in b satisfies c`},
+		{`if (a) then b else c or d`, `if (a) then b else c or d`},
+		{`(for $x in a return b) or c`, `(for $x in a return b) or c`},
+		{`a = b or c < d`, `(a = b) or (c < d)`},
+		{`1 to 2 + 3`, `1 to (2 + 3)`},
+		{`a < 1 to 2`, `a < (1 to 2)`},
+		{`-a | b`, `(-a) | b`},
+		{`-a * b | c`, `(-a) * (b | c)`},
+		{`(a, b)[1]`, `(a, b)[1]`},
+		{`a[1, 2]`, `a[(1, 2)]`},
+		{`-a cast as xs:int`, `(-a) cast as xs:int`},
+		{`a instance of xs:integer* + 1`, `(a instance of xs:integer*) + 1`},
+		{`a cast as xs:int castable as xs:int treat as item() instance of item()`,
+			`(((a cast as xs:int) castable as xs:int) treat as item()) instance of item()`},
+		{`a instance of item() and b`, `(a instance of item()) and b`},
+		{`a | b instance of item()`, `a | (b instance of item())`},
+		{`a eq b and c << d`, `(a eq b) and (c << d)`},
+		{`a is b | c`, `a is (b | c)`},
+		{`1 + 2 lt 3 idiv 4`, `(1 + 2) lt (3 idiv 4)`},
+		{`a | b intersect c except d`, `a | ((b intersect c) except d)`},
+		{`a except b instance of item()`, `a except (b instance of item())`},
+		{`a union b | c intersect d`, `(a | b) | (c intersect d)`},
+		{`union union union`, `union | union`},
+	}
+	options := &ParseOptions{Version: XPath20}
+	config := &PrintConfig{Mode: Abbreviate, Version: XPath20}
+	for _, test := range precedence {
+		expr, err := options.Parse(test.xpath)
+		if err != nil {
+			t.Errorf("FAIL: %v", err)
+			continue
+		}
+		if got := config.Format(expr); got != test.want {
+			t.Errorf("FAIL: %s: got %s, want %s", test.xpath, got, test.want)
+		}
+	}
+
+	invalid := []string{
+		`a = b = c`,
+		`a < b != c`,
+		`1 to 2 to 3`,
+		`for $x in a`,
+		`for $x in a return`,
+		`for x in a return x`,
+		`for $x a return $x`,
+		`some $x in a`,
+		`every $x in a return $x`,
+		`if (a) then b`,
+		`if a then b else c`,
+		`if (a) b else c`,
+		`if(a)`,
+		`item()`,
+		`(a, )`,
+		`a[]`,
+		`a instance xs:integer`,
+		`a instance of`,
+		`a instance of xs:integer + 1`,
+		`a instance of empty-sequence()*`,
+		`a instance of foo()`,
+		`a instance of element(a, b, c)`,
+		`a instance of document-node(attribute())`,
+		`a instance of schema-element()`,
+		`a cast as xs:int*`,
+		`a cast as item()`,
+		`a cast as xs:int cast as xs:string`,
+		`a treat item()`,
+		`element(a)(1)`,
+		`item()`,
+		`a eq b eq c`,
+		`a is b = c`,
+		`a << b >> c`,
+		`a intersect`,
+		`idiv 2`,
+		`(: unclosed`,
+		`1 (: a (: b :) + 2`,
+		`'a''`,
+		`1e`,
+		`1e+`,
+		`a/-1`,
+		`a/f()[`,
+		`a/f()/@`,
+		`$a/`,
+		`$a//`,
+		`* :a`,
+		`*: a`,
+		`*:*`,
+		`a/*:`,
+		`$a, $b/`,
+		`(), (0)/`,
+	}
+	for _, xpath := range invalid {
+		if _, err := options.Parse(xpath); err == nil {
+			t.Errorf("FAIL: error expected for %s", xpath)
+		}
+	}
+
+	// XPath 1.0 must not accept XPath 2.0 syntax
+	for _, xpath := range []string{`for $x in a return $x`, `some $x in a satisfies b`, `if (a) then b else c`, `1 to 2`, `(1, 2)`, `()`, `a[1, 2]`,
+		`a instance of b`, `a cast as b`, `a castable as b?`, `a/element(b)`, `processing-instruction(pi)`,
+		`a eq b`, `a is b`, `a << b`, `a >> b`, `7 idiv 2`, `a intersect b`, `a except b`,
+		`'it''s'`, `1e3`, `(: c :) 1`, `+1`, `a/string()`, `1/a`, `$x/f()`, `/f()`, `a union b`, `*:a`} {
+		if _, err := Parse(xpath); err == nil {
+			t.Errorf("FAIL: error expected in XPath 1.0 for %s", xpath)
+		}
+	}
+
+	// keywords are names in XPath 1.0
+	for _, xpath := range []string{`for`, `to/in`, `if`, `return[then]`, `else | satisfies`, `eq/is`, `idiv[except]`, `intersect`, `union/a`} {
+		if _, err := options.Parse(xpath); err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+		}
+		if _, err := Parse(xpath); err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+		}
+	}
+}
+
+func TestXPath30(t *testing.T) {
+	precedence := []struct {
+		xpath string
+		want  string
+	}{
+		{`let $x := a, $y := b return $x`, `let $x := a return let $y := b return $x`},
+		{`let $x := a return $x or b`, `let $x := a return $x or b`},
+		{`a || b || c`, `(a || b) || c`},
+		{`a || b = c`, `(a || b) = c`},
+		{`a = b || c`, `a = (b || c)`},
+		{`a || 1 to 2`, `a || (1 to 2)`},
+		{`a ! b ! c`, `(a ! b) ! c`},
+		{`-a ! b`, `-(a ! b)`},
+		{`a ! b | c`, `(a ! b) | c`},
+		{`a ! b/c`, `a ! b/c`},
+		{`$f(1)(2)`, `$f(1)(2)`},
+		{`function($a) {$a, 1}`, `function($a) {($a, 1)}`},
+		{`$f instance of function() as xs:int*`, `$f instance of function() as xs:int*`},
+		{`$f instance of (function() as xs:int)*`, `$f instance of (function() as xs:int)*`},
+	}
+	options := &ParseOptions{Version: XPath30}
+	config := &PrintConfig{Mode: Abbreviate, Version: XPath30}
+	for _, test := range precedence {
+		expr, err := options.Parse(test.xpath)
+		if err != nil {
+			t.Errorf("FAIL: %v", err)
+			continue
+		}
+		if got := config.Format(expr); got != test.want {
+			t.Errorf("FAIL: %s: got %s, want %s", test.xpath, got, test.want)
+		}
+	}
+
+	invalid := []string{
+		`let $x = 1 return $x`,
+		`let $x := 1`,
+		`let x := 1 return x`,
+		`function($a,) {1}`,
+		`function() {}`,
+		`function(a) {1}`,
+		`function($a) 1`,
+		`function($a) {1`,
+		`f#1.5`,
+		`f#`,
+		`f#a`,
+		`function#1`,
+		`a !`,
+		`! a`,
+		`a ||`,
+		`a | | b`,
+		`}`,
+		`$f instance of function(*`,
+		`$f instance of function(*, xs:int) as item()`,
+		`$f instance of function(xs:int)`,
+		`$f instance of function() as`,
+		`$f instance of function(xs:int,) as item()`,
+		`$f instance of (function(*)`,
+		`$f instance of ()`,
+		`$f cast as function(*)`,
+		`namespace-node(a)`,
+		`$n instance of namespace-node(*)`,
+		`document-node(namespace-node())`,
+		`Q{u`,
+		`Q{u{}a`,
+		`Q{u} a`,
+		`Q{u}1`,
+		`$Q{u}*`,
+		`Q{u}*(1)`,
+		`a:Q{u}b`,
+		`Q{u}a:b`,
+		`processing-instruction(Q{u}a)`,
+	}
+	for _, xpath := range invalid {
+		if _, err := options.Parse(xpath); err == nil {
+			t.Errorf("FAIL: error expected for %s", xpath)
+		}
+	}
+
+	// XPath 2.0 must not accept XPath 3.0 syntax
+	v20 := &ParseOptions{Version: XPath20}
+	for _, xpath := range []string{`let $x := 1 return $x`, `function($a) {$a}`, `f#1`, `$f(1)`, `f(1)(2)`, `a ! b`, `a || b`,
+		`$f instance of function(*)`, `$f instance of (item())`, `$n instance of namespace-node()`,
+		`Q{u}a`} {
+		if _, err := v20.Parse(xpath); err == nil {
+			t.Errorf("FAIL: error expected in XPath 2.0 for %s", xpath)
+		}
+	}
+
+	// let, function and namespace-node are names in XPath 2.0
+	for _, xpath := range []string{`let`, `let/function`, `function[let]`, `namespace-node()`} {
+		if _, err := options.Parse(xpath); err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+		}
+		if _, err := v20.Parse(xpath); err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+		}
+	}
+}
+
+func TestXPath31(t *testing.T) {
+	precedence := []struct {
+		xpath string
+		want  string
+	}{
+		{`a => f() => g()`, `(a => f()) => g()`},
+		{`-a => f()`, `(-a) => f()`},
+		{`a => f() + 1`, `(a => f()) + 1`},
+		{`a ! b => f()`, `(a ! b) => f()`},
+		{`a => f() cast as xs:int`, `(a => f()) cast as xs:int`},
+		{`$m?a?b`, `$m?a?b`},
+		{`$m?a[1]`, `$m?a[1]`},
+		{`map {"a": 1 + 2}`, `map {"a": 1 + 2}`},
+		{`[1 + 2, 3]`, `[1 + 2, 3]`},
+	}
+	options := &ParseOptions{Version: XPath31}
+	config := &PrintConfig{Mode: Abbreviate, Version: XPath31}
+	for _, test := range precedence {
+		expr, err := options.Parse(test.xpath)
+		if err != nil {
+			t.Errorf("FAIL: %v", err)
+			continue
+		}
+		if got := config.Format(expr); got != test.want {
+			t.Errorf("FAIL: %s: got %s, want %s", test.xpath, got, test.want)
+		}
+	}
+
+	invalid := []string{
+		`map {`,
+		`map {"a"}`,
+		`map {"a": }`,
+		`map {"a": 1,}`,
+		`map {"a" 1}`,
+		`[1,]`,
+		`[1`,
+		`array {1,}`,
+		`array {1`,
+		`$m?`,
+		`$m?1.5`,
+		`$m?-1`,
+		`$m?"a"`,
+		`a?b`,
+		`a =>`,
+		`a => f`,
+		`a => 1()`,
+		`a => f#1()`,
+		`$m?Q{u}a`,
+		`a => if()`,
+		`map(1)`,
+		`array(1)`,
+		`$m instance of map(item(), item())`,
+		`$m instance of map(xs:string)`,
+		`$m instance of map(*, item())`,
+		`$m instance of map(xs:string, item()`,
+		`$a instance of array()`,
+		`$a instance of array(*`,
+		`$a instance of array(xs:int, xs:int)`,
+	}
+	for _, xpath := range invalid {
+		if _, err := options.Parse(xpath); err == nil {
+			t.Errorf("FAIL: error expected for %s", xpath)
+		}
+	}
+
+	// XPath 3.0 must not accept XPath 3.1 syntax
+	v30 := &ParseOptions{Version: XPath30}
+	for _, xpath := range []string{`map {"a": 1}`, `[1, 2]`, `array {1}`, `$m?a`, `a[?b]`, `a => f()`, `function() {}`,
+		`$m instance of map(*)`, `$a instance of array(*)`} {
+		if _, err := v30.Parse(xpath); err == nil {
+			t.Errorf("FAIL: error expected in XPath 3.0 for %s", xpath)
+		}
+	}
+
+	// map and array are names, and QNames in XPath 3.1 have no whitespace
+	for _, xpath := range []string{`map`, `map/array`, `array[map]`, `ns:map`, `ns:a`} {
+		if _, err := options.Parse(xpath); err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+		}
+		if _, err := v30.Parse(xpath); err != nil {
+			t.Errorf("FAIL: %s: %v", xpath, err)
+		}
+	}
+	for _, xpath := range []string{`ns :a`, `ns: a`, `$ns : a`} {
+		if _, err := options.Parse(xpath); err == nil {
+			t.Errorf("FAIL: error expected for %s", xpath)
+		}
+	}
+}
+
+func TestSpans(t *testing.T) {
+	xpath := "foo(a//b,\n  @x[1] = 'v', (-$y)[2]/..)"
+	expr, err := Parse(xpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text := func(s Span) string {
+		return xpath[s.Start.Offset:s.End.Offset]
+	}
+	fc := expr.(*FuncCall)
+	arg0 := fc.Args[0].(*LocationPath)
+	arg1 := fc.Args[1].(*BinaryExpr)
+	arg2 := fc.Args[2].(*PathExpr)
+	step := arg1.LHS.(*LocationPath).Steps[0]
+	filter := arg2.Filter.(*FilterExpr)
+	tests := []struct {
+		span Span
+		text string
+	}{
+		{fc.Span, xpath},
+		{arg0.Span, "a//b"},
+		{arg0.Steps[0].Span, "a"},
+		{arg0.Steps[0].NodeTest.(*NameTest).Span, "a"},
+		{arg0.Steps[1].Span, "//"},
+		{arg0.Steps[1].NodeTest.(*NodeTypeTest).Span, "//"},
+		{arg0.Steps[2].Span, "b"},
+		{arg1.Span, "@x[1] = 'v'"},
+		{step.Span, "@x[1]"},
+		{step.NodeTest.(*NameTest).Span, "x"},
+		{arg2.Span, "(-$y)[2]/.."},
+		{filter.Span, "(-$y)[2]"},
+		{filter.Expr.(*NegateExpr).Span, "-$y"},
+		{filter.Expr.(*NegateExpr).Expr.(*VarRef).Span, "$y"},
+		{arg2.LocationPath.Span, "/.."},
+		{arg2.LocationPath.Steps[0].Span, ".."},
+		{arg2.LocationPath.Steps[0].NodeTest.(*NodeTypeTest).Span, ".."},
+		{step.Predicates[0].(*Number).Span, "1"},
+		{arg1.RHS.(*String).Span, "'v'"},
+		{filter.Predicates[0].(*Number).Span, "2"},
+	}
+	for _, test := range tests {
+		if got := text(test.span); got != test.text {
+			t.Errorf("FAIL: got %q, want %q", got, test.text)
+		}
+	}
+	if got, want := arg1.Span.Start, (Pos{Offset: 12, Line: 2, Column: 3}); got != want {
+		t.Errorf("FAIL: got %#v, want %#v", got, want)
+	}
+	if got, want := fc.Span.End, (Pos{Offset: len(xpath), Line: 2, Column: 28}); got != want {
+		t.Errorf("FAIL: got %#v, want %#v", got, want)
+	}
+
+	xpath = `$m?key?2`
+	expr, err = (&ParseOptions{Version: XPath31}).Parse(xpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	outer := expr.(*LookupExpr)
+	inner := outer.Expr.(*LookupExpr)
+	if got := text(inner.Key.(*String).Span); got != "key" {
+		t.Errorf("FAIL: got %q, want %q", got, "key")
+	}
+	if got := text(outer.Key.(*Number).Span); got != "2" {
+		t.Errorf("FAIL: got %q, want %q", got, "2")
+	}
+
+	xpath = `function() { }`
+	expr, err = (&ParseOptions{Version: XPath31}).Parse(xpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := text(expr.(*InlineFunctionExpr).Body.(*SequenceExpr).Span); got != "{ }" {
+		t.Errorf("FAIL: got %q, want %q", got, "{ }")
+	}
+
+	xpath = `text()[. instance of item()]/processing-instruction(pi)`
+	expr, err = (&ParseOptions{Version: XPath20}).Parse(xpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := expr.(*LocationPath).Steps
+	instanceOf := steps[0].Predicates[0].(*InstanceOfExpr)
+	for _, test := range []struct {
+		span Span
+		text string
+	}{
+		{steps[0].NodeTest.(*NodeTypeTest).Span, "text()"},
+		{instanceOf.Type.ItemType.(*AnyItem).Span, "item()"},
+		{steps[1].NodeTest.(*PITest).Span, "processing-instruction(pi)"},
+	} {
+		if got := text(test.span); got != test.text {
+			t.Errorf("FAIL: got %q, want %q", got, test.text)
+		}
+	}
+
+	xpath = `a//b/string()/c`
+	expr, err = (&ParseOptions{Version: XPath20}).Parse(xpath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := expr.(*PathExpr)
+	slash := path.Filter.(*SlashExpr)
+	for _, test := range []struct {
+		span Span
+		text string
+	}{
+		{path.Span, xpath},
+		{path.LocationPath.Span, "/c"},
+		{slash.Span, "a//b/string()"},
+		{slash.LHS.(*LocationPath).Span, "a//b"},
+		{slash.RHS.(*FuncCall).Span, "string()"},
+	} {
+		if got := text(test.span); got != test.text {
+			t.Errorf("FAIL: got %q, want %q", got, test.text)
+		}
+	}
+}
@@ -0,0 +1,1530 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// XQueryXNamespace is the namespace of XQueryX vocabulary.
+const XQueryXNamespace = "http://www.w3.org/2005/XQueryX"
+
+var op2XQueryX = map[Op]string{
+	EQ:       "equalOp",
+	NEQ:      "notEqualOp",
+	LT:       "lessThanOp",
+	LTE:      "lessThanOrEqualOp",
+	GT:       "greaterThanOp",
+	GTE:      "greaterThanOrEqualOp",
+	Add:      "addOp",
+	Subtract: "subtractOp",
+	Multiply: "multiplyOp",
+	Mod:      "modOp",
+	Div:      "divOp",
+	And:      "andOp",
+	Or:       "orOp",
+	Union:    "unionOp",
+
+	ValueEQ:   "eqOp",
+	ValueNE:   "neOp",
+	ValueLT:   "ltOp",
+	ValueLE:   "leOp",
+	ValueGT:   "gtOp",
+	ValueGE:   "geOp",
+	Is:        "isOp",
+	Precedes:  "nodeBeforeOp",
+	Follows:   "nodeAfterOp",
+	IDiv:      "idivOp",
+	Intersect: "intersectOp",
+	Except:    "exceptOp",
+	Concat:    "stringConcatenateOp",
+}
+
+var xqueryX2Op = make(map[string]Op)
+
+func init() {
+	for op, name := range op2XQueryX {
+		xqueryX2Op[name] = op
+	}
+}
+
+// EncodeXQueryX returns expr as XQueryX document, as specified in
+// https://www.w3.org/TR/xqueryx/. The expression is the queryBody
+// of the mainModule.
+//
+// The document uses the XQueryX vocabulary for the XPath 1.0 subset:
+// location paths are pathExpr elements with optional rootExpr followed by
+// stepExpr elements holding xpathAxis, nameTest, Wildcard or kind test and
+// predicates, where Wildcard holds star before NCName for *:local of
+// XPath 2.0; filter expressions are stepExpr elements holding filterExpr;
+// operators use the corresponding elements such as addOp, unionOp or
+// unaryMinusOp. Expressions of XPath 2.0 use flworExpr with single forClause,
+// quantifiedExpr, ifThenElseExpr, sequenceExpr, rangeSequenceExpr, unaryPlusOp,
+// stepExpr holding filterExpr following other steps of pathExpr, and
+// instanceOfExpr, treatExpr, castableExpr and castExpr holding sequenceType
+// or singleType. Expressions of XPath 3.0 use flworExpr with single
+// letClause, inlineFunctionExpr, namedFunctionRef,
+// dynamicFunctionInvocationExpr, simpleMapExpr and stringConcatenateOp;
+// function tests are anyFunctionTest or typedFunctionTest, and
+// namespace-node() is namespaceTest. The braced URI of EQName is written
+// as xqx:URI attribute, and as xqx:uri element of Wildcard.
+// Expressions of XPath 3.1 use mapConstructor, arrayConstructor holding
+// squareArray or curlyArray, arrowExpr, and lookupExpr and unaryLookup
+// holding the key as NCName, integerConstantExpr, parenthesizedExpr or star;
+// map and array tests are anyMapTest, typedMapTest, anyArrayTest or
+// typedArrayTest.
+//
+// DecodeXQueryX(EncodeXQueryX(expr)) is Equal to expr for any expr returned
+// by Parse.
+//
+// BadExpr has no XQueryX equivalent; an expr containing it is an error.
+// So is a string or name containing a character that XML does not allow,
+// such as U+0000.
+func EncodeXQueryX(expr Expr) ([]byte, error) {
+	var bad *BadExpr
+	Inspect(expr, func(n TreeNode) bool {
+		if b, ok := n.(*BadExpr); ok {
+			bad = b
+		}
+		return bad == nil
+	})
+	if bad != nil {
+		return nil, fmt.Errorf("xpathparser: BadExpr at %v cannot be encoded as xqueryx", bad.Span)
+	}
+	buf := new(bytes.Buffer)
+	e := &xqueryXEncoder{Encoder: xml.NewEncoder(buf)}
+	e.Indent("", "  ")
+	e.start("module", xml.Attr{Name: xml.Name{Local: "xmlns:xqx"}, Value: XQueryXNamespace})
+	e.start("mainModule")
+	e.start("queryBody")
+	e.expr(expr)
+	e.end("queryBody")
+	e.end("mainModule")
+	e.end("module")
+	if e.err != nil {
+		return nil, e.err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+type xqueryXEncoder struct {
+	*xml.Encoder
+	err error // first error encountered
+}
+
+// encode encodes token t, unless an error is already encountered.
+func (e *xqueryXEncoder) encode(t xml.Token) {
+	if e.err == nil {
+		e.err = e.EncodeToken(t)
+	}
+}
+
+// check records error, if s contains a character that is not allowed
+// in XML. The xml.Encoder would silently replace it with U+FFFD.
+func (e *xqueryXEncoder) check(s string) {
+	if e.err != nil {
+		return
+	}
+	for i, r := range s {
+		if r == utf8.RuneError {
+			if _, size := utf8.DecodeRuneInString(s[i:]); size == 1 {
+				e.err = fmt.Errorf("xpathparser: invalid utf-8 in %q cannot be encoded as xqueryx", s)
+				return
+			}
+		} else if !isXMLChar(r) {
+			e.err = fmt.Errorf("xpathparser: character %U in %q cannot be encoded as xqueryx", r, s)
+			return
+		}
+	}
+}
+
+// isXMLChar tells whether r matches production Char of XML 1.0.
+func isXMLChar(r rune) bool {
+	return r == '\t' || r == '\n' || r == '\r' ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+func (e *xqueryXEncoder) start(name string, attr ...xml.Attr) {
+	for _, a := range attr {
+		e.check(a.Value)
+	}
+	e.encode(xml.StartElement{Name: xml.Name{Local: "xqx:" + name}, Attr: attr})
+}
+
+func (e *xqueryXEncoder) end(name string) {
+	e.encode(xml.EndElement{Name: xml.Name{Local: "xqx:" + name}})
+}
+
+func (e *xqueryXEncoder) empty(name string) {
+	e.start(name)
+	e.end(name)
+}
+
+func (e *xqueryXEncoder) text(name, text string, attr ...xml.Attr) {
+	e.start(name, attr...)
+	e.check(text)
+	e.encode(xml.CharData(text))
+	e.end(name)
+}
+
+func (e *xqueryXEncoder) qname(name, prefix, local string) {
+	switch {
+	case prefix == "":
+		e.text(name, local)
+	case isBracedURI(prefix):
+		e.text(name, local, xml.Attr{Name: xml.Name{Local: "xqx:URI"}, Value: prefix[2 : len(prefix)-1]})
+	default:
+		e.text(name, local, xml.Attr{Name: xml.Name{Local: "xqx:prefix"}, Value: prefix})
+	}
+}
+
+func (e *xqueryXEncoder) expr(expr Expr) {
+	switch ex := expr.(type) {
+	case *LocationPath, *FilterExpr, *PathExpr, *SlashExpr:
+		e.start("pathExpr")
+		e.pathSteps(ex)
+		e.end("pathExpr")
+	case *BinaryExpr:
+		if ex.Op == To {
+			e.start("rangeSequenceExpr")
+			e.operand("startExpr", ex.LHS)
+			e.operand("endExpr", ex.RHS)
+			e.end("rangeSequenceExpr")
+			break
+		}
+		name := op2XQueryX[ex.Op]
+		e.start(name)
+		e.operand("firstOperand", ex.LHS)
+		e.operand("secondOperand", ex.RHS)
+		e.end(name)
+	case *NegateExpr:
+		e.start("unaryMinusOp")
+		e.operand("operand", ex.Expr)
+		e.end("unaryMinusOp")
+	case *UnaryPlusExpr:
+		e.start("unaryPlusOp")
+		e.operand("operand", ex.Expr)
+		e.end("unaryPlusOp")
+	case *VarRef:
+		e.start("varRef")
+		e.qname("name", ex.Prefix, ex.Local)
+		e.end("varRef")
+	case *FuncCall:
+		e.start("functionCallExpr")
+		e.qname("functionName", ex.Prefix, ex.Local)
+		if len(ex.Args) > 0 {
+			e.start("arguments")
+			for _, arg := range ex.Args {
+				e.expr(arg)
+			}
+			e.end("arguments")
+		}
+		e.end("functionCallExpr")
+	case *Number:
+		f := ex.Value
+		var name string
+		switch {
+		case math.IsNaN(f) || math.IsInf(f, 0) || math.Signbit(f):
+			name = "doubleConstantExpr"
+		case f == math.Trunc(f):
+			name = "integerConstantExpr"
+		default:
+			name = "decimalConstantExpr"
+		}
+		e.start(name)
+		e.text("value", xqueryXNumber(f))
+		e.end(name)
+	case *String:
+		e.start("stringConstantExpr")
+		e.text("value", ex.Value)
+		e.end("stringConstantExpr")
+	case *ForExpr:
+		e.start("flworExpr")
+		e.start("forClause")
+		e.start("forClauseItem")
+		e.binding(ex.Prefix, ex.Local)
+		e.operand("forExpr", ex.In)
+		e.end("forClauseItem")
+		e.end("forClause")
+		e.operand("returnClause", ex.Return)
+		e.end("flworExpr")
+	case *QuantifiedExpr:
+		e.start("quantifiedExpr")
+		if ex.Every {
+			e.text("quantifier", "every")
+		} else {
+			e.text("quantifier", "some")
+		}
+		e.start("quantifiedExprInClause")
+		e.binding(ex.Prefix, ex.Local)
+		e.operand("sourceExpr", ex.In)
+		e.end("quantifiedExprInClause")
+		e.operand("predicateExpr", ex.Satisfies)
+		e.end("quantifiedExpr")
+	case *IfExpr:
+		e.start("ifThenElseExpr")
+		e.operand("ifClause", ex.Cond)
+		e.operand("thenClause", ex.Then)
+		e.operand("elseClause", ex.Else)
+		e.end("ifThenElseExpr")
+	case *SequenceExpr:
+		e.start("sequenceExpr")
+		for _, item := range ex.Items {
+			e.expr(item)
+		}
+		e.end("sequenceExpr")
+	case *InstanceOfExpr:
+		e.start("instanceOfExpr")
+		e.operand("argExpr", ex.Expr)
+		e.sequenceType("sequenceType", ex.Type)
+		e.end("instanceOfExpr")
+	case *TreatExpr:
+		e.start("treatExpr")
+		e.operand("argExpr", ex.Expr)
+		e.sequenceType("sequenceType", ex.Type)
+		e.end("treatExpr")
+	case *CastableExpr:
+		e.start("castableExpr")
+		e.operand("argExpr", ex.Expr)
+		e.singleType(ex.Type)
+		e.end("castableExpr")
+	case *CastExpr:
+		e.start("castExpr")
+		e.operand("argExpr", ex.Expr)
+		e.singleType(ex.Type)
+		e.end("castExpr")
+	case *LetExpr:
+		e.start("flworExpr")
+		e.start("letClause")
+		e.start("letClauseItem")
+		e.binding(ex.Prefix, ex.Local)
+		e.operand("letExpr", ex.Expr)
+		e.end("letClauseItem")
+		e.end("letClause")
+		e.operand("returnClause", ex.Return)
+		e.end("flworExpr")
+	case *InlineFunctionExpr:
+		e.start("inlineFunctionExpr")
+		e.start("paramList")
+		for _, param := range ex.Params {
+			e.start("param")
+			e.qname("varName", param.Prefix, param.Local)
+			if param.Type != nil {
+				e.sequenceType("typeDeclaration", param.Type)
+			}
+			e.end("param")
+		}
+		e.end("paramList")
+		if ex.Type != nil {
+			e.sequenceType("typeDeclaration", ex.Type)
+		}
+		e.operand("functionBody", ex.Body)
+		e.end("inlineFunctionExpr")
+	case *NamedFunctionRef:
+		e.start("namedFunctionRef")
+		e.qname("functionName", ex.Prefix, ex.Local)
+		e.start("integerConstantExpr")
+		e.text("value", strconv.Itoa(ex.Arity))
+		e.end("integerConstantExpr")
+		e.end("namedFunctionRef")
+	case *DynamicCallExpr:
+		e.start("dynamicFunctionInvocationExpr")
+		e.operand("functionItem", ex.Func)
+		if len(ex.Args) > 0 {
+			e.start("arguments")
+			for _, arg := range ex.Args {
+				e.expr(arg)
+			}
+			e.end("arguments")
+		}
+		e.end("dynamicFunctionInvocationExpr")
+	case *SimpleMapExpr:
+		e.start("simpleMapExpr")
+		e.simpleMapOperands(ex)
+		e.end("simpleMapExpr")
+	case *MapConstructor:
+		e.start("mapConstructor")
+		for _, entry := range ex.Entries {
+			e.start("mapConstructorEntry")
+			e.operand("mapKeyExpr", entry.Key)
+			e.operand("mapValueExpr", entry.Value)
+			e.end("mapConstructorEntry")
+		}
+		e.end("mapConstructor")
+	case *ArrayConstructor:
+		e.start("arrayConstructor")
+		if ex.Curly {
+			e.start("curlyArray")
+			e.curlyArrayMembers(ex.Members)
+			e.end("curlyArray")
+		} else {
+			e.start("squareArray")
+			for _, member := range ex.Members {
+				e.operand("arrayElem", member)
+			}
+			e.end("squareArray")
+		}
+		e.end("arrayConstructor")
+	case *LookupExpr:
+		if ex.Expr == nil {
+			e.start("unaryLookup")
+			e.lookupKey(ex.Key)
+			e.end("unaryLookup")
+			break
+		}
+		e.start("lookupExpr")
+		e.operand("argExpr", ex.Expr)
+		e.start("lookup")
+		e.lookupKey(ex.Key)
+		e.end("lookup")
+		e.end("lookupExpr")
+	case *ArrowExpr:
+		e.start("arrowExpr")
+		e.operand("argExpr", ex.Expr)
+		var args []Expr
+		switch call := ex.Call.(type) {
+		case *FuncCall:
+			e.qname("functionName", call.Prefix, call.Local)
+			args = call.Args
+		case *DynamicCallExpr:
+			e.operand("functionItem", call.Func)
+			args = call.Args
+		}
+		if len(args) > 0 {
+			e.start("arguments")
+			for _, arg := range args {
+				e.expr(arg)
+			}
+			e.end("arguments")
+		}
+		e.end("arrowExpr")
+	default:
+		panic(fmt.Sprintf("xpathparser: unexpected expr type %T", expr))
+	}
+}
+
+// operand encodes expr wrapped in element with given name.
+func (e *xqueryXEncoder) operand(name string, expr Expr) {
+	e.start(name)
+	e.expr(expr)
+	e.end(name)
+}
+
+// binding encodes typedVariableBinding of variable with given name.
+func (e *xqueryXEncoder) binding(prefix, local string) {
+	e.start("typedVariableBinding")
+	e.qname("varName", prefix, local)
+	e.end("typedVariableBinding")
+}
+
+// simpleMapOperands encodes operands of left nested SimpleMapExpr as
+// siblings.
+func (e *xqueryXEncoder) simpleMapOperands(s *SimpleMapExpr) {
+	if lhs, ok := s.LHS.(*SimpleMapExpr); ok {
+		e.simpleMapOperands(lhs)
+	} else {
+		e.expr(s.LHS)
+	}
+	e.expr(s.RHS)
+}
+
+// curlyArrayMembers encodes the members of curly array constructor as
+// single expression. A single member, which is sequence, is parenthesized
+// to distinguish it from multiple members.
+func (e *xqueryXEncoder) curlyArrayMembers(members []Expr) {
+	switch len(members) {
+	case 0:
+	case 1:
+		if _, ok := members[0].(*SequenceExpr); ok {
+			e.operand("parenthesizedExpr", members[0])
+		} else {
+			e.expr(members[0])
+		}
+	default:
+		e.start("sequenceExpr")
+		for _, member := range members {
+			e.expr(member)
+		}
+		e.end("sequenceExpr")
+	}
+}
+
+// lookupKey encodes key of lookup operator, nil being wildcard.
+func (e *xqueryXEncoder) lookupKey(key Expr) {
+	switch k := key.(type) {
+	case nil:
+		e.empty("star")
+	case *String:
+		if isName([]byte(k.Value)) {
+			e.text("NCName", k.Value)
+			return
+		}
+		e.operand("parenthesizedExpr", key)
+	case *Number:
+		if f := k.Value; isLiteral(k) && f == math.Trunc(f) {
+			e.expr(k)
+			return
+		}
+		e.operand("parenthesizedExpr", key)
+	default:
+		e.operand("parenthesizedExpr", key)
+	}
+}
+
+// sequenceType encodes t as element with given name, which is
+// sequenceType or typeDeclaration.
+func (e *xqueryXEncoder) sequenceType(name string, t *SequenceType) {
+	e.start(name)
+	if t.ItemType == nil {
+		e.empty("voidSequenceType")
+	} else {
+		e.itemType(t.ItemType)
+		if t.Occurrence != ExactlyOne {
+			e.text("occurrenceIndicator", t.Occurrence.String())
+		}
+	}
+	e.end(name)
+}
+
+func (e *xqueryXEncoder) singleType(t *SequenceType) {
+	e.start("singleType")
+	e.itemType(t.ItemType)
+	if t.Occurrence == ZeroOrOne {
+		e.empty("optional")
+	}
+	e.end("singleType")
+}
+
+func (e *xqueryXEncoder) itemType(it ItemType) {
+	switch it := it.(type) {
+	case *AtomicType:
+		e.qname("atomicType", it.Prefix, it.Local)
+	case *AnyItem:
+		e.empty("anyItemType")
+	case NodeTest:
+		e.nodeTest(it)
+	case *FunctionTest:
+		if it.ReturnType == nil {
+			e.empty("anyFunctionTest")
+			return
+		}
+		e.start("typedFunctionTest")
+		if len(it.ParamTypes) > 0 {
+			e.start("paramTypeList")
+			for _, t := range it.ParamTypes {
+				e.sequenceType("sequenceType", t)
+			}
+			e.end("paramTypeList")
+		}
+		e.sequenceType("sequenceType", it.ReturnType)
+		e.end("typedFunctionTest")
+	case *MapTest:
+		if it.KeyType == nil {
+			e.empty("anyMapTest")
+			return
+		}
+		e.start("typedMapTest")
+		e.qname("atomicType", it.KeyType.Prefix, it.KeyType.Local)
+		e.sequenceType("sequenceType", it.ValueType)
+		e.end("typedMapTest")
+	case *ArrayTest:
+		if it.MemberType == nil {
+			e.empty("anyArrayTest")
+			return
+		}
+		e.start("typedArrayTest")
+		e.sequenceType("sequenceType", it.MemberType)
+		e.end("typedArrayTest")
+	default:
+		panic(fmt.Sprintf("xpathparser: unexpected itemType type %T", it))
+	}
+}
+
+func xqueryXNumber(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "INF"
+	case math.IsInf(f, -1):
+		return "-INF"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// pathSteps encodes the steps of path expression. The operands of
+// SlashExpr, that are path expressions, are flattened into the steps.
+func (e *xqueryXEncoder) pathSteps(expr Expr) {
+	switch ex := expr.(type) {
+	case *LocationPath:
+		e.locationPath(ex)
+	case *PathExpr:
+		if _, ok := ex.Filter.(*SlashExpr); ok {
+			e.pathSteps(ex.Filter)
+		} else if _, ok := ex.Filter.(*FilterExpr); ok && len(ex.LocationPath.Steps) == 0 {
+			// filter expression with predicates followed by trailing '/',
+			// as in $a[1]/, is parenthesized, so that the single step is
+			// not decoded as the filter expression itself
+			e.start("stepExpr")
+			e.start("filterExpr")
+			e.primary(ex.Filter)
+			e.end("filterExpr")
+			e.end("stepExpr")
+		} else {
+			e.filterStep(ex.Filter)
+		}
+		e.locationPath(ex.LocationPath)
+	case *SlashExpr:
+		switch ex.LHS.(type) {
+		case *LocationPath, *PathExpr, *SlashExpr:
+			e.pathSteps(ex.LHS)
+		default:
+			e.filterStep(ex.LHS)
+		}
+		e.filterStep(ex.RHS)
+	default:
+		e.filterStep(expr)
+	}
+}
+
+// filterStep encodes stepExpr holding filterExpr.
+func (e *xqueryXEncoder) filterStep(expr Expr) {
+	e.start("stepExpr")
+	e.start("filterExpr")
+	if f, ok := expr.(*FilterExpr); ok {
+		e.primary(f.Expr)
+		e.end("filterExpr")
+		e.predicates(f.Predicates)
+	} else {
+		e.primary(expr)
+		e.end("filterExpr")
+	}
+	e.end("stepExpr")
+}
+
+func (e *xqueryXEncoder) primary(expr Expr) {
+	switch expr.(type) {
+	case *VarRef, *FuncCall, *Number, *String, *NamedFunctionRef, *InlineFunctionExpr, *MapConstructor, *ArrayConstructor:
+		e.expr(expr)
+	default:
+		e.start("parenthesizedExpr")
+		e.expr(expr)
+		e.end("parenthesizedExpr")
+	}
+}
+
+func (e *xqueryXEncoder) locationPath(lp *LocationPath) {
+	if lp.Abs {
+		e.empty("rootExpr")
+	}
+	for _, step := range lp.Steps {
+		e.start("stepExpr")
+		e.text("xpathAxis", step.Axis.String())
+		e.nodeTest(step.NodeTest)
+		e.predicates(step.Predicates)
+		e.end("stepExpr")
+	}
+}
+
+func (e *xqueryXEncoder) nodeTest(nodeTest NodeTest) {
+	switch nt := nodeTest.(type) {
+	case *NameTest:
+		switch {
+		case nt.Prefix == "*":
+			e.start("Wildcard")
+			e.empty("star")
+			e.text("NCName", nt.Local)
+			e.end("Wildcard")
+		case nt.Local != "*":
+			e.qname("nameTest", nt.Prefix, nt.Local)
+		case nt.Prefix == "":
+			e.empty("Wildcard")
+		case isBracedURI(nt.Prefix):
+			e.start("Wildcard")
+			e.text("uri", nt.Prefix[2:len(nt.Prefix)-1])
+			e.empty("star")
+			e.end("Wildcard")
+		default:
+			e.start("Wildcard")
+			e.text("NCName", nt.Prefix)
+			e.empty("star")
+			e.end("Wildcard")
+		}
+	case *NodeTypeTest:
+		switch nt.Type {
+		case Comment:
+			e.empty("commentTest")
+		case Text:
+			e.empty("textTest")
+		default:
+			e.empty("anyKindTest")
+		}
+	case *PITest:
+		e.start("piTest")
+		if nt.Target != "" {
+			e.text("piTarget", nt.Target)
+		}
+		e.end("piTest")
+	case *KindTest:
+		e.kindTest(nt)
+	default:
+		panic(fmt.Sprintf("xpathparser: unexpected nodeTest type %T", nt))
+	}
+}
+
+func (e *xqueryXEncoder) kindTest(kt *KindTest) {
+	switch kt.Test {
+	case DocumentTest:
+		e.start("documentTest")
+		if kt.Element != nil {
+			e.kindTest(kt.Element)
+		}
+		e.end("documentTest")
+	case ElementTest, AttributeTest:
+		name, nameName := "elementTest", "elementName"
+		if kt.Test == AttributeTest {
+			name, nameName = "attributeTest", "attributeName"
+		}
+		e.start(name)
+		if kt.Local != "" {
+			e.start(nameName)
+			if kt.Local == "*" {
+				e.empty("star")
+			} else {
+				e.qname("QName", kt.Prefix, kt.Local)
+			}
+			e.end(nameName)
+			if kt.TypeLocal != "" {
+				e.qname("typeName", kt.TypePrefix, kt.TypeLocal)
+				if kt.Nillable {
+					e.empty("nillable")
+				}
+			}
+		}
+		e.end(name)
+	case SchemaElementTest:
+		e.qname("schemaElementTest", kt.Prefix, kt.Local)
+	case SchemaAttributeTest:
+		e.qname("schemaAttributeTest", kt.Prefix, kt.Local)
+	case NamespaceNodeTest:
+		e.empty("namespaceTest")
+	}
+}
+
+func (e *xqueryXEncoder) predicates(predicates []Expr) {
+	if len(predicates) > 0 {
+		e.start("predicates")
+		for _, predicate := range predicates {
+			e.expr(predicate)
+		}
+		e.end("predicates")
+	}
+}
+
+// DecodeXQueryX decodes expression from XQueryX document. The document
+// element is either a module, whose queryBody is decoded, or the
+// expression element itself. See EncodeXQueryX for details.
+func DecodeXQueryX(data []byte) (Expr, error) {
+	root, err := readXQueryX(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.name == "module" {
+		body := root.child("mainModule").child("queryBody")
+		if body == nil || len(body.children) != 1 {
+			return nil, fmt.Errorf("xpathparser: xqueryx queryBody with single expression expected")
+		}
+		root = body.children[0]
+	}
+	return root.expr()
+}
+
+// xqxElem is an element in XQueryX namespace.
+type xqxElem struct {
+	name     string
+	prefix   string // value of xqx:prefix attribute
+	text     string
+	children []*xqxElem
+}
+
+func readXQueryX(data []byte) (*xqxElem, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*xqxElem
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil, fmt.Errorf("xpathparser: xqueryx document element missing")
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if t.Name.Space != XQueryXNamespace {
+				return nil, fmt.Errorf("xpathparser: unexpected element {%s}%s", t.Name.Space, t.Name.Local)
+			}
+			elem := &xqxElem{name: t.Name.Local}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == XQueryXNamespace {
+					switch attr.Name.Local {
+					case "prefix":
+						elem.prefix = attr.Value
+					case "URI":
+						elem.prefix = "Q{" + attr.Value + "}"
+					}
+				}
+			}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, elem)
+			}
+			stack = append(stack, elem)
+		case xml.EndElement:
+			elem := stack[len(stack)-1]
+			if stack = stack[:len(stack)-1]; len(stack) == 0 {
+				return elem, nil
+			}
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+}
+
+func (e *xqxElem) String() string {
+	return "xqx:" + e.name
+}
+
+// child returns the first child element with given name.
+// It returns nil if not found or e is nil.
+func (e *xqxElem) child(name string) *xqxElem {
+	if e != nil {
+		for _, c := range e.children {
+			if c.name == name {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// operand returns the expression held by child element with given name.
+func (e *xqxElem) operand(name string) (Expr, error) {
+	c := e.child(name)
+	if c == nil || len(c.children) != 1 {
+		return nil, fmt.Errorf("xpathparser: %s with single xqx:%s expected", e, name)
+	}
+	return c.children[0].expr()
+}
+
+func (e *xqxElem) exprs() ([]Expr, error) {
+	var exprs []Expr
+	for _, c := range e.children {
+		expr, err := c.expr()
+		if err != nil {
+			return nil, err
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+func (e *xqxElem) expr() (Expr, error) {
+	if op, ok := xqueryX2Op[e.name]; ok {
+		lhs, err := e.operand("firstOperand")
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := e.operand("secondOperand")
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{LHS: lhs, Op: op, RHS: rhs}, nil
+	}
+	switch e.name {
+	case "pathExpr":
+		return e.pathExpr()
+	case "unaryMinusOp":
+		expr, err := e.operand("operand")
+		if err != nil {
+			return nil, err
+		}
+		return &NegateExpr{Expr: expr}, nil
+	case "unaryPlusOp":
+		expr, err := e.operand("operand")
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryPlusExpr{Expr: expr}, nil
+	case "parenthesizedExpr":
+		if len(e.children) != 1 {
+			return nil, fmt.Errorf("xpathparser: %s with single expression expected", e)
+		}
+		return e.children[0].expr()
+	case "varRef":
+		name := e.child("name")
+		if name == nil {
+			return nil, fmt.Errorf("xpathparser: %s without xqx:name", e)
+		}
+		return &VarRef{Prefix: name.prefix, Local: strings.TrimSpace(name.text)}, nil
+	case "functionCallExpr":
+		name := e.child("functionName")
+		if name == nil {
+			return nil, fmt.Errorf("xpathparser: %s without xqx:functionName", e)
+		}
+		var args []Expr
+		if arguments := e.child("arguments"); arguments != nil {
+			var err error
+			if args, err = arguments.exprs(); err != nil {
+				return nil, err
+			}
+		}
+		return &FuncCall{Prefix: name.prefix, Local: strings.TrimSpace(name.text), Args: args}, nil
+	case "integerConstantExpr", "decimalConstantExpr", "doubleConstantExpr":
+		value := e.child("value")
+		if value == nil {
+			return nil, fmt.Errorf("xpathparser: %s without xqx:value", e)
+		}
+		f, err := strconv.ParseFloat(strings.TrimSpace(value.text), 64)
+		if err != nil {
+			return nil, fmt.Errorf("xpathparser: invalid %s value %q", e, value.text)
+		}
+		return &Number{Value: f}, nil
+	case "stringConstantExpr":
+		value := e.child("value")
+		if value == nil {
+			return nil, fmt.Errorf("xpathparser: %s without xqx:value", e)
+		}
+		return &String{Value: value.text}, nil
+	case "rangeSequenceExpr":
+		lhs, err := e.operand("startExpr")
+		if err != nil {
+			return nil, err
+		}
+		rhs, err := e.operand("endExpr")
+		if err != nil {
+			return nil, err
+		}
+		return &BinaryExpr{LHS: lhs, Op: To, RHS: rhs}, nil
+	case "flworExpr":
+		return e.flworExpr()
+	case "quantifiedExpr":
+		return e.quantifiedExpr()
+	case "ifThenElseExpr":
+		cond, err := e.operand("ifClause")
+		if err != nil {
+			return nil, err
+		}
+		then, err := e.operand("thenClause")
+		if err != nil {
+			return nil, err
+		}
+		els, err := e.operand("elseClause")
+		if err != nil {
+			return nil, err
+		}
+		return &IfExpr{Cond: cond, Then: then, Else: els}, nil
+	case "sequenceExpr":
+		items, err := e.exprs()
+		if err != nil {
+			return nil, err
+		}
+		return &SequenceExpr{Items: items}, nil
+	case "instanceOfExpr", "treatExpr", "castableExpr", "castExpr":
+		expr, err := e.operand("argExpr")
+		if err != nil {
+			return nil, err
+		}
+		var t *SequenceType
+		if e.name == "instanceOfExpr" || e.name == "treatExpr" {
+			t, err = e.child("sequenceType").sequenceType(e)
+		} else {
+			t, err = e.child("singleType").singleType(e)
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch e.name {
+		case "instanceOfExpr":
+			return &InstanceOfExpr{Expr: expr, Type: t}, nil
+		case "treatExpr":
+			return &TreatExpr{Expr: expr, Type: t}, nil
+		case "castableExpr":
+			return &CastableExpr{Expr: expr, Type: t}, nil
+		default:
+			return &CastExpr{Expr: expr, Type: t}, nil
+		}
+	case "inlineFunctionExpr":
+		return e.inlineFunctionExpr()
+	case "namedFunctionRef":
+		name := e.child("functionName")
+		arity := e.child("integerConstantExpr").child("value")
+		if name == nil || arity == nil {
+			return nil, fmt.Errorf("xpathparser: %s with xqx:functionName and xqx:integerConstantExpr expected", e)
+		}
+		n, err := strconv.Atoi(strings.TrimSpace(arity.text))
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("xpathparser: invalid %s arity %q", e, arity.text)
+		}
+		return &NamedFunctionRef{Prefix: name.prefix, Local: strings.TrimSpace(name.text), Arity: n}, nil
+	case "dynamicFunctionInvocationExpr":
+		f, err := e.operand("functionItem")
+		if err != nil {
+			return nil, err
+		}
+		var args []Expr
+		if arguments := e.child("arguments"); arguments != nil {
+			if args, err = arguments.exprs(); err != nil {
+				return nil, err
+			}
+		}
+		return &DynamicCallExpr{Func: f, Args: args}, nil
+	case "simpleMapExpr":
+		if len(e.children) < 2 {
+			return nil, fmt.Errorf("xpathparser: %s with at least two expressions expected", e)
+		}
+		exprs, err := e.exprs()
+		if err != nil {
+			return nil, err
+		}
+		expr := exprs[0]
+		for _, rhs := range exprs[1:] {
+			expr = &SimpleMapExpr{LHS: expr, RHS: rhs}
+		}
+		return expr, nil
+	case "mapConstructor":
+		m := new(MapConstructor)
+		for _, c := range e.children {
+			if c.name != "mapConstructorEntry" {
+				return nil, fmt.Errorf("xpathparser: unexpected element %s in %s", c, e)
+			}
+			key, err := c.operand("mapKeyExpr")
+			if err != nil {
+				return nil, err
+			}
+			value, err := c.operand("mapValueExpr")
+			if err != nil {
+				return nil, err
+			}
+			m.Entries = append(m.Entries, &MapEntry{Key: key, Value: value})
+		}
+		return m, nil
+	case "arrayConstructor":
+		return e.arrayConstructor()
+	case "unaryLookup":
+		key, err := e.lookupKey(e)
+		if err != nil {
+			return nil, err
+		}
+		return &LookupExpr{Key: key}, nil
+	case "lookupExpr":
+		expr, err := e.operand("argExpr")
+		if err != nil {
+			return nil, err
+		}
+		key, err := e.child("lookup").lookupKey(e)
+		if err != nil {
+			return nil, err
+		}
+		return &LookupExpr{Expr: expr, Key: key}, nil
+	case "arrowExpr":
+		return e.arrowExpr()
+	}
+	return nil, fmt.Errorf("xpathparser: unexpected element %s", e)
+}
+
+// flworExpr decodes each variable of the for and let clauses as nested
+// ForExpr and LetExpr.
+func (e *xqxElem) flworExpr() (Expr, error) {
+	n := len(e.children)
+	if n < 2 || e.children[n-1].name != "returnClause" {
+		return nil, fmt.Errorf("xpathparser: %s with xqx:returnClause expected", e)
+	}
+	expr, err := e.operand("returnClause")
+	if err != nil {
+		return nil, err
+	}
+	for i := n - 2; i >= 0; i-- {
+		clause := e.children[i]
+		if clause.name != "forClause" && clause.name != "letClause" {
+			return nil, fmt.Errorf("xpathparser: unexpected element %s", clause)
+		}
+		for j := len(clause.children) - 1; j >= 0; j-- {
+			item := clause.children[j]
+			name, err := item.varName()
+			if err != nil {
+				return nil, err
+			}
+			if clause.name == "letClause" {
+				value, err := item.operand("letExpr")
+				if err != nil {
+					return nil, err
+				}
+				expr = &LetExpr{Prefix: name.prefix, Local: strings.TrimSpace(name.text), Expr: value, Return: expr}
+				continue
+			}
+			in, err := item.operand("forExpr")
+			if err != nil {
+				return nil, err
+			}
+			expr = &ForExpr{Prefix: name.prefix, Local: strings.TrimSpace(name.text), In: in, Return: expr}
+		}
+	}
+	return expr, nil
+}
+
+// quantifiedExpr decodes each variable of quantifiedExpr as nested
+// QuantifiedExpr.
+func (e *xqxElem) quantifiedExpr() (Expr, error) {
+	quantifier := e.child("quantifier")
+	if quantifier == nil {
+		return nil, fmt.Errorf("xpathparser: %s without xqx:quantifier", e)
+	}
+	var every bool
+	switch q := strings.TrimSpace(quantifier.text); q {
+	case "some":
+	case "every":
+		every = true
+	default:
+		return nil, fmt.Errorf("xpathparser: invalid xqx:quantifier %q", q)
+	}
+	expr, err := e.operand("predicateExpr")
+	if err != nil {
+		return nil, err
+	}
+	clauses := 0
+	for i := len(e.children) - 1; i >= 0; i-- {
+		clause := e.children[i]
+		if clause.name != "quantifiedExprInClause" {
+			continue
+		}
+		clauses++
+		name, err := clause.varName()
+		if err != nil {
+			return nil, err
+		}
+		in, err := clause.operand("sourceExpr")
+		if err != nil {
+			return nil, err
+		}
+		expr = &QuantifiedExpr{Every: every, Prefix: name.prefix, Local: strings.TrimSpace(name.text), In: in, Satisfies: expr}
+	}
+	if clauses == 0 {
+		return nil, fmt.Errorf("xpathparser: %s without xqx:quantifiedExprInClause", e)
+	}
+	return expr, nil
+}
+
+func (e *xqxElem) inlineFunctionExpr() (Expr, error) {
+	paramList := e.child("paramList")
+	if paramList == nil {
+		return nil, fmt.Errorf("xpathparser: %s without xqx:paramList", e)
+	}
+	f := new(InlineFunctionExpr)
+	for _, p := range paramList.children {
+		name := p.child("varName")
+		if p.name != "param" || name == nil {
+			return nil, fmt.Errorf("xpathparser: %s with xqx:param expected", paramList)
+		}
+		param := &Param{Prefix: name.prefix, Local: strings.TrimSpace(name.text)}
+		if typeDecl := p.child("typeDeclaration"); typeDecl != nil {
+			var err error
+			if param.Type, err = typeDecl.sequenceType(p); err != nil {
+				return nil, err
+			}
+		}
+		f.Params = append(f.Params, param)
+	}
+	if typeDecl := e.child("typeDeclaration"); typeDecl != nil {
+		var err error
+		if f.Type, err = typeDecl.sequenceType(e); err != nil {
+			return nil, err
+		}
+	}
+	body, err := e.operand("functionBody")
+	if err != nil {
+		return nil, err
+	}
+	f.Body = body
+	return f, nil
+}
+
+func (e *xqxElem) arrayConstructor() (Expr, error) {
+	if len(e.children) != 1 {
+		return nil, fmt.Errorf("xpathparser: %s with xqx:squareArray or xqx:curlyArray expected", e)
+	}
+	a := e.children[0]
+	switch a.name {
+	case "squareArray":
+		var members []Expr
+		for _, c := range a.children {
+			if c.name != "arrayElem" || len(c.children) != 1 {
+				return nil, fmt.Errorf("xpathparser: %s with xqx:arrayElem holding single expression expected", a)
+			}
+			member, err := c.children[0].expr()
+			if err != nil {
+				return nil, err
+			}
+			members = append(members, member)
+		}
+		return &ArrayConstructor{Members: members}, nil
+	case "curlyArray":
+		switch {
+		case len(a.children) == 0:
+			return &ArrayConstructor{Curly: true}, nil
+		case len(a.children) > 1:
+			return nil, fmt.Errorf("xpathparser: %s with single expression expected", a)
+		case a.children[0].name == "sequenceExpr":
+			members, err := a.children[0].exprs()
+			if err != nil {
+				return nil, err
+			}
+			return &ArrayConstructor{Curly: true, Members: members}, nil
+		}
+		member, err := a.children[0].expr()
+		if err != nil {
+			return nil, err
+		}
+		return &ArrayConstructor{Curly: true, Members: []Expr{member}}, nil
+	}
+	return nil, fmt.Errorf("xpathparser: unexpected element %s in %s", a, e)
+}
+
+// lookupKey decodes the key held by e, which is the lookup element of
+// parent or parent itself.
+func (e *xqxElem) lookupKey(parent *xqxElem) (Expr, error) {
+	if e == nil || len(e.children) != 1 {
+		return nil, fmt.Errorf("xpathparser: %s with single lookup key expected", parent)
+	}
+	switch k := e.children[0]; k.name {
+	case "star":
+		return nil, nil
+	case "NCName":
+		return &String{Value: strings.TrimSpace(k.text)}, nil
+	case "integerConstantExpr", "parenthesizedExpr":
+		return k.expr()
+	default:
+		return nil, fmt.Errorf("xpathparser: unexpected lookup key %s", k)
+	}
+}
+
+func (e *xqxElem) arrowExpr() (Expr, error) {
+	expr, err := e.operand("argExpr")
+	if err != nil {
+		return nil, err
+	}
+	var args []Expr
+	if arguments := e.child("arguments"); arguments != nil {
+		if args, err = arguments.exprs(); err != nil {
+			return nil, err
+		}
+	}
+	if name := e.child("functionName"); name != nil {
+		call := &FuncCall{Prefix: name.prefix, Local: strings.TrimSpace(name.text), Args: args}
+		return &ArrowExpr{Expr: expr, Call: call}, nil
+	}
+	f, err := e.operand("functionItem")
+	if err != nil {
+		return nil, err
+	}
+	return &ArrowExpr{Expr: expr, Call: &DynamicCallExpr{Func: f, Args: args}}, nil
+}
+
+// varName returns the varName of typedVariableBinding child.
+func (e *xqxElem) varName() (*xqxElem, error) {
+	name := e.child("typedVariableBinding").child("varName")
+	if name == nil {
+		return nil, fmt.Errorf("xpathparser: %s without xqx:typedVariableBinding", e)
+	}
+	return name, nil
+}
+
+// pathExpr decodes the steps of path expression. A filter step following
+// other steps is decoded as RHS of SlashExpr, and the location steps
+// following a filter step as LocationPath of PathExpr.
+func (e *xqxElem) pathExpr() (Expr, error) {
+	children := e.children
+	var expr Expr
+	var lp *LocationPath // location path, to which location steps are appended
+	if len(children) > 0 && children[0].name == "rootExpr" {
+		lp = &LocationPath{Abs: true}
+		expr = lp
+		children = children[1:]
+	}
+
+	for i, c := range children {
+		if f := c.child("filterExpr"); f != nil {
+			if len(f.children) != 1 {
+				return nil, fmt.Errorf("xpathparser: %s with single expression expected", f)
+			}
+			filter, err := f.children[0].expr()
+			if err != nil {
+				return nil, err
+			}
+			predicates, err := c.predicates()
+			if err != nil {
+				return nil, err
+			}
+			if len(predicates) > 0 {
+				filter = &FilterExpr{Expr: filter, Predicates: predicates}
+			}
+			switch {
+			case expr != nil:
+				expr = &SlashExpr{LHS: expr, RHS: filter}
+			case len(children) == 1 && len(predicates) == 0:
+				return &PathExpr{Filter: filter, LocationPath: new(LocationPath)}, nil
+			default:
+				expr = filter
+			}
+			lp = nil
+			continue
+		}
+		step, err := c.step()
+		if err != nil {
+			return nil, err
+		}
+		if lp == nil {
+			lp = new(LocationPath)
+			if i == 0 {
+				expr = lp
+			} else {
+				expr = &PathExpr{Filter: expr, LocationPath: lp}
+			}
+		}
+		lp.Steps = append(lp.Steps, step)
+	}
+	if expr == nil {
+		return new(LocationPath), nil
+	}
+	return expr, nil
+}
+
+func (e *xqxElem) step() (*Step, error) {
+	if e.name != "stepExpr" || len(e.children) < 2 || e.children[0].name != "xpathAxis" {
+		return nil, fmt.Errorf("xpathparser: %s with xqx:xpathAxis expected", e)
+	}
+	axisName := strings.TrimSpace(e.children[0].text)
+	axis, ok := name2Axis[axisName]
+	if !ok {
+		return nil, fmt.Errorf("xpathparser: invalid xqx:xpathAxis %q", axisName)
+	}
+	nodeTest, err := e.children[1].nodeTest()
+	if err != nil {
+		return nil, err
+	}
+	predicates, err := e.predicates()
+	if err != nil {
+		return nil, err
+	}
+	return &Step{Axis: axis, NodeTest: nodeTest, Predicates: predicates}, nil
+}
+
+func (e *xqxElem) nodeTest() (NodeTest, error) {
+	switch e.name {
+	case "nameTest":
+		return &NameTest{Prefix: e.prefix, Local: strings.TrimSpace(e.text)}, nil
+	case "Wildcard":
+		if len(e.children) == 2 && e.children[0].name == "star" && e.children[1].name == "NCName" {
+			// *:local
+			return &NameTest{Prefix: "*", Local: strings.TrimSpace(e.children[1].text)}, nil
+		}
+		prefix := ""
+		if ncname := e.child("NCName"); ncname != nil {
+			prefix = strings.TrimSpace(ncname.text)
+		} else if uri := e.child("uri"); uri != nil {
+			prefix = "Q{" + uri.text + "}"
+		}
+		return &NameTest{Prefix: prefix, Local: "*"}, nil
+	case "anyKindTest":
+		return &NodeTypeTest{Type: Node}, nil
+	case "textTest":
+		return &NodeTypeTest{Type: Text}, nil
+	case "commentTest":
+		return &NodeTypeTest{Type: Comment}, nil
+	case "piTest":
+		target := ""
+		if t := e.child("piTarget"); t != nil {
+			target = strings.TrimSpace(t.text)
+		}
+		return &PITest{Target: target}, nil
+	case "documentTest", "elementTest", "attributeTest", "schemaElementTest", "schemaAttributeTest", "namespaceTest":
+		kindTest, err := e.kindTest()
+		if err != nil {
+			return nil, err
+		}
+		return kindTest, nil
+	}
+	return nil, fmt.Errorf("xpathparser: unexpected node test %s", e)
+}
+
+func (e *xqxElem) kindTest() (*KindTest, error) {
+	kindTest := new(KindTest)
+	switch e.name {
+	case "documentTest":
+		kindTest.Test = DocumentTest
+		if len(e.children) > 0 {
+			c := e.children[0]
+			if c.name != "elementTest" && c.name != "schemaElementTest" {
+				return nil, fmt.Errorf("xpathparser: unexpected element %s in %s", c, e)
+			}
+			element, err := c.kindTest()
+			if err != nil {
+				return nil, err
+			}
+			kindTest.Element = element
+		}
+	case "elementTest", "attributeTest":
+		nameName := "elementName"
+		if kindTest.Test = ElementTest; e.name == "attributeTest" {
+			kindTest.Test, nameName = AttributeTest, "attributeName"
+		}
+		if name := e.child(nameName); name != nil {
+			if qname := name.child("QName"); qname != nil {
+				kindTest.Prefix, kindTest.Local = qname.prefix, strings.TrimSpace(qname.text)
+			} else if name.child("star") != nil {
+				kindTest.Local = "*"
+			} else {
+				return nil, fmt.Errorf("xpathparser: %s without xqx:QName or xqx:star", name)
+			}
+		}
+		if typeName := e.child("typeName"); typeName != nil {
+			kindTest.TypePrefix, kindTest.TypeLocal = typeName.prefix, strings.TrimSpace(typeName.text)
+			kindTest.Nillable = e.child("nillable") != nil
+		}
+	case "namespaceTest":
+		kindTest.Test = NamespaceNodeTest
+	default:
+		kindTest.Test = SchemaElementTest
+		if e.name == "schemaAttributeTest" {
+			kindTest.Test = SchemaAttributeTest
+		}
+		kindTest.Prefix, kindTest.Local = e.prefix, strings.TrimSpace(e.text)
+	}
+	return kindTest, nil
+}
+
+// sequenceType decodes sequenceType or typeDeclaration element of parent.
+func (e *xqxElem) sequenceType(parent *xqxElem) (*SequenceType, error) {
+	if e == nil || len(e.children) == 0 {
+		return nil, fmt.Errorf("xpathparser: %s without xqx:sequenceType", parent)
+	}
+	if e.children[0].name == "voidSequenceType" {
+		return &SequenceType{}, nil
+	}
+	itemType, err := e.children[0].itemType()
+	if err != nil {
+		return nil, err
+	}
+	t := &SequenceType{ItemType: itemType}
+	if indicator := e.child("occurrenceIndicator"); indicator != nil {
+		found := false
+		for i, name := range occurrenceNames {
+			if name != "" && name == strings.TrimSpace(indicator.text) {
+				t.Occurrence, found = Occurrence(i), true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("xpathparser: invalid xqx:occurrenceIndicator %q", indicator.text)
+		}
+	}
+	return t, nil
+}
+
+// singleType decodes singleType element of parent.
+func (e *xqxElem) singleType(parent *xqxElem) (*SequenceType, error) {
+	atomicType := e.child("atomicType")
+	if atomicType == nil {
+		return nil, fmt.Errorf("xpathparser: %s without xqx:singleType", parent)
+	}
+	t := &SequenceType{ItemType: &AtomicType{Prefix: atomicType.prefix, Local: strings.TrimSpace(atomicType.text)}}
+	if e.child("optional") != nil {
+		t.Occurrence = ZeroOrOne
+	}
+	return t, nil
+}
+
+func (e *xqxElem) itemType() (ItemType, error) {
+	switch e.name {
+	case "atomicType":
+		return &AtomicType{Prefix: e.prefix, Local: strings.TrimSpace(e.text)}, nil
+	case "anyItemType":
+		return &AnyItem{}, nil
+	case "anyFunctionTest":
+		return &FunctionTest{}, nil
+	case "typedFunctionTest":
+		return e.typedFunctionTest()
+	case "anyMapTest":
+		return &MapTest{}, nil
+	case "typedMapTest":
+		atomicType := e.child("atomicType")
+		if atomicType == nil {
+			return nil, fmt.Errorf("xpathparser: %s without xqx:atomicType", e)
+		}
+		t, err := e.child("sequenceType").sequenceType(e)
+		if err != nil {
+			return nil, err
+		}
+		return &MapTest{KeyType: &AtomicType{Prefix: atomicType.prefix, Local: strings.TrimSpace(atomicType.text)}, ValueType: t}, nil
+	case "anyArrayTest":
+		return &ArrayTest{}, nil
+	case "typedArrayTest":
+		t, err := e.child("sequenceType").sequenceType(e)
+		if err != nil {
+			return nil, err
+		}
+		return &ArrayTest{MemberType: t}, nil
+	case "parenthesizedItemType":
+		if len(e.children) == 0 {
+			return nil, fmt.Errorf("xpathparser: %s without item type", e)
+		}
+		return e.children[0].itemType()
+	case "nameTest", "Wildcard":
+		return nil, fmt.Errorf("xpathparser: unexpected item type %s", e)
+	}
+	nodeTest, err := e.nodeTest()
+	if err != nil {
+		return nil, err
+	}
+	return nodeTest.(ItemType), nil
+}
+
+func (e *xqxElem) typedFunctionTest() (ItemType, error) {
+	functionTest := new(FunctionTest)
+	if list := e.child("paramTypeList"); list != nil {
+		for _, c := range list.children {
+			t, err := c.sequenceType(list)
+			if err != nil {
+				return nil, err
+			}
+			functionTest.ParamTypes = append(functionTest.ParamTypes, t)
+		}
+	}
+	t, err := e.child("sequenceType").sequenceType(e)
+	if err != nil {
+		return nil, err
+	}
+	functionTest.ReturnType = t
+	return functionTest, nil
+}
+
+func (e *xqxElem) predicates() ([]Expr, error) {
+	if p := e.child("predicates"); p != nil {
+		return p.exprs()
+	}
+	return nil, nil
+}
@@ -0,0 +1,141 @@
+// Copyright 2017 Santhosh Kumar Tekuri. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xpathparser_test
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/santhosh-tekuri/xpathparser"
+)
+
+func TestXQueryX(t *testing.T) {
+	tests := roundTrips()
+	for _, xpath := range []string{`"  spaces  "`, `'<&>'`, `ns:*`, `$x[1][2]/a`} {
+		tests = append(tests, roundTrip{xpath, XPath10, MustParse(xpath)})
+	}
+	for _, test := range tests {
+		b, err := EncodeXQueryX(test.expr)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v", test.xpath, err)
+			continue
+		}
+		got, err := DecodeXQueryX(b)
+		if err != nil {
+			t.Errorf("FAIL: %s: %v\n%s", test.xpath, err, b)
+			continue
+		}
+		if !Equal(test.expr, got) {
+			t.Errorf("FAIL: %s: decoded to %v\n%s", test.xpath, got, b)
+		}
+	}
+}
+
+func TestXQueryXDocument(t *testing.T) {
+	b, err := EncodeXQueryX(MustParse(`/a[@b = 1] | $x`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `<xqx:module xmlns:xqx="http://www.w3.org/2005/XQueryX">
+  <xqx:mainModule>
+    <xqx:queryBody>
+      <xqx:unionOp>
+        <xqx:firstOperand>
+          <xqx:pathExpr>
+            <xqx:rootExpr></xqx:rootExpr>
+            <xqx:stepExpr>
+              <xqx:xpathAxis>child</xqx:xpathAxis>
+              <xqx:nameTest>a</xqx:nameTest>
+              <xqx:predicates>
+                <xqx:equalOp>
+                  <xqx:firstOperand>
+                    <xqx:pathExpr>
+                      <xqx:stepExpr>
+                        <xqx:xpathAxis>attribute</xqx:xpathAxis>
+                        <xqx:nameTest>b</xqx:nameTest>
+                      </xqx:stepExpr>
+                    </xqx:pathExpr>
+                  </xqx:firstOperand>
+                  <xqx:secondOperand>
+                    <xqx:integerConstantExpr>
+                      <xqx:value>1</xqx:value>
+                    </xqx:integerConstantExpr>
+                  </xqx:secondOperand>
+                </xqx:equalOp>
+              </xqx:predicates>
+            </xqx:stepExpr>
+          </xqx:pathExpr>
+        </xqx:firstOperand>
+        <xqx:secondOperand>
+          <xqx:varRef>
+            <xqx:name>x</xqx:name>
+          </xqx:varRef>
+        </xqx:secondOperand>
+      </xqx:unionOp>
+    </xqx:queryBody>
+  </xqx:mainModule>
+</xqx:module>`
+	if string(b) != want {
+		t.Errorf("FAIL: got\n%s", b)
+	}
+
+	expr, err := (&ParseOptions{Version: XPath30}).Parse(`Q{http://x}f() | Q{http://x}*`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err = EncodeXQueryX(expr); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{`<xqx:functionName xqx:URI="http://x">f</xqx:functionName>`, `<xqx:uri>http://x</xqx:uri>`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("FAIL: %s missing in\n%s", want, b)
+		}
+	}
+}
+
+func TestXQueryXChars(t *testing.T) {
+	for _, s := range []string{"\t\r\n", "a\r\nb", "\uFFFD", "\U0001F600"} {
+		expr := &String{Value: s}
+		b, err := EncodeXQueryX(expr)
+		if err != nil {
+			t.Errorf("FAIL: %q: %v", s, err)
+			continue
+		}
+		if got, err := DecodeXQueryX(b); err != nil || !Equal(got, expr) {
+			t.Errorf("FAIL: %q: decoded to %v, %v", s, got, err)
+		}
+	}
+	invalid := []Expr{
+		&String{Value: "a\x00b"},
+		&String{Value: "\x1b"},
+		&String{Value: "\uFFFE"},
+		&String{Value: "\xff"},
+		&FuncCall{Local: "f", Args: []Expr{&String{Value: "\x00"}}},
+		&VarRef{Prefix: "\x00", Local: "a"},
+	}
+	for _, expr := range invalid {
+		if b, err := EncodeXQueryX(expr); err == nil {
+			t.Errorf("FAIL: %v: error expected, got\n%s", expr, b)
+		}
+	}
+}
+
+func TestInvalidXQueryX(t *testing.T) {
+	tests := []string{
+		``,
+		`<a/>`,
+		`<xqx:foo xmlns:xqx="http://www.w3.org/2005/XQueryX"/>`,
+		`<xqx:addOp xmlns:xqx="http://www.w3.org/2005/XQueryX"><xqx:firstOperand/></xqx:addOp>`,
+		`<xqx:pathExpr xmlns:xqx="http://www.w3.org/2005/XQueryX"><xqx:stepExpr><xqx:xpathAxis>up</xqx:xpathAxis><xqx:anyKindTest/></xqx:stepExpr></xqx:pathExpr>`,
+		`<xqx:integerConstantExpr xmlns:xqx="http://www.w3.org/2005/XQueryX"><xqx:value>one</xqx:value></xqx:integerConstantExpr>`,
+		`<xqx:castExpr xmlns:xqx="http://www.w3.org/2005/XQueryX"><xqx:argExpr><xqx:varRef><xqx:name>x</xqx:name></xqx:varRef></xqx:argExpr></xqx:castExpr>`,
+		`<xqx:treatExpr xmlns:xqx="http://www.w3.org/2005/XQueryX"><xqx:argExpr><xqx:varRef><xqx:name>x</xqx:name></xqx:varRef></xqx:argExpr><xqx:sequenceType><xqx:nameTest>a</xqx:nameTest></xqx:sequenceType></xqx:treatExpr>`,
+	}
+	for _, test := range tests {
+		if expr, err := DecodeXQueryX([]byte(test)); err == nil {
+			t.Errorf("FAIL: %s: error expected, got %v", test, expr)
+		}
+	}
+}